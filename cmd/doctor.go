@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"mimo/internal/decompress"
+	"mimo/internal/doctor"
+	"mimo/internal/run"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check whether this host is ready for an update",
+	Long:  "检查主机环境（权限、系统版本、架构、依赖命令、磁盘空间、hugepages、IOMMU、内核模块、MIMO 运行状态），给出每项的修复建议",
+	Args:  cobra.NoArgs,
+	// 检查失败不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b := decompress.Embedded()
+		if file, _ := cmd.Flags().GetString("bundle"); file != "" {
+			var err error
+			if b, err = decompress.Open(file); err != nil {
+				return err
+			}
+		}
+		staging, _ := cmd.Flags().GetString("staging-dir")
+		if staging == "" {
+			staging = os.Getenv(run.EnvStagingDir)
+		}
+		if staging == "" {
+			staging = os.TempDir()
+		}
+
+		rs := doctor.Run(doctor.Input{Bundle: b, StagingDir: staging})
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			data, err := json.MarshalIndent(rs, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			doctor.Print(os.Stdout, rs, false)
+		}
+		if failed := doctor.Failed(rs); len(failed) > 0 {
+			return &run.ExitError{Code: run.ExitFailed, Err: fmt.Errorf("%d check(s) failed", len(failed))}
+		}
+		return nil
+	},
+}
+
+func init() {
+	doctorCmd.Flags().Bool("json", false, "以 JSON 格式输出")
+	doctorCmd.Flags().String("bundle", "", "按外部资源包检查（- 表示标准输入），默认使用内嵌资源包")
+	doctorCmd.Flags().String("staging-dir", "", "解压资源包的目录（环境变量 "+run.EnvStagingDir+"）")
+	RootCmd.AddCommand(doctorCmd)
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"mimo/internal/history"
+	"mimo/internal/run"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var updateHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List past updates",
+	Long:  "列出 " + history.DefaultPath + " 中记录的更新（最新在后）",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, skipped, err := run.History()
+		if err != nil {
+			return err
+		}
+		if n, _ := cmd.Flags().GetInt("last"); n > 0 && len(entries) > n {
+			entries = entries[len(entries)-n:]
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			if entries == nil {
+				entries = []history.Entry{}
+			}
+			data, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if skipped > 0 {
+			fmt.Printf("WARN: skipped %d unreadable line(s) in %s\n", skipped, history.DefaultPath)
+		}
+		if len(entries) == 0 {
+			fmt.Println("no updates recorded")
+			return nil
+		}
+		fmt.Printf("%-24s %-19s %-15s %-16s %-27s %s\n", "ID", "STARTED", "KIND", "USER", "VERSION", "OUTCOME")
+		for _, e := range entries {
+			fmt.Printf("%-24s %-19s %-15s %-16s %-27s %s\n",
+				e.ID, localTime(e.Started), e.Kind, e.User, versionChange(e), e.Outcome)
+		}
+		return nil
+	},
+}
+
+var updateShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show details of a past update",
+	Long:  "显示一次更新的完整记录，<id> 可为唯一前缀",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, _, err := run.History()
+		if err != nil {
+			return err
+		}
+		e, err := history.Find(entries, args[0])
+		if err != nil {
+			return err
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			data, err := json.MarshalIndent(e, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		fmt.Printf("ID          : %s\n", e.ID)
+		fmt.Printf("Kind        : %s\n", e.Kind)
+		fmt.Printf("Outcome     : %s\n", e.Outcome)
+		fmt.Printf("Started     : %s\n", localTime(e.Started))
+		fmt.Printf("Finished    : %s (%s)\n", localTime(e.Finished), e.Finished.Sub(e.Started).Round(time.Second))
+		fmt.Printf("User        : %s\n", e.User)
+		if e.Host != "" {
+			fmt.Printf("Host        : %s\n", e.Host)
+		}
+		if len(e.Command) > 0 {
+			fmt.Printf("Command     : %s\n", strings.Join(e.Command, " "))
+		}
+		fmt.Printf("Version     : %s\n", versionChange(*e))
+		if e.Bundle != "" {
+			fmt.Printf("Bundle      : %s\n", e.Bundle)
+			fmt.Printf("Bundle hash : %s\n", e.BundleSHA256)
+		}
+		if e.Transaction != "" {
+			fmt.Printf("Transaction : %s\n", e.Transaction)
+		}
+		if e.Error != "" {
+			fmt.Printf("Error       : %s\n", e.Error)
+		}
+		if e.RollbackError != "" {
+			fmt.Printf("Rollback    : %s\n", e.RollbackError)
+		}
+		if len(e.Actions) > 0 {
+			fmt.Println("Actions:")
+			for _, a := range e.Actions {
+				fmt.Printf("  %-11s %s\n", a.Status, a.Name)
+				if a.Error != "" {
+					fmt.Printf("  %-11s   %s\n", "", a.Error)
+				}
+			}
+		}
+		return nil
+	},
+}
+
+func localTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// versionChange 格式化 "旧版本 -> 新版本"
+func versionChange(e history.Entry) string {
+	old, next := e.OldVersion, e.NewVersion
+	if old == "" {
+		old = "?"
+	}
+	if next == "" {
+		next = "?"
+	}
+	return old + " -> " + next
+}
+
+func init() {
+	updateHistoryCmd.Flags().Bool("json", false, "以 JSON 格式输出")
+	updateHistoryCmd.Flags().IntP("last", "n", 0, "只显示最近 N 次更新")
+	updateShowCmd.Flags().Bool("json", false, "以 JSON 格式输出")
+	updateCmd.AddCommand(updateHistoryCmd)
+	updateCmd.AddCommand(updateShowCmd)
+}
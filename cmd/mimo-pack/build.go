@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"mimo/internal/pack"
+
+	"github.com/spf13/cobra"
+)
+
+var buildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Build the resources bundle and the versioned binary",
+	Long: `读取 config.json，检查每个映射的源文件是否存在，生成可复现的资源包（含 manifest.json），
+签名后输出独立资源包并编译带版本号的可执行文件。
+
+指定 --base 时生成只包含变化文件的增量包 bin/mimo-bundle-<version>-delta-<base>.tar，
+只能安装在基础版本之上。
+
+归档中的文件时间取自环境变量 SOURCE_DATE_EPOCH（默认 0），相同输入总是得到相同的资源包。`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := pack.DefaultOptions()
+		opts.Root, _ = cmd.Flags().GetString("root")
+		opts.Config, _ = cmd.Flags().GetString("config")
+		opts.SrcDir, _ = cmd.Flags().GetString("src")
+		opts.OutDir, _ = cmd.Flags().GetString("out")
+		opts.BinDir, _ = cmd.Flags().GetString("bin")
+		opts.SigningKey, _ = cmd.Flags().GetString("key")
+		opts.Base, _ = cmd.Flags().GetString("base")
+		noBinary, _ := cmd.Flags().GetBool("no-binary")
+		opts.Binary = !noBinary
+
+		epoch, err := pack.EpochFromEnv()
+		if err != nil {
+			return err
+		}
+		opts.Epoch = epoch
+
+		res, err := pack.Build(opts)
+		if err != nil {
+			return err
+		}
+
+		signed := "unsigned"
+		if res.Signed {
+			signed = res.Signature
+		}
+		fmt.Println("打包与编译完成！")
+		fmt.Println("版本号        :", res.Version)
+		if res.Base != "" {
+			fmt.Println("增量基础版本  :", res.Base)
+		}
+		fmt.Printf("文件          : %d (%d bytes)\n", res.Files, res.Size)
+		fmt.Println("资源压缩包    :", res.Archive)
+		fmt.Println("SHA256        :", res.Digest)
+		fmt.Println("签名文件      :", signed)
+		fmt.Println("独立资源包    :", res.Bundle)
+		if res.Binary != "" {
+			fmt.Println("Go 可执行文件 :", res.Binary)
+		}
+		return nil
+	},
+}
+
+func init() {
+	def := pack.DefaultOptions()
+	buildCmd.Flags().String("root", def.Root, "项目根目录")
+	buildCmd.Flags().String("config", def.Config, "配置文件")
+	buildCmd.Flags().String("src", def.SrcDir, "资源目录")
+	buildCmd.Flags().String("out", def.OutDir, "资源包输出目录")
+	buildCmd.Flags().String("bin", def.BinDir, "可执行文件与独立资源包输出目录")
+	buildCmd.Flags().String("key", os.Getenv("MIMO_SIGNING_KEY"), "签名私钥（默认取环境变量 MIMO_SIGNING_KEY，为空时生成未签名的包）")
+	buildCmd.Flags().String("base", "", "构建增量包：基础版本的 manifest.json 或独立资源包（不替换内嵌资源、不编译）")
+	buildCmd.Flags().Bool("no-binary", false, "只生成资源包，不编译可执行文件")
+
+	rootCmd.AddCommand(buildCmd)
+}
@@ -0,0 +1,24 @@
+// mimo-pack 是构建主机上使用的打包工具：构建资源包与可执行文件、生成签名密钥、对资源包签名。
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var rootCmd = &cobra.Command{
+	Use:           "mimo-pack",
+	Short:         "MIMO bundle packaging tool",
+	Long:          "MIMO 资源包打包工具：构建资源包、生成签名密钥并对资源包签名。",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"mimo/internal/fileops"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate-config",
+	Short: "Convert config.json to the current schema",
+	Long: `将旧格式（无 schema 字段、version 为数组）的 config.json 转换为当前格式并写回。
+mimo 在加载时会自动迁移旧格式，此命令只是让源码中的配置文件与新格式保持一致。`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("config")
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			out = path
+		}
+
+		cfg, err := fileops.LoadConfig(path)
+		if err != nil {
+			return err
+		}
+		if cfg.Schema == fileops.ConfigSchema && out == path {
+			fmt.Printf("INFO: %s already uses schema %d\n", path, fileops.ConfigSchema)
+			return nil
+		}
+		data, err := cfg.Marshal()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(out, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("INFO: wrote %s (schema %d)\n", out, fileops.ConfigSchema)
+		return nil
+	},
+}
+
+func init() {
+	migrateCmd.Flags().String("config", "config.json", "待转换的配置文件")
+	migrateCmd.Flags().String("out", "", "输出文件（默认覆盖原文件）")
+	rootCmd.AddCommand(migrateCmd)
+}
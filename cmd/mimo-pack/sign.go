@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"mimo/internal/fileops"
+	"mimo/internal/pack"
+	"mimo/internal/signature"
+
+	"github.com/spf13/cobra"
+)
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate an ed25519 signing key pair",
+	Long:  "生成 ed25519 签名密钥对；公钥需安装到节点的 " + signature.DefaultTrustedDir + "/",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("out")
+		name, _ := cmd.Flags().GetString("name")
+
+		privPath := filepath.Join(out, name+".key")
+		pubPath := filepath.Join(out, name+".pub")
+		if _, err := os.Stat(privPath); err == nil {
+			return fmt.Errorf("%s already exists", privPath)
+		}
+
+		pub, priv, err := signature.GenerateKey()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(out, 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(privPath, priv, 0600); err != nil {
+			return err
+		}
+		if err := os.WriteFile(pubPath, pub, 0644); err != nil {
+			return err
+		}
+		key, _ := signature.ParsePublicKey(pub)
+		fmt.Printf("INFO: private key: %s (keep it secret)\n", privPath)
+		fmt.Printf("INFO: public key : %s (id %s)\n", pubPath, signature.KeyID(key))
+		return nil
+	},
+}
+
+var signCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Sign a bundle archive",
+	Long:  "使用私钥对资源包（resources.tar.gz）签名，输出签名文件",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyPath, _ := cmd.Flags().GetString("key")
+		in, _ := cmd.Flags().GetString("in")
+		out, _ := cmd.Flags().GetString("out")
+
+		sig, err := signFile(keyPath, in)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(out, sig, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("INFO: signature written to %s\n", out)
+		return nil
+	},
+}
+
+// signFile 计算 path 的 SHA-256 并用 keyPath 中的私钥签名
+func signFile(keyPath, path string) ([]byte, error) {
+	digest, err := fileops.FileDigest(path)
+	if err != nil {
+		return nil, fmt.Errorf("hash %s: %w", path, err)
+	}
+	return pack.SignDigest(keyPath, digest)
+}
+
+func init() {
+	keygenCmd.Flags().String("out", ".", "密钥输出目录")
+	keygenCmd.Flags().String("name", "mimo-signing", "密钥文件名（不含扩展名）")
+
+	signCmd.Flags().String("key", os.Getenv("MIMO_SIGNING_KEY"), "私钥文件（默认取环境变量 MIMO_SIGNING_KEY）")
+	signCmd.Flags().String("in", "resources/resources.tar.gz", "待签名的资源包")
+	signCmd.Flags().String("out", "resources/resources.sig", "签名输出文件")
+
+	rootCmd.AddCommand(keygenCmd)
+	rootCmd.AddCommand(signCmd)
+}
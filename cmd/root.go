@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"mimo/internal/run"
+
 	"github.com/mimo/mimo-rpc-service/client"
 
 	"github.com/spf13/cobra"
@@ -21,10 +24,16 @@ var RootCmd = &cobra.Command{
 		// 跳过无需 RPC 初始化的命令
 		skip := map[string]bool{
 			"completion": true,
+			"doctor":     true,
 			"help":       true,
+			"system":     true,
+			"uninstall":  true,
 			"update":     true,
+			"verify":     true,
+			"version":    true,
+			"versions":   true,
 		}
-		if skip[cmd.Name()] {
+		if skip[topLevelName(cmd)] {
 			return nil
 		}
 
@@ -52,10 +61,37 @@ func Execute() {
 		printHelp(cmd)
 	})
 
+	// 错误由下面统一输出，避免 cobra 再输出一次
+	RootCmd.SilenceErrors = true
 	if err := RootCmd.Execute(); err != nil {
-		fmt.Println("Error:", err)
-		os.Exit(1)
+		os.Exit(reportError(err))
+	}
+}
+
+// reportError 输出 err 并返回进程退出码。带退出码的错误（如 update 的取消/回滚）使用其自身的退出码；
+// 取消与无需更新不是错误，只在带有说明时输出 INFO（调用方通常已经输出过），其余错误输出到 stderr。
+func reportError(err error) int {
+	var exit *run.ExitError
+	if errors.As(err, &exit) && (exit.Code == run.ExitNoOp || exit.Code == run.ExitCancelled) {
+		if exit.Err != nil {
+			fmt.Println("INFO:", exit.Err)
+		}
+		return exit.Code
+	}
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	var coded interface{ ExitCode() int }
+	if errors.As(err, &coded) {
+		return coded.ExitCode()
+	}
+	return 1
+}
+
+// topLevelName 返回 cmd 所属的一级子命令名称（子命令继承父命令的跳过规则）
+func topLevelName(cmd *cobra.Command) string {
+	for cmd.HasParent() && cmd.Parent().HasParent() {
+		cmd = cmd.Parent()
 	}
+	return cmd.Name()
 }
 
 // isRegisteredCommand 判断 args[0] 是否是注册的子命令
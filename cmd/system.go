@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"mimo/internal/grub"
+	"mimo/internal/hugepages"
+	"mimo/internal/run"
+	"mimo/internal/system"
+
+	"github.com/spf13/cobra"
+)
+
+var systemCmd = &cobra.Command{
+	Use:   "system",
+	Short: "Manage host settings MIMO depends on",
+	Long:  "查看与修改 MIMO 依赖的主机设置",
+}
+
+var cmdlineCmd = &cobra.Command{
+	Use:   "cmdline",
+	Short: "Show or edit the kernel command line",
+	Long:  "查看或修改 " + grub.GrubFile + " 及 " + grub.DropinDir + "/*.cfg 中的内核参数，只改动指定的参数，其余保持不变",
+}
+
+var cmdlineGetCmd = &cobra.Command{
+	Use:   "get [key]",
+	Short: "Show the configured kernel parameters",
+	Long:  "显示 " + grub.VarLinux + " 与 " + grub.VarDefault + " 的生效值及其所在文件；指定 key 时只显示该参数",
+	Args:  cobra.MaximumNArgs(1),
+	// 参数不存在不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := grub.CurrentCmdline()
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			for _, v := range []string{grub.VarLinux, grub.VarDefault} {
+				src := c.Source(v)
+				if src == "" {
+					src = "not set"
+				}
+				fmt.Printf("%s=\"%s\"  (%s)\n", v, grub.Join(c.Value(v)), src)
+			}
+			return nil
+		}
+		ps, vars := c.Lookup(args[0])
+		if len(ps) == 0 {
+			return &run.ExitError{Code: run.ExitFailed, Err: fmt.Errorf("%s is not set", args[0])}
+		}
+		for i, p := range ps {
+			fmt.Printf("%s  (%s)\n", p, vars[i])
+		}
+		return nil
+	},
+}
+
+var cmdlineSetCmd = &cobra.Command{
+	Use:   "set key[=value]...",
+	Short: "Add or update kernel parameters",
+	Long:  "更新已有的同名参数（同名参数只保留一个），没有时追加到 " + grub.VarDefault + "（--linux 追加到 " + grub.VarLinux + "），有变化时执行 update-grub，重启后生效",
+	Args:  cobra.MinimumNArgs(1),
+	// 运行期错误不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ps, err := parseParams(args)
+		if err != nil {
+			return err
+		}
+		opts, err := systemOptions(cmd)
+		if err != nil {
+			return err
+		}
+		v := grub.VarDefault
+		if linux, _ := cmd.Flags().GetBool("linux"); linux {
+			v = grub.VarLinux
+		}
+		return run.EditCmdline(opts, ps, nil, v)
+	},
+}
+
+var cmdlineUnsetCmd = &cobra.Command{
+	Use:   "unset key[=value]...",
+	Short: "Remove kernel parameters",
+	Long:  "删除指定的参数：只给 key 时删除所有同名参数，给 key=value 时只删除完全相同的一个；有变化时执行 update-grub，重启后生效",
+	Args:  cobra.MinimumNArgs(1),
+	// 运行期错误不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ps, err := parseParams(args)
+		if err != nil {
+			return err
+		}
+		opts, err := systemOptions(cmd)
+		if err != nil {
+			return err
+		}
+		return run.EditCmdline(opts, nil, ps, grub.VarDefault)
+	},
+}
+
+var hugepagesCmd = &cobra.Command{
+	Use:   "hugepages",
+	Short: "Show or configure hugepages for SPDK",
+	Long:  "查看或配置 SPDK 使用的大页",
+}
+
+var hugepagesStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show hugepage allocation and usage per NUMA node",
+	Long:  "显示内核命令行中的大页配置，以及每种页大小在每个 NUMA 节点上的分配与使用情况",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pools, err := hugepages.Status()
+		if err != nil {
+			return err
+		}
+		var boot *hugepages.Setting
+		if c, err := grub.CurrentCmdline(); err == nil {
+			boot = hugepages.Boot(c)
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			data, err := json.MarshalIndent(struct {
+				Boot  *hugepages.Setting `json:"boot"`
+				Pools []hugepages.Pool   `json:"pools"`
+			}{boot, pools}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if boot != nil {
+			fmt.Printf("boot: %s (kernel command line)\n", boot)
+		} else {
+			fmt.Println("boot: not configured on the kernel command line")
+		}
+		fmt.Printf("%-4s  %-4s  %7s  %7s  %7s  %7s\n", "SIZE", "NODE", "TOTAL", "FREE", "USED", "SURPLUS")
+		for _, p := range pools {
+			node := "-"
+			if p.Node >= 0 {
+				node = fmt.Sprint(p.Node)
+			}
+			fmt.Printf("%-4s  %-4s  %7d  %7d  %7d  %7d\n", p.Size, node, p.Total, p.Free, p.Used(), p.Surplus)
+		}
+		return nil
+	},
+}
+
+var hugepagesSetCmd = &cobra.Command{
+	Use:   "set --size 2M|1G --count N [--per-numa]",
+	Short: "Reserve hugepages now and at every boot",
+	Long:  "将大页配置写入内核命令行（重启后保持），并立即通过 sysfs 调整数量；--count 0 取消预留",
+	Args:  cobra.NoArgs,
+	// 运行期错误不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var s hugepages.Setting
+		s.Size, _ = cmd.Flags().GetString("size")
+		s.Count, _ = cmd.Flags().GetInt("count")
+		s.PerNUMA, _ = cmd.Flags().GetBool("per-numa")
+		opts, err := systemOptions(cmd)
+		if err != nil {
+			return err
+		}
+		return run.SetHugepages(opts, s)
+	},
+}
+
+var cloudInitCmd = &cobra.Command{
+	Use:   "cloud-init",
+	Short: "Show, enable or disable cloud-init",
+	Long:  "查看、启用或禁用 cloud-init；mimo update --sys 会禁用它，需要 cloud-init 的部署可重新启用",
+}
+
+var cloudInitStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the cloud-init units",
+	Long:  "显示 cloud-init 各单元的启用与运行状态，以及禁用标记文件是否存在",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st := system.CloudInitStatus()
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			data, err := json.MarshalIndent(struct {
+				State string `json:"state"`
+				system.CloudInitState
+			}{st.Summary(), st}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		marker := "absent"
+		if st.Marker {
+			marker = "present"
+		}
+		fmt.Printf("cloud-init: %s (marker %s)\n", st.Summary(), marker)
+		fmt.Printf("%-18s  %-10s  %s\n", "UNIT", "ENABLED", "ACTIVE")
+		for _, u := range st.Units {
+			fmt.Printf("%-18s  %-10s  %s\n", u.Unit, u.Enabled, u.Active)
+		}
+		return nil
+	},
+}
+
+var cloudInitEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Turn cloud-init back on",
+	Long:  "删除禁用标记文件并启用已安装的 cloud-init 单元，下次启动时运行",
+	Args:  cobra.NoArgs,
+	// 运行期错误不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := systemOptions(cmd)
+		if err != nil {
+			return err
+		}
+		return run.SetCloudInit(opts, true)
+	},
+}
+
+var cloudInitDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Stop and disable cloud-init",
+	Long:  "停止并禁用 cloud-init 单元，创建禁用标记文件；失败时恢复原来的状态",
+	Args:  cobra.NoArgs,
+	// 运行期错误不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := systemOptions(cmd)
+		if err != nil {
+			return err
+		}
+		return run.SetCloudInit(opts, false)
+	},
+}
+
+func parseParams(args []string) ([]grub.Param, error) {
+	ps := make([]grub.Param, 0, len(args))
+	for _, a := range args {
+		p, err := grub.ParseParam(a)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+func systemOptions(cmd *cobra.Command) (run.Options, error) {
+	opts, err := run.OptionsFromEnv()
+	if err != nil {
+		return opts, err
+	}
+	opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+	return opts, nil
+}
+
+func init() {
+	cmdlineSetCmd.Flags().Bool("linux", false, "新参数追加到 "+grub.VarLinux+"（对恢复模式同样生效）")
+	hugepagesStatusCmd.Flags().Bool("json", false, "以 JSON 格式输出")
+	hugepagesSetCmd.Flags().String("size", hugepages.Size2M, "页大小："+hugepages.Size2M+" 或 "+hugepages.Size1G)
+	hugepagesSetCmd.Flags().Int("count", 0, "页数（--per-numa 时为每个 NUMA 节点的页数）")
+	hugepagesSetCmd.Flags().Bool("per-numa", false, "在每个 NUMA 节点上预留 --count 页")
+	_ = hugepagesSetCmd.MarkFlagRequired("count")
+	cloudInitStatusCmd.Flags().Bool("json", false, "以 JSON 格式输出")
+	for _, c := range []*cobra.Command{cmdlineSetCmd, cmdlineUnsetCmd, hugepagesSetCmd, cloudInitEnableCmd, cloudInitDisableCmd} {
+		c.Flags().Bool("dry-run", false, "只打印修改计划，不修改系统")
+	}
+	cmdlineCmd.AddCommand(cmdlineGetCmd, cmdlineSetCmd, cmdlineUnsetCmd)
+	hugepagesCmd.AddCommand(hugepagesStatusCmd, hugepagesSetCmd)
+	cloudInitCmd.AddCommand(cloudInitStatusCmd, cloudInitEnableCmd, cloudInitDisableCmd)
+	systemCmd.AddCommand(cmdlineCmd, hugepagesCmd, cloudInitCmd)
+	RootCmd.AddCommand(systemCmd)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"mimo/internal/run"
+
+	"github.com/spf13/cobra"
+)
+
+var uninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove MIMO and restore the original system configuration",
+	Long:  "停用并删除 MIMO 的服务与文件，用安装前保存的原始文件恢复被覆盖的系统文件，恢复 motd 与 cloud-init，重新生成 grub 与 initramfs",
+	Args:  cobra.NoArgs,
+	// 运行期错误不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := run.OptionsFromEnv()
+		if err != nil {
+			return err
+		}
+		opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+		if cmd.Flags().Changed("yes") {
+			opts.AssumeYes, _ = cmd.Flags().GetBool("yes")
+		}
+		if cmd.Flags().Changed("stop-running") {
+			v, _ := cmd.Flags().GetString("stop-running")
+			if opts.StopRunning, err = run.ParseStopPolicy(v); err != nil {
+				return err
+			}
+		}
+		keepData, _ := cmd.Flags().GetBool("keep-data")
+		return run.Uninstall(opts, keepData)
+	},
+}
+
+func init() {
+	uninstallCmd.Flags().Bool("keep-data", false, "保留 /var/lib/mimo（更新历史、原始文件、SPDK 配置备份）")
+	uninstallCmd.Flags().Bool("dry-run", false, "只打印卸载步骤，不修改系统")
+	uninstallCmd.Flags().BoolP("yes", "y", false, "对所有确认提示回答 yes（环境变量 "+run.EnvAssumeYes+"）")
+	uninstallCmd.Flags().String("stop-running", string(run.StopAsk), "MIMO 正在运行时的处理：auto|never|ask（环境变量 "+run.EnvStopRunning+"）")
+	RootCmd.AddCommand(uninstallCmd)
+}
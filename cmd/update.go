@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"mimo/internal/render"
 	"mimo/internal/run"
+	"mimo/internal/spdk"
 
 	"github.com/spf13/cobra"
 )
@@ -11,28 +13,98 @@ var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "MIMO system updater",
 	Long:  "执行系统资源或目标更新，可选择 --sys 或 --target",
+	// 运行期错误不是用法错误，不打印 usage
+	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		sysFlag, _ := cmd.Flags().GetBool("sys")
 		tgtFlag, _ := cmd.Flags().GetBool("target")
+		opts, err := updateOptions(cmd)
+		if err != nil {
+			return err
+		}
 
 		if !sysFlag && !tgtFlag {
 			return fmt.Errorf("specify one of the options: --sys or --target")
 		}
 
 		if sysFlag {
-			return run.RunUpdate()
+			return run.RunUpdate(opts)
 		}
 		if tgtFlag {
-			return run.RuntgtUpdate()
+			return run.RuntgtUpdate(opts)
 		}
 		return nil
 	},
 }
 
+// updateOptions 合并环境变量与命令行参数（参数优先）
+func updateOptions(cmd *cobra.Command) (run.Options, error) {
+	opts, err := run.OptionsFromEnv()
+	if err != nil {
+		return opts, err
+	}
+	opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+	opts.Force, _ = cmd.Flags().GetBool("force")
+	opts.AllowDowngrade, _ = cmd.Flags().GetBool("allow-downgrade")
+	opts.SkipSignature, _ = cmd.Flags().GetBool("insecure-skip-signature")
+	opts.Bundle, _ = cmd.Flags().GetString("bundle")
+	opts.StartTimeout, _ = cmd.Flags().GetDuration("start-timeout")
+	set, _ := cmd.Flags().GetStringArray("set")
+	if opts.Values, err = render.ParseSet(set); err != nil {
+		return opts, err
+	}
+	if cmd.Flags().Changed("staging-dir") {
+		opts.StagingDir, _ = cmd.Flags().GetString("staging-dir")
+	}
+	if cmd.Flags().Changed("yes") {
+		opts.AssumeYes, _ = cmd.Flags().GetBool("yes")
+	}
+	if cmd.Flags().Changed("stop-running") {
+		v, _ := cmd.Flags().GetString("stop-running")
+		if opts.StopRunning, err = run.ParseStopPolicy(v); err != nil {
+			return opts, err
+		}
+	}
+	// 标准输入已被资源包占用，无法再回答确认提示
+	if opts.Bundle == "-" && !opts.AssumeYes && !opts.DryRun {
+		return opts, fmt.Errorf("--bundle - requires --yes")
+	}
+	return opts, nil
+}
+
+var updateRecoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Roll back an interrupted update",
+	Long:  "根据事务日志回滚被中断的更新，可选 --resume 在回滚后重新执行该更新",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resume, _ := cmd.Flags().GetBool("resume")
+		discard, _ := cmd.Flags().GetBool("discard")
+		if resume && discard {
+			return fmt.Errorf("--resume and --discard are mutually exclusive")
+		}
+		return run.Recover(resume, discard)
+	},
+}
+
 func init() {
+	updateRecoverCmd.Flags().Bool("resume", false, "回滚后重新执行被中断的更新")
+	updateRecoverCmd.Flags().Bool("discard", false, "丢弃事务日志而不回滚（回滚无法完成时使用）")
+	updateCmd.AddCommand(updateRecoverCmd)
+
 	// 为 update 命令添加 flags
 	updateCmd.Flags().Bool("sys", false, "执行系统更新")
 	updateCmd.Flags().Bool("target", false, "执行target更新")
+	updateCmd.Flags().Bool("dry-run", false, "只打印完整的执行计划，不修改系统")
+	updateCmd.Flags().Bool("force", false, "忽略版本检查，允许重复安装或降级")
+	updateCmd.Flags().Bool("allow-downgrade", false, "允许安装比当前更旧的版本")
+	updateCmd.Flags().String("bundle", "", "使用外部资源包文件而非内嵌资源（- 表示标准输入）")
+	updateCmd.Flags().Bool("insecure-skip-signature", false, "跳过资源包签名校验（仅限开发环境）")
+	updateCmd.Flags().BoolP("yes", "y", false, "对所有确认提示回答 yes（环境变量 "+run.EnvAssumeYes+"）")
+	updateCmd.Flags().String("stop-running", string(run.StopAsk), "MIMO 正在运行时的处理：auto|never|ask（环境变量 "+run.EnvStopRunning+"）")
+	updateCmd.Flags().String("staging-dir", "", "解压资源包的目录，每次更新在其下创建独占子目录（默认系统临时目录，环境变量 "+run.EnvStagingDir+"）")
+	updateCmd.Flags().StringArray("set", nil, "设置模板变量 KEY=VALUE，可重复（覆盖 "+render.ValuesPath+"）")
+	updateCmd.Flags().Duration("start-timeout", spdk.DefaultStartTimeout, "重启后等待 MIMO 就绪并恢复全部 bdev 与 RAID 的时间，超时则回滚")
 
 	// 注册到根命令
 	RootCmd.AddCommand(updateCmd)
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"mimo/internal/fileops"
+	"mimo/internal/render"
+	"mimo/internal/run"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check installed files against the installed manifest",
+	Long:  "将每个已安装文件与已安装清单比较（内容、权限、属主），报告被修改、缺失与多出的文件；--repair 在事务中用资源包恢复被修改与缺失的文件",
+	Args:  cobra.NoArgs,
+	// 发现差异不是用法错误，不打印 usage
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := run.OptionsFromEnv()
+		if err != nil {
+			return err
+		}
+		opts.Bundle, _ = cmd.Flags().GetString("bundle")
+		opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+		opts.SkipSignature, _ = cmd.Flags().GetBool("insecure-skip-signature")
+		if cmd.Flags().Changed("staging-dir") {
+			opts.StagingDir, _ = cmd.Flags().GetString("staging-dir")
+		}
+		if cmd.Flags().Changed("yes") {
+			opts.AssumeYes, _ = cmd.Flags().GetBool("yes")
+		}
+		set, _ := cmd.Flags().GetStringArray("set")
+		if opts.Values, err = render.ParseSet(set); err != nil {
+			return err
+		}
+
+		if repair, _ := cmd.Flags().GetBool("repair"); repair {
+			if opts.Bundle == "-" && !opts.AssumeYes && !opts.DryRun {
+				return fmt.Errorf("--bundle - requires --yes")
+			}
+			return run.Repair(opts)
+		}
+
+		drifts, err := run.Verify(opts)
+		if err != nil {
+			return err
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			if drifts == nil {
+				drifts = []fileops.Drift{}
+			}
+			data, err := json.MarshalIndent(drifts, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			run.PrintDrift(drifts)
+			fmt.Printf("INFO: %s\n", run.CountDrift(drifts))
+		}
+		for _, d := range drifts {
+			if d.Kind != fileops.DriftEdited {
+				return &run.ExitError{Code: run.ExitFailed, Err: fmt.Errorf("installed files differ from the installed manifest")}
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().Bool("repair", false, "在事务中恢复被修改与缺失的文件（多出的文件与本地修改过的配置文件不变）")
+	verifyCmd.Flags().Bool("json", false, "以 JSON 格式输出差异")
+	verifyCmd.Flags().Bool("dry-run", false, "与 --repair 一起使用：只打印恢复计划，不修改系统")
+	verifyCmd.Flags().String("bundle", "", "使用外部资源包文件而非内嵌资源（- 表示标准输入）")
+	verifyCmd.Flags().Bool("insecure-skip-signature", false, "跳过资源包签名校验（仅限开发环境）")
+	verifyCmd.Flags().BoolP("yes", "y", false, "对所有确认提示回答 yes（环境变量 "+run.EnvAssumeYes+"）")
+	verifyCmd.Flags().String("staging-dir", "", "解压资源包的目录（环境变量 "+run.EnvStagingDir+"）")
+	verifyCmd.Flags().StringArray("set", nil, "恢复模板时设置模板变量 KEY=VALUE，可重复（覆盖 "+render.ValuesPath+"）")
+	RootCmd.AddCommand(verifyCmd)
+}
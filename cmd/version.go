@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"mimo/internal/decompress"
+	"mimo/internal/run"
+
+	"github.com/spf13/cobra"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show CLI, bundle and installed versions",
+	Long:  "显示 CLI 构建版本、内嵌资源包版本与已安装的 MIMO 版本",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var b *decompress.Bundle
+		if file, _ := cmd.Flags().GetString("bundle"); file != "" {
+			var err error
+			if b, err = decompress.Open(file); err != nil {
+				return err
+			}
+		}
+		v := run.CurrentVersions(b)
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			data, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		fmt.Printf("CLI       : %s\n", v.CLI)
+		fmt.Printf("Bundle    : %s\n", v.Bundle)
+		fmt.Printf("Installed : %s\n", v.Installed)
+		return nil
+	},
+}
+
+func init() {
+	versionCmd.Flags().Bool("json", false, "以 JSON 格式输出")
+	versionCmd.Flags().String("bundle", "", "显示外部资源包文件的版本（- 表示标准输入）")
+	RootCmd.AddCommand(versionCmd)
+}
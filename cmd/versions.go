@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"mimo/internal/run"
+	"mimo/internal/slots"
+	"mimo/internal/spdk"
+
+	"github.com/spf13/cobra"
+)
+
+var versionsCmd = &cobra.Command{
+	Use:   "versions",
+	Short: "Manage installed MIMO versions",
+	Long:  "管理 " + slots.DefaultDir + " 下已安装的 MIMO 版本，" + slots.DefaultLink + " 为指向当前版本的符号链接",
+}
+
+var versionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List installed versions",
+	Long:  "列出已安装的版本，* 表示当前版本",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		l := slots.Default()
+		list, err := l.List()
+		if err != nil {
+			return err
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			if list == nil {
+				list = []slots.Slot{}
+			}
+			data, err := json.MarshalIndent(list, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if l.IsLegacy() {
+			fmt.Printf("%s is a plain directory; it becomes a version slot on the next target update\n", l.Link)
+		}
+		if len(list) == 0 {
+			fmt.Println("no versions installed in", l.Dir)
+			return nil
+		}
+		for _, s := range list {
+			mark := " "
+			if s.Active {
+				mark = "*"
+			}
+			fmt.Printf("%s %-16s %s\n", mark, s.Version, s.Path)
+		}
+		return nil
+	},
+}
+
+var versionsSwitchCmd = &cobra.Command{
+	Use:          "switch <version>",
+	Short:        "Switch to an installed version",
+	Long:         "原子地将 " + slots.DefaultLink + " 切换到已安装的版本；MIMO 正在运行时停止后以保存的配置重启，启动失败自动切回",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := run.OptionsFromEnv()
+		if err != nil {
+			return err
+		}
+		opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+		opts.StartTimeout, _ = cmd.Flags().GetDuration("start-timeout")
+		if cmd.Flags().Changed("yes") {
+			opts.AssumeYes, _ = cmd.Flags().GetBool("yes")
+		}
+		if cmd.Flags().Changed("stop-running") {
+			v, _ := cmd.Flags().GetString("stop-running")
+			if opts.StopRunning, err = run.ParseStopPolicy(v); err != nil {
+				return err
+			}
+		}
+		return run.SwitchVersion(args[0], opts)
+	},
+}
+
+var versionsPruneCmd = &cobra.Command{
+	Use:          "prune",
+	Short:        "Remove old versions",
+	Long:         "删除旧版本，只保留最新的若干个（当前版本总是保留）",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keep, _ := cmd.Flags().GetInt("keep")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if keep < 1 {
+			return fmt.Errorf("--keep must be at least 1")
+		}
+		return run.PruneVersions(keep, dryRun)
+	},
+}
+
+func init() {
+	versionsListCmd.Flags().Bool("json", false, "以 JSON 格式输出")
+
+	versionsSwitchCmd.Flags().Bool("dry-run", false, "只打印执行计划，不修改系统")
+	versionsSwitchCmd.Flags().BoolP("yes", "y", false, "对所有确认提示回答 yes（环境变量 "+run.EnvAssumeYes+"）")
+	versionsSwitchCmd.Flags().Duration("start-timeout", spdk.DefaultStartTimeout, "重启后等待 MIMO 就绪并恢复全部 bdev 与 RAID 的时间，超时则切回")
+	versionsSwitchCmd.Flags().String("stop-running", string(run.StopAsk), "MIMO 正在运行时的处理：auto|never|ask（环境变量 "+run.EnvStopRunning+"）")
+
+	versionsPruneCmd.Flags().Int("keep", slots.DefaultKeep, "保留的版本数（含当前版本）")
+	versionsPruneCmd.Flags().Bool("dry-run", false, "只列出将被删除的版本")
+
+	versionsCmd.AddCommand(versionsListCmd)
+	versionsCmd.AddCommand(versionsSwitchCmd)
+	versionsCmd.AddCommand(versionsPruneCmd)
+	RootCmd.AddCommand(versionsCmd)
+}
@@ -1,11 +1,11 @@
 #!/bin/bash
 HOST=$(hostname)
-VERSION="1.0.0"
+VERSION="{{.Version}}"
 DATE=$(date "+%Y-%m-%d %H:%M:%S")
 
 cat <<ISSUE > /etc/issue
 ┌──────────────────────────────────┐
-│     Welcome to MIMO Storage      │
+│     Welcome to {{printf "%-18s" (print .Values.product " Storage")}}│
 └──────────────────────────────────┘
 ISSUE
 
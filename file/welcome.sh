@@ -3,9 +3,9 @@ clear
 GREEN="\e[32m"
 RESET="\e[0m"
 RED="\e[31m"
-echo "Welcome to MIMO Server!"
+echo "Welcome to {{.Values.product}} Server!"
 echo "* Hostname      : $(hostname)"
-echo "* Version       : 1.0.0($(uname -r))"
+echo "* Version       : {{.Version}}($(uname -r))"
 echo
 echo "Network:"
 echo
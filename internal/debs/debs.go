@@ -0,0 +1,298 @@
+// Package debs installs the .deb packages a bundle depends on from the
+// repository shipped inside the bundle, without apt sources or network
+// access. Only packages missing from the dpkg status are installed.
+package debs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"mimo/internal/fileops"
+	"mimo/internal/transaction"
+)
+
+// StatusPath dpkg 状态文件
+const StatusPath = "/var/lib/dpkg/status"
+
+const undoKind = "debs.remove"
+
+// Deb 仓库中的一个软件包文件
+type Deb struct {
+	Name    string
+	Version string
+	Arch    string
+	Path    string
+}
+
+func (d Deb) String() string {
+	return fmt.Sprintf("%s %s (%s)", d.Name, d.Version, filepath.Base(d.Path))
+}
+
+// Installed 读取 dpkg 状态文件，返回已安装的包及其版本
+func Installed(statusPath string) (map[string]string, error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := map[string]string{}
+	var name, version, status string
+	flush := func() {
+		if name != "" && strings.HasSuffix(status, " installed") {
+			out[name] = version
+		}
+		name, version, status = "", "", ""
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, " ") {
+			continue
+		}
+		switch k {
+		case "Package":
+			name = strings.TrimSpace(v)
+		case "Version":
+			version = strings.TrimSpace(v)
+		case "Status":
+			status = strings.TrimSpace(v)
+		}
+	}
+	flush()
+	return out, sc.Err()
+}
+
+// Arch 返回本机的 Debian 架构名
+func Arch() string {
+	switch runtime.GOARCH {
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhf"
+	}
+	return runtime.GOARCH
+}
+
+// Repo 列出 dir 中适用于 arch 的 .deb（按 Debian 命名 name_version_arch.deb），
+// 同名包保留最高版本
+func Repo(dir, arch string) (map[string]Deb, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.deb"))
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]Deb{}
+	for _, p := range files {
+		d, ok := parseName(p)
+		if !ok || (d.Arch != arch && d.Arch != "all") {
+			continue
+		}
+		if cur, ok := out[d.Name]; ok && CompareVersions(cur.Version, d.Version) >= 0 {
+			continue
+		}
+		out[d.Name] = d
+	}
+	return out, nil
+}
+
+func parseName(p string) (Deb, bool) {
+	parts := strings.Split(strings.TrimSuffix(filepath.Base(p), ".deb"), "_")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return Deb{}, false
+	}
+	// 文件名中的 epoch 冒号写作 %3a
+	ver, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return Deb{}, false
+	}
+	return Deb{Name: parts[0], Version: ver, Arch: parts[2], Path: p}, true
+}
+
+// Plan 依赖检查结果
+type Plan struct {
+	// Install 需要从仓库安装的包
+	Install []Deb
+	// Satisfied 已满足的包
+	Satisfied []string
+	// Missing 无法满足的必需包及原因
+	Missing []string
+	// Skipped 无法满足的可选包及原因
+	Skipped []string
+}
+
+// NewPlan 比较依赖清单与已安装的包，决定需要安装哪些
+func NewPlan(pkgs []fileops.Package, installed map[string]string, repo map[string]Deb) *Plan {
+	p := &Plan{}
+	for _, req := range pkgs {
+		have, ok := installed[req.Name]
+		if ok && (req.Version == "" || CompareVersions(have, req.Version) >= 0) {
+			p.Satisfied = append(p.Satisfied, fmt.Sprintf("%s %s", req.Name, have))
+			continue
+		}
+		why := ""
+		d, inRepo := repo[req.Name]
+		switch {
+		case !inRepo:
+			why = "not in the bundle repository"
+		case req.Version != "" && CompareVersions(d.Version, req.Version) < 0:
+			why = fmt.Sprintf("bundle has %s, %s required", d.Version, req.Version)
+		}
+		if why == "" {
+			p.Install = append(p.Install, d)
+			continue
+		}
+		if ok {
+			why = fmt.Sprintf("installed %s is too old and %s", have, why)
+		}
+		msg := fmt.Sprintf("%s: %s", req.Name, why)
+		if req.Optional {
+			p.Skipped = append(p.Skipped, msg)
+		} else {
+			p.Missing = append(p.Missing, msg)
+		}
+	}
+	return p
+}
+
+// Check 在 installed 中确认 pkgs 的必需包均已满足
+func Check(pkgs []fileops.Package, installed map[string]string) error {
+	var bad []string
+	for _, req := range pkgs {
+		have, ok := installed[req.Name]
+		switch {
+		case req.Optional:
+		case !ok:
+			bad = append(bad, req.Name+" is not installed")
+		case req.Version != "" && CompareVersions(have, req.Version) < 0:
+			bad = append(bad, fmt.Sprintf("%s %s is older than %s", req.Name, have, req.Version))
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("required packages not satisfied: %s", strings.Join(bad, "; "))
+	}
+	return nil
+}
+
+// removeState 撤销数据：本次新安装的包
+type removeState struct {
+	Packages []string `json:"packages"`
+}
+
+func init() {
+	transaction.RegisterUndo(undoKind, func(raw json.RawMessage) error {
+		var st removeState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return st.remove()
+	})
+}
+
+func (st *removeState) remove() error {
+	if len(st.Packages) == 0 {
+		return nil
+	}
+	fmt.Printf("INFO: removing %s\n", strings.Join(st.Packages, " "))
+	return command("dpkg", append([]string{"--remove"}, st.Packages...)...)
+}
+
+// RegisterInstallAction 注册从仓库安装 plan.Install 的动作，安装后确认 pkgs 的必需包均已满足。
+// 撤销时删除本次新安装的包；被升级的包无法离线降级，保持新版本。
+func RegisterInstallAction(txn *transaction.Transaction, plan *Plan, pkgs []fileops.Package, installed map[string]string) {
+	if len(plan.Install) == 0 {
+		return
+	}
+	st := &removeState{}
+	for _, d := range plan.Install {
+		if _, ok := installed[d.Name]; !ok {
+			st.Packages = append(st.Packages, d.Name)
+		}
+	}
+	sort.Strings(st.Packages)
+
+	optional := map[string]bool{}
+	for _, req := range pkgs {
+		optional[req.Name] = req.Optional
+	}
+
+	txn.Add(&transaction.Action{
+		Name: "install package dependencies",
+		Kind: undoKind,
+		State: func() (any, error) {
+			return st, nil
+		},
+		Describe: func() []string {
+			out := make([]string, 0, len(plan.Install))
+			for _, d := range plan.Install {
+				if have, ok := installed[d.Name]; ok {
+					out = append(out, fmt.Sprintf("upgrade %s %s -> %s", d.Name, have, d.Version))
+				} else {
+					out = append(out, fmt.Sprintf("install %s", d))
+				}
+			}
+			return out
+		},
+		Do: func() error {
+			fmt.Printf("INFO: installing %d package(s) from the bundle repository...\n", len(plan.Install))
+			var required, opt []string
+			for _, d := range plan.Install {
+				if optional[d.Name] {
+					opt = append(opt, d.Path)
+				} else {
+					required = append(required, d.Path)
+				}
+			}
+			if err := aptInstall(required); err != nil {
+				return err
+			}
+			for _, p := range opt {
+				if err := aptInstall([]string{p}); err != nil {
+					fmt.Printf("WARN: optional package %s not installed: %v\n", filepath.Base(p), err)
+				}
+			}
+			now, err := Installed(StatusPath)
+			if err != nil {
+				return err
+			}
+			return Check(pkgs, now)
+		},
+		Undo: st.remove,
+	})
+}
+
+// aptInstall 只从给定的 .deb 文件安装：清空 apt 源，既不联网也不使用已下载的索引
+func aptInstall(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	args := []string{"install", "-y", "--no-install-recommends",
+		"-o", "Dir::Etc::SourceList=/dev/null",
+		"-o", "Dir::Etc::SourceParts=/dev/null",
+	}
+	return command("apt-get", append(args, paths...)...)
+}
+
+func command(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package debs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions 按 Debian 规则（与 dpkg --compare-versions 一致）比较两个版本，
+// 返回负数、0 或正数
+func CompareVersions(a, b string) int {
+	ae, au, ar := splitVersion(a)
+	be, bu, br := splitVersion(b)
+	if ae != be {
+		if ae < be {
+			return -1
+		}
+		return 1
+	}
+	if c := verrevcmp(au, bu); c != 0 {
+		return c
+	}
+	return verrevcmp(ar, br)
+}
+
+// splitVersion 拆分 [epoch:]upstream[-revision]
+func splitVersion(v string) (int, string, string) {
+	epoch := 0
+	if e, rest, ok := strings.Cut(v, ":"); ok {
+		if n, err := strconv.Atoi(e); err == nil {
+			epoch, v = n, rest
+		}
+	}
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// order 非数字字符的排序权重：~ 最小，其次是字符串结束，然后是字母，最后是其它符号
+func order(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+func verrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			if ac, bc := order(a, i), order(b, j); ac != bc {
+				return ac - bc
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		first := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if first == 0 {
+				first = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if first != 0 {
+			return first
+		}
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package debs
+
+import "testing"
+
+// 期望值与 dpkg --compare-versions 的结果一致
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.1", "1.0", 1},
+		{"1.0", "1.0-1", -1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-10", "1.0-9", 1},
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0+", "1.0.", -1},
+		{"1.0.1", "1.0+1", 1},
+		{"1.002", "1.2", 0},
+		{"1.09", "1.10", -1},
+		{"1.0a", "1.0b", -1},
+		{"2.30-0ubuntu1", "2.30-0ubuntu1.1", -1},
+		{"0.3.112-5", "0.3.112", 1},
+		{"0.3.110-5ubuntu0.1", "0.3.112", -1},
+		{"1.0-1ubuntu1~20.04", "1.0-1ubuntu1", -1},
+		{"2.34-0ubuntu3.2", "2.31-0ubuntu9.9", 1},
+		{"1.0-a-b", "1.0-a-c", -1},
+		{"1.0-1", "1.0-a", -1},
+		{"7.68.0-1ubuntu2.21", "7.68.0-1ubuntu2.7", 1},
+	}
+	sign := func(n int) int {
+		switch {
+		case n < 0:
+			return -1
+		case n > 0:
+			return 1
+		}
+		return 0
+	}
+	for _, tt := range tests {
+		if got := sign(CompareVersions(tt.a, tt.b)); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := sign(CompareVersions(tt.b, tt.a)); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package decompress
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// Member names inside a standalone bundle file. A bundle file is an
+// uncompressed tar holding the same three files that are embedded into the
+// binary by pack_resources.sh.
+const (
+	ArchiveName   = "resources.tar.gz"
+	HashName      = "resources.sha256"
+	SignatureName = "resources.sig"
+)
+
+// maxMetaSize bounds the hash and signature members
+const maxMetaSize = 64 * 1024
+
+// Open loads a standalone bundle file; "-" reads it from stdin
+func Open(file string) (*Bundle, error) {
+	var r io.Reader
+	if file == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("open bundle: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := ReadBundle(r)
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", file, err)
+	}
+	b.Source = file
+	if file == "-" {
+		b.Source = "stdin"
+	}
+	return b, nil
+}
+
+// ReadBundle parses a standalone bundle from r
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	b := &Bundle{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read bundle: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		switch path.Base(hdr.Name) {
+		case ArchiveName:
+			b.archive, err = io.ReadAll(tr)
+		case HashName:
+			b.hash, err = io.ReadAll(io.LimitReader(tr, maxMetaSize))
+		case SignatureName:
+			b.sig, err = io.ReadAll(io.LimitReader(tr, maxMetaSize))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %v", hdr.Name, err)
+		}
+	}
+	if len(b.archive) == 0 {
+		return nil, fmt.Errorf("missing %s", ArchiveName)
+	}
+	if len(b.hash) == 0 {
+		return nil, fmt.Errorf("missing %s", HashName)
+	}
+	return b, nil
+}
+
+// WriteBundle writes b as a standalone bundle file to w
+func WriteBundle(w io.Writer, b *Bundle) error {
+	tw := tar.NewWriter(w)
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{
+		{ArchiveName, b.archive},
+		{HashName, b.hash},
+		{SignatureName, b.sig},
+	} {
+		hdr := &tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(m.data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// NewBundle assembles a bundle from its parts
+func NewBundle(archive, hash, sig []byte) *Bundle {
+	return &Bundle{archive: archive, hash: hash, sig: sig}
+}
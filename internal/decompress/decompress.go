@@ -12,6 +12,8 @@ import (
 	"strings"
 
 	_ "embed"
+
+	"mimo/internal/signature"
 )
 
 //go:embed resources.tar.gz
@@ -20,80 +22,89 @@ var embeddedResources []byte
 //go:embed resources.sha256
 var embeddedHash []byte
 
-// ExtractResources extracts embedded resources into the target directory
-func ExtractResources(dest string) error {
-	dest = filepath.Clean(dest)
+// resources.sig is empty for unsigned builds
+//
+//go:embed resources.sig
+var embeddedSig []byte
+
+// Bundle is an update bundle: the resources archive plus its hash and
+// signature. It is either embedded in the binary or loaded from a file.
+type Bundle struct {
+	// Source describes where the bundle came from ("embedded" or a path)
+	Source string
+
+	archive []byte
+	hash    []byte
+	sig     []byte
+}
+
+// Embedded returns the bundle compiled into this binary
+func Embedded() *Bundle {
+	return &Bundle{Source: "embedded", archive: embeddedResources, hash: embeddedHash, sig: embeddedSig}
+}
+
+// ExtractResources extracts the bundle into the target directory.
+// Callers must verify the bundle (VerifyHash, VerifySignature) first.
+func (b *Bundle) ExtractResources(dest string) error {
 	fmt.Println("INFO: extracting resources; this may take some time...")
+	if err := extractArchive(bytes.NewReader(b.archive), dest); err != nil {
+		return err
+	}
+	fmt.Println("INFO: extraction completed")
+	return nil
+}
 
-	reader := bytes.NewReader(embeddedResources)
-	gzr, err := gzip.NewReader(reader)
+// ReadFile returns the content of a single regular file from the bundle
+// archive without extracting anything to disk.
+func (b *Bundle) ReadFile(name string) ([]byte, error) {
+	name = strings.TrimPrefix(filepath.Clean(name), "/")
+	gzr, err := gzip.NewReader(bytes.NewReader(b.archive))
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %v", err)
+		return nil, fmt.Errorf("failed to create gzip reader: %v", err)
 	}
 	defer gzr.Close()
 
 	tr := tar.NewReader(gzr)
-
-	var skipped int
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
-			break
+			return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read archive: %v", err)
+			return nil, fmt.Errorf("failed to read archive: %v", err)
 		}
-
-		targetPath := filepath.Join(dest, hdr.Name)
-
-		switch hdr.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, 0755); err != nil {
-				return fmt.Errorf("failed to create directory: %v", err)
-			}
-
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return fmt.Errorf("failed to create parent directory: %v", err)
-			}
-			f, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
-			if err != nil {
-				return fmt.Errorf("failed to create file: %v", err)
-			}
-			if _, err := io.Copy(f, tr); err != nil {
-				f.Close()
-				return fmt.Errorf("failed to write file: %v", err)
-			}
-			f.Close()
-
-		case tar.TypeSymlink:
-			linkTarget := filepath.Join(dest, hdr.Linkname)
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return fmt.Errorf("failed to create directory for symlink: %v", err)
-			}
-			_ = os.Remove(targetPath) // remove existing file if any
-			if err := os.Symlink(linkTarget, targetPath); err != nil {
-				return fmt.Errorf("failed to create symlink: %v", err)
-			}
-
-		default:
-			// unsupported entry type, count and continue
-			skipped++
+		if hdr.Typeflag == tar.TypeReg && filepath.Clean(hdr.Name) == name {
+			return io.ReadAll(tr)
 		}
 	}
+}
 
-	if skipped > 0 {
-		fmt.Println("WARN: some archive entries were skipped")
+// Digest returns the hex SHA256 of the bundle archive
+func (b *Bundle) Digest() string {
+	sum := sha256.Sum256(b.archive)
+	return fmt.Sprintf("%x", sum[:])
+}
+
+// VerifySignature checks the bundle signature against the public keys
+// installed in trustedDir.
+func (b *Bundle) VerifySignature(trustedDir string) error {
+	keys, err := signature.LoadTrustedKeys(trustedDir)
+	if err != nil {
+		return err
 	}
-	fmt.Println("INFO: extraction completed")
+	key, err := signature.Verify(keys, b.Digest(), b.sig)
+	if err != nil {
+		fmt.Println("ERROR: resources signature verification failed")
+		return fmt.Errorf("%w (trusted keys: %s)", err, trustedDir)
+	}
+	fmt.Printf("INFO: resources signed by trusted key %s (%s)\n", key.ID, filepath.Base(key.File))
 	return nil
 }
 
-// VerifyHash verifies the SHA256 of the embedded resources
-func VerifyHash() bool {
-	sum := sha256.Sum256(embeddedResources)
-	calculated := fmt.Sprintf("%x", sum[:])
-	expected := string(bytes.TrimSpace(embeddedHash))
+// VerifyHash verifies the SHA256 of the bundle archive
+func (b *Bundle) VerifyHash() bool {
+	calculated := b.Digest()
+	expected := string(bytes.TrimSpace(b.hash))
 	if !strings.EqualFold(calculated, expected) {
 		fmt.Println("ERROR: resources verification failed")
 		return false
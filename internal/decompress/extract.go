@@ -0,0 +1,285 @@
+package decompress
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// extractArchive unpacks a tar.gz stream into dest. Entry names and hard link
+// targets must stay inside dest, and nothing is written through a symlink.
+// Symlinks are kept as written in the archive: absolute targets name paths
+// on the node, relative ones must resolve inside dest. Ownership and mtimes
+// are applied.
+func extractArchive(r io.Reader, dest string) error {
+	dest = filepath.Clean(dest)
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dest, err)
+	}
+
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip reader: %v", err)
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	asRoot := os.Geteuid() == 0
+
+	type dirEntry struct {
+		path string
+		hdr  *tar.Header
+	}
+	var dirs []dirEntry
+	var links []string
+	var skipped []string
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %v", err)
+		}
+
+		targetPath, err := safeJoin(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if targetPath == dest {
+			continue
+		}
+		if err := checkParents(dest, targetPath); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(targetPath, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %v", err)
+			}
+			// mode and mtime are applied once the directory is populated
+			dirs = append(dirs, dirEntry{targetPath, hdr})
+
+		case tar.TypeReg:
+			if err := prepare(targetPath); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(targetPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return fmt.Errorf("failed to create file: %v", err)
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to write file: %v", err)
+			}
+			f.Close()
+			if err := applyHeader(targetPath, hdr, asRoot); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := checkLinkTarget(dest, targetPath, hdr.Linkname); err != nil {
+				return err
+			}
+			if err := prepare(targetPath); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, targetPath); err != nil {
+				return fmt.Errorf("failed to create symlink: %v", err)
+			}
+			if asRoot {
+				_ = os.Lchown(targetPath, hdr.Uid, hdr.Gid)
+			}
+			links = append(links, targetPath)
+
+		case tar.TypeLink:
+			linkPath, err := safeJoin(dest, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := checkParents(dest, linkPath); err != nil {
+				return err
+			}
+			if err := prepare(targetPath); err != nil {
+				return err
+			}
+			if err := os.Link(linkPath, targetPath); err != nil {
+				return fmt.Errorf("failed to create hard link: %v", err)
+			}
+
+		default:
+			// devices, fifos and the like have no place in an update bundle
+			skipped = append(skipped, fmt.Sprintf("%s (type %q)", hdr.Name, hdr.Typeflag))
+		}
+	}
+
+	// symlinks may chain through each other; resolve them now that all exist
+	if err := checkResolvedLinks(dest, links); err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := applyHeader(dirs[i].path, dirs[i].hdr, asRoot); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range skipped {
+		fmt.Printf("WARN: skipped unsupported archive entry %s\n", s)
+	}
+	return nil
+}
+
+// safeJoin joins an archive path onto dest, rejecting absolute paths and
+// paths that climb out of dest.
+func safeJoin(dest, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) {
+		return "", fmt.Errorf("archive entry %q: absolute path not allowed", name)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("archive entry %q: path traversal not allowed", name)
+		}
+	}
+	p := filepath.Join(dest, name)
+	if !within(dest, p) {
+		return "", fmt.Errorf("archive entry %q escapes %s", name, dest)
+	}
+	return p, nil
+}
+
+// checkLinkTarget requires relative symlink targets to resolve (lexically)
+// inside dest. Absolute targets such as lib -> /usr/lib are allowed: they
+// only matter on the node, and checkParents keeps extraction from writing
+// through them.
+func checkLinkTarget(dest, linkPath, target string) error {
+	if target == "" {
+		return fmt.Errorf("archive symlink %s: empty target", linkPath)
+	}
+	if filepath.IsAbs(target) {
+		return nil
+	}
+	if !within(dest, filepath.Join(filepath.Dir(linkPath), target)) {
+		return fmt.Errorf("archive symlink %s -> %q escapes %s", linkPath, target, dest)
+	}
+	return nil
+}
+
+// checkResolvedLinks resolves every extracted symlink and fails if a chain
+// of relative links ends up outside dest.
+func checkResolvedLinks(dest string, links []string) error {
+	realDest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		rel, _ := filepath.Rel(dest, l)
+		if escapes(realDest, rel) {
+			return fmt.Errorf("archive symlink %s resolves outside %s", l, dest)
+		}
+	}
+	return nil
+}
+
+// escapes resolves rel under root one component at a time and reports
+// whether a relative link leads out of root. An absolute link target ends
+// the walk, as do dangling links and loops, which point nowhere.
+func escapes(root, rel string) bool {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	cur := root
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+			if !within(root, cur) {
+				return true
+			}
+			continue
+		}
+		next := filepath.Join(cur, part)
+		fi, err := os.Lstat(next)
+		if err != nil {
+			return false
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+		target, err := os.Readlink(next)
+		if hops++; err != nil || hops > 255 || filepath.IsAbs(target) {
+			return false
+		}
+		parts = append(strings.Split(filepath.ToSlash(target), "/"), parts...)
+	}
+	return false
+}
+
+// checkParents refuses to write through a symlinked directory
+func checkParents(dest, p string) error {
+	rel, err := filepath.Rel(dest, filepath.Dir(p))
+	if err != nil || rel == "." {
+		return err
+	}
+	cur := dest
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		fi, err := os.Lstat(cur)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %s would be written through symlink %s", p, cur)
+		}
+	}
+	return nil
+}
+
+// prepare creates the parent directory and removes anything already at p
+func prepare(p string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %v", err)
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s: %v", p, err)
+	}
+	return nil
+}
+
+// applyHeader sets ownership (root only), mode and mtime from hdr
+func applyHeader(p string, hdr *tar.Header, asRoot bool) error {
+	if asRoot {
+		if err := os.Lchown(p, hdr.Uid, hdr.Gid); err != nil {
+			return fmt.Errorf("failed to chown %s: %v", p, err)
+		}
+	}
+	if err := os.Chmod(p, hdr.FileInfo().Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("failed to chmod %s: %v", p, err)
+	}
+	if !hdr.ModTime.IsZero() {
+		if err := os.Chtimes(p, time.Now(), hdr.ModTime); err != nil {
+			return fmt.Errorf("failed to set mtime on %s: %v", p, err)
+		}
+	}
+	return nil
+}
+
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,136 @@
+package decompress
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry is one archive member; name and link may use OUTSIDE for a
+// directory next to the extraction root
+type entry struct {
+	typ  byte
+	name string
+	link string
+	body string
+}
+
+func file(name, body string) entry { return entry{typ: tar.TypeReg, name: name, body: body} }
+func dir(name string) entry        { return entry{typ: tar.TypeDir, name: name} }
+func symlink(name, link string) entry {
+	return entry{typ: tar.TypeSymlink, name: name, link: link}
+}
+func hardlink(name, link string) entry {
+	return entry{typ: tar.TypeLink, name: name, link: link}
+}
+
+// archive builds a tar.gz holding entries
+func archive(t *testing.T, outside string, entries []entry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Typeflag: e.typ,
+			Name:     strings.ReplaceAll(e.name, "OUTSIDE", outside),
+			Linkname: strings.ReplaceAll(e.link, "OUTSIDE", outside),
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractArchiveRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"parent path", []entry{file("../evil", "x")}},
+		{"nested parent path", []entry{file("a/../../evil", "x")}},
+		{"absolute path", []entry{file("OUTSIDE/evil", "x")}},
+		{"symlink escapes", []entry{symlink("link", "../outside")}},
+		{"symlink escapes from subdir", []entry{dir("a"), symlink("a/link", "../../outside")}},
+		{"file through relative symlink", []entry{dir("a"), symlink("link", "a"), file("link/evil", "x")}},
+		{"file through absolute symlink", []entry{symlink("link", "OUTSIDE"), file("link/evil", "x")}},
+		{"dir through absolute symlink", []entry{symlink("link", "OUTSIDE"), dir("link/sub")}},
+		{"symlink chain escapes", []entry{symlink("d", "."), symlink("x", "d/..")}},
+		{"symlink chain escapes later", []entry{symlink("x", "d/.."), symlink("d", ".")}},
+		{"hardlink parent path", []entry{hardlink("h", "../outside/file")}},
+		{"hardlink absolute", []entry{hardlink("h", "OUTSIDE/file")}},
+		{"hardlink through symlink", []entry{symlink("link", "OUTSIDE"), hardlink("h", "link/file")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			outside := filepath.Join(base, "outside")
+			if err := os.Mkdir(outside, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(outside, "file"), []byte("host"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(base, "dest")
+
+			err := extractArchive(archive(t, outside, tt.entries), dest)
+			if err == nil {
+				t.Fatal("extractArchive succeeded, want error")
+			}
+			des, _ := os.ReadDir(outside)
+			if len(des) != 1 {
+				t.Errorf("%s was written to: %d entries", outside, len(des))
+			}
+			if _, err := os.Stat(filepath.Join(base, "evil")); err == nil {
+				t.Errorf("%s was created", filepath.Join(base, "evil"))
+			}
+		})
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	base := t.TempDir()
+	dest := filepath.Join(base, "dest")
+	entries := []entry{
+		dir("a"),
+		file("a/f", "hello"),
+		symlink("a/rel", "f"),
+		symlink("up", "a/../a/f"),
+		symlink("lib", "/usr/lib"),
+		symlink("dangling", "a/missing"),
+		hardlink("a/h", "a/f"),
+	}
+	if err := extractArchive(archive(t, "", entries), dest); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a/f", "a/rel", "up", "a/h"} {
+		data, err := os.ReadFile(filepath.Join(dest, p))
+		if err != nil || string(data) != "hello" {
+			t.Errorf("%s = %q, %v; want %q", p, data, err, "hello")
+		}
+	}
+	for link, want := range map[string]string{"lib": "/usr/lib", "dangling": "a/missing"} {
+		if got, err := os.Readlink(filepath.Join(dest, link)); err != nil || got != want {
+			t.Errorf("%s -> %q, %v; want %q", link, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+// Package doctor checks whether a host is ready for a MIMO update and
+// explains how to fix what is not. `mimo doctor` prints the full report;
+// `mimo update --sys` runs the same checks and refuses to start on failures.
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"mimo/internal/decompress"
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/manifest"
+	"mimo/internal/spdk"
+	"mimo/internal/system"
+)
+
+// Status 单项检查的结果
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Result 单项检查的结果及修复建议
+type Result struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// Input 检查所需的资源包信息
+type Input struct {
+	// Bundle 将要安装的资源包，为 nil 时跳过依赖资源包内容的检查
+	Bundle *decompress.Bundle
+	// StagingDir 解压资源包的父目录
+	StagingDir string
+}
+
+const (
+	supportedID      = "ubuntu"
+	supportedVersion = "20.04"
+	supportedArch    = "x86_64"
+
+	modulesDir = "/etc/modules-load.d/"
+	configFile = "config.json"
+
+	// lowSpace 空间满足需要但余量少于此值时告警
+	lowSpace = 1 << 30
+)
+
+// requiredBinaries 更新过程中调用的外部命令及提供它们的软件包
+var requiredBinaries = []struct{ name, pkg string }{
+	{"update-grub", "grub2-common"},
+	{"update-initramfs", "initramfs-tools"},
+	{"systemctl", "systemd"},
+	{"lsof", "lsof"},
+	{"ps", "procps"},
+	{"bash", "bash"},
+	{"dpkg", "dpkg"},
+	{"apt-get", "apt"},
+}
+
+// Run 执行全部检查
+func Run(in Input) []Result {
+	var next *manifest.Manifest
+	if in.Bundle != nil {
+		if data, err := in.Bundle.ReadFile(manifest.FileName); err == nil {
+			next, _ = manifest.Parse(data)
+		}
+	}
+
+	out := []Result{checkRoot(), checkOS(), checkArch()}
+	for _, b := range requiredBinaries {
+		out = append(out, checkBinary(b.name, b.pkg))
+	}
+	out = append(out,
+		checkSpace("space /usr/local", "/usr/local", installSize(next)),
+		checkSpace("space staging", in.StagingDir, stagingSize(next)),
+		checkHugepages(),
+		checkIOMMU(),
+	)
+	out = append(out, checkModules(in.Bundle)...)
+	out = append(out, checkSPDK())
+	return out
+}
+
+// Failed 返回未通过的检查
+func Failed(rs []Result) []Result {
+	var out []Result
+	for _, r := range rs {
+		if r.Status == Fail {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Print 按行输出检查结果；quiet 时省略通过的项
+func Print(w io.Writer, rs []Result, quiet bool) {
+	var pass, warn, fail int
+	for _, r := range rs {
+		switch r.Status {
+		case Pass:
+			pass++
+		case Warn:
+			warn++
+		case Fail:
+			fail++
+		}
+		if quiet && r.Status == Pass {
+			continue
+		}
+		fmt.Fprintf(w, "%-4s  %-20s %s\n", strings.ToUpper(string(r.Status)), r.Name, r.Detail)
+		if r.Hint != "" && r.Status != Pass {
+			fmt.Fprintf(w, "      %-20s fix: %s\n", "", r.Hint)
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d warnings, %d failed\n", pass, warn, fail)
+}
+
+func checkRoot() Result {
+	r := Result{Name: "root"}
+	if uid := os.Geteuid(); uid != 0 {
+		r.Status, r.Detail, r.Hint = Fail, fmt.Sprintf("running as uid %d", uid), "run with sudo"
+		return r
+	}
+	r.Status, r.Detail = Pass, "running as root"
+	return r
+}
+
+// checkOS 检查发行版。安装会覆盖 /etc/os-release，因此优先读取安装前保存的原始文件。
+func checkOS() Result {
+	r := Result{Name: "os"}
+	rel, source, err := system.OSRelease()
+	if err != nil {
+		r.Status, r.Detail = Fail, fmt.Sprintf("cannot read %s: %v", source, err)
+		return r
+	}
+	name := strings.TrimSpace(rel["ID"] + " " + rel["VERSION_ID"])
+	like := " " + rel["ID_LIKE"] + " "
+	switch {
+	case rel["ID"] == supportedID && rel["VERSION_ID"] == supportedVersion:
+		r.Status, r.Detail = Pass, fmt.Sprintf("%s (from %s)", name, source)
+	case rel["ID"] == supportedID || strings.Contains(like, " ubuntu ") || strings.Contains(like, " debian ") || rel["ID"] == "debian":
+		r.Status, r.Detail = Warn, fmt.Sprintf("%s (from %s) has not been tested", name, source)
+		r.Hint = fmt.Sprintf("MIMO is built for %s %s", supportedID, supportedVersion)
+	default:
+		r.Status, r.Detail = Fail, fmt.Sprintf("%s (from %s) is not supported", name, source)
+		r.Hint = fmt.Sprintf("install on %s %s", supportedID, supportedVersion)
+	}
+	return r
+}
+
+func checkArch() Result {
+	r := Result{Name: "arch"}
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		r.Status, r.Detail = Warn, fmt.Sprintf("uname: %v", err)
+		return r
+	}
+	var b strings.Builder
+	for _, c := range u.Machine {
+		if c == 0 {
+			break
+		}
+		b.WriteByte(byte(c))
+	}
+	if m := b.String(); m != supportedArch {
+		r.Status, r.Detail, r.Hint = Fail, m, "the bundle ships "+supportedArch+" binaries (SPDK, Go toolchain)"
+		return r
+	}
+	r.Status, r.Detail = Pass, supportedArch
+	return r
+}
+
+func checkBinary(name, pkg string) Result {
+	r := Result{Name: name}
+	p, err := exec.LookPath(name)
+	if err != nil {
+		r.Status, r.Detail, r.Hint = Fail, "not found in PATH", "apt install "+pkg
+		return r
+	}
+	r.Status, r.Detail = Pass, p
+	return r
+}
+
+// installSize 估算安装到 /usr/local 所需的空间：与已安装清单相同的文件以硬链接共享，不计入
+func installSize(next *manifest.Manifest) int64 {
+	if next == nil {
+		return 0
+	}
+	var installed map[string]*manifest.File
+	if m, err := manifest.Load(manifest.InstalledPath); err == nil {
+		installed = m.ByTarget()
+	}
+	var n int64
+	for _, f := range next.Files {
+		if !strings.HasPrefix(f.Target, "/usr/local/") || f.Unchanged {
+			continue
+		}
+		if old, ok := installed[f.Target]; ok && old.SHA256 == f.SHA256 {
+			continue
+		}
+		n += f.Size
+	}
+	return n
+}
+
+// stagingSize 返回解压资源包所需的空间
+func stagingSize(next *manifest.Manifest) int64 {
+	if next == nil {
+		return 0
+	}
+	return next.UnpackedSize
+}
+
+// checkSpace 检查 dir 所在文件系统的可用空间；need 为 0 表示所需大小未知
+func checkSpace(name, dir string, need int64) Result {
+	r := Result{Name: name}
+	// 目录可能尚不存在，检查最近的已存在上级目录
+	for dir != "/" {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		r.Status, r.Detail = Warn, fmt.Sprintf("statfs %s: %v", dir, err)
+		return r
+	}
+	free := int64(st.Bavail) * int64(st.Bsize)
+	switch {
+	case need > 0 && free < need:
+		r.Status = Fail
+		r.Detail = fmt.Sprintf("%s free in %s, the update needs %s", fileops.FormatSize(free), dir, fileops.FormatSize(need))
+		r.Hint = "free up space in " + dir
+	case free < need+lowSpace:
+		r.Status = Warn
+		r.Detail = fmt.Sprintf("only %s free in %s", fileops.FormatSize(free), dir)
+		if need > 0 {
+			r.Detail += fmt.Sprintf(" (update needs %s)", fileops.FormatSize(need))
+		}
+		r.Hint = "free up space in " + dir
+	default:
+		r.Status, r.Detail = Pass, fmt.Sprintf("%s free in %s", fileops.FormatSize(free), dir)
+		if need > 0 {
+			r.Detail += fmt.Sprintf(", update needs %s", fileops.FormatSize(need))
+		}
+	}
+	return r
+}
+
+func checkHugepages() Result {
+	r := Result{Name: "hugepages"}
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		r.Status, r.Detail = Warn, fmt.Sprintf("cannot read /proc/meminfo: %v", err)
+		return r
+	}
+	info := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), ":"); ok {
+			info[k] = strings.TrimSpace(v)
+		}
+	}
+	total, _ := strconv.Atoi(info["HugePages_Total"])
+	if total == 0 {
+		r.Status, r.Detail = Warn, "no hugepages reserved"
+		r.Hint = "SPDK needs hugepages; reserve them with 'mimo system hugepages set --count N'"
+		return r
+	}
+	r.Status = Pass
+	r.Detail = fmt.Sprintf("%d x %s reserved, %s free", total, info["Hugepagesize"], info["HugePages_Free"])
+	return r
+}
+
+func checkIOMMU() Result {
+	r := Result{Name: "iommu"}
+	groups, _ := os.ReadDir("/sys/kernel/iommu_groups")
+	if len(groups) == 0 {
+		r.Status, r.Detail = Warn, "IOMMU is not enabled"
+		r.Hint = "enable VT-d/AMD-Vi in the BIOS and add intel_iommu=on iommu=pt to the kernel command line"
+		return r
+	}
+	r.Status, r.Detail = Pass, fmt.Sprintf("%d IOMMU groups", len(groups))
+	return r
+}
+
+// checkModules 检查资源包中 modules-load.d 配置列出的内核模块；
+// 没有资源包时检查已安装的配置
+func checkModules(b *decompress.Bundle) []Result {
+	names, source := bundleModules(b)
+	if names == nil {
+		names, source = installedModules()
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	var out []Result
+	for _, m := range names {
+		r := Result{Name: "module " + m}
+		switch {
+		case exists(filepath.Join("/sys/module", strings.ReplaceAll(m, "-", "_"))):
+			r.Status, r.Detail = Pass, "loaded"
+		case exec.Command("modprobe", "-n", "-q", m).Run() == nil:
+			r.Status, r.Detail = Warn, "available but not loaded (listed in "+source+", loaded at boot)"
+			r.Hint = "modprobe " + m
+		default:
+			r.Status, r.Detail = Warn, "not available for kernel "+kernelRelease()
+			r.Hint = "install the driver package (e.g. MLNX_OFED) that provides " + m
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func bundleModules(b *decompress.Bundle) ([]string, string) {
+	if b == nil {
+		return nil, ""
+	}
+	data, err := b.ReadFile(configFile)
+	if err != nil {
+		return nil, ""
+	}
+	cfg, err := fileops.ParseConfig(data)
+	if err != nil {
+		return nil, ""
+	}
+	for _, m := range cfg.FileMappings {
+		if !strings.HasPrefix(m.Dst, modulesDir) {
+			continue
+		}
+		src := strings.TrimPrefix(filepath.Clean(m.Src), env.StagingRoot+"/")
+		if data, err := b.ReadFile(src); err == nil {
+			return parseModules(data), m.Dst
+		}
+	}
+	return nil, ""
+}
+
+func installedModules() ([]string, string) {
+	p := filepath.Join(modulesDir, "rdma-nvme.conf")
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, ""
+	}
+	return parseModules(data), p
+}
+
+func parseModules(data []byte) []string {
+	out := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		out = append(out, strings.Fields(line)[0])
+	}
+	return out
+}
+
+func kernelRelease() string {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return "?"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func checkSPDK() Result {
+	r := Result{Name: "spdk"}
+	if spdk.IsRunning() {
+		r.Status, r.Detail = Warn, "MIMO is running on "+spdk.SPDKSock()
+		r.Hint = "--sys replaces files without restarting MIMO; use 'mimo update --target' to restart it safely"
+		return r
+	}
+	r.Status, r.Detail = Pass, "MIMO is not running"
+	return r
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
@@ -14,17 +14,24 @@ import (
 
 const (
 	defaultMimoRoot = "/usr/local/mimo"
-	profilePath     = "/etc/profile.d/mimo_root.sh"
+	ProfilePath     = "/etc/profile.d/mimo_root.sh"
 	defaultVersion  = "v0.0.0"
 )
 
-type VersionMapping struct {
-	Src string `json:"src"`
-	Dst string `json:"dst"`
-}
+// StagingRoot 是 config.json 中 src 路径的前缀：打包时约定的解压目录，
+// 更新时由 RebaseSrc 换算为实际的暂存目录
+const StagingRoot = "/tmp/mimo-output"
 
-type VersionConfig struct {
-	Version []VersionMapping `json:"version"`
+// RebaseSrc 将位于 StagingRoot 下的 src 换算到暂存目录 dir，其它路径原样返回
+func RebaseSrc(src, dir string) string {
+	src = filepath.Clean(src)
+	if src == StagingRoot {
+		return filepath.Clean(dir)
+	}
+	if rel, ok := strings.CutPrefix(src, StagingRoot+"/"); ok {
+		return filepath.Join(dir, rel)
+	}
+	return src
 }
 
 func MustBeRoot() {
@@ -46,6 +53,14 @@ func ConfirmPrompt(msg string) bool {
 	return strings.ToLower(strings.TrimSpace(line)) == "y"
 }
 
+// MimoRoot returns MIMO_ROOT or the default, without persisting anything.
+func MimoRoot() string {
+	if r := os.Getenv("MIMO_ROOT"); r != "" {
+		return r
+	}
+	return defaultMimoRoot
+}
+
 // EnsureMimoRoot ensures MIMO_ROOT is set. If absent, set to default and try to persist.
 // On persistence failure a warning is logged but function continues.
 func EnsureMimoRoot() string {
@@ -58,7 +73,7 @@ func EnsureMimoRoot() string {
 		fmt.Printf("INFO: MIMO_ROOT set to %s\n", mimoRoot)
 
 		content := fmt.Sprintf("export MIMO_ROOT=%s\n", mimoRoot)
-		if err := os.WriteFile(filepath.Clean(profilePath), []byte(content), 0644); err != nil {
+		if err := os.WriteFile(filepath.Clean(ProfilePath), []byte(content), 0644); err != nil {
 			log.Printf("WARN: failed to persist MIMO_ROOT (will continue): %v", err)
 		} else {
 			fmt.Println("INFO: MIMO_ROOT persisted")
@@ -67,35 +82,12 @@ func EnsureMimoRoot() string {
 	return mimoRoot
 }
 
-// LoadFileOpsConfig loads file operations config from path. Fatal on error (preserve original behavior).
+// LoadFileOpsConfig loads and validates config.json from path, migrating
+// schema 1 files. Fatal on error (preserve original behavior).
 func LoadFileOpsConfig(path string) *fileops.Config {
-	cleanPath := filepath.Clean(path)
-	data, err := os.ReadFile(cleanPath)
-	if err != nil {
-		log.Fatalf("ERROR: failed to read %s: %v", cleanPath, err)
-	}
-
-	cfg := &fileops.Config{}
-	if err := json.Unmarshal(data, cfg); err != nil {
-		log.Fatalf("ERROR: failed to parse %s: %v", cleanPath, err)
-	}
-	return cfg
-}
-
-// LoadVersionConfig loads version mapping config from path. Fatal on error or malformed content.
-func LoadVersionConfig(path string) *VersionConfig {
-	cleanPath := filepath.Clean(path)
-	data, err := os.ReadFile(cleanPath)
+	cfg, err := fileops.LoadConfig(filepath.Clean(path))
 	if err != nil {
-		log.Fatalf("ERROR: failed to read %s: %v", cleanPath, err)
-	}
-
-	cfg := &VersionConfig{}
-	if err := json.Unmarshal(data, cfg); err != nil {
-		log.Fatalf("ERROR: failed to parse %s: %v", cleanPath, err)
-	}
-	if len(cfg.Version) < 2 {
-		log.Fatalf("ERROR: version config malformed: need src and dst entries")
+		log.Fatalf("ERROR: %v", err)
 	}
 	return cfg
 }
@@ -107,6 +99,11 @@ func ReadMimoVersion(path string) string {
 	if err != nil {
 		return defaultVersion
 	}
+	return ParseMimoVersion(data)
+}
+
+// ParseMimoVersion returns the MIMO field of VERSION.json content, or defaultVersion.
+func ParseMimoVersion(data []byte) string {
 	var v struct {
 		MIMO string `json:"MIMO"`
 	}
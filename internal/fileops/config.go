@@ -0,0 +1,557 @@
+package fileops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigSchema is the config.json format written by this version. Files
+// without a "schema" field are version 1 and are migrated when loaded.
+const ConfigSchema = 2
+
+// Mapping types
+const (
+	TypeFile    = "file"
+	TypeDir     = "dir"
+	TypeSymlink = "symlink"
+)
+
+// NewSuffix is appended to a config file's destination when the user has
+// edited the installed copy; the shipped version is written next to it.
+const NewSuffix = ".mimo-new"
+
+// FileMapping installs Src at Dst. Everything except Src and Dst is
+// optional and only available with schema 2.
+type FileMapping struct {
+	Src string `json:"src,omitempty"`
+	Dst string `json:"dst"`
+	// Type is file, dir or symlink; empty means whatever Src is
+	Type string `json:"type,omitempty"`
+	// Target is what a symlink mapping points to (symlinks have no Src)
+	Target string `json:"target,omitempty"`
+	// Mode is an octal permission applied to every installed file, e.g. "0755"
+	Mode string `json:"mode,omitempty"`
+	// Owner and Group are names or numeric ids applied to installed files
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+	// Include and Exclude select files of a dir mapping, see Selects
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Config marks configuration files: a copy the user edited is kept and
+	// the shipped version is written next to it with NewSuffix
+	Config bool `json:"config,omitempty"`
+	// Template marks a file rendered with text/template on the node before
+	// it is installed
+	Template bool `json:"template,omitempty"`
+	// When limits the mapping to hosts that meet the condition
+	When *Condition `json:"when,omitempty"`
+}
+
+// Condition decides on the node whether a mapping is installed
+type Condition struct {
+	// Exists installs the mapping only if this path exists
+	Exists string `json:"exists,omitempty"`
+	// OS installs the mapping only on these systems: "ubuntu" or "ubuntu 20.04"
+	OS []string `json:"os,omitempty"`
+}
+
+// VersionMapping names the version file in the bundle and where the
+// installed one lives
+type VersionMapping struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// DefaultHookTimeout applies to hooks that do not set a timeout
+const DefaultHookTimeout = 5 * time.Minute
+
+// Hook is a script shipped in the bundle and run as part of the update
+type Hook struct {
+	// Name is shown in the plan and history; defaults to the script name
+	Name string `json:"name,omitempty"`
+	// Script and Undo are bundle paths like src; Undo runs on rollback
+	Script string `json:"script"`
+	Undo   string `json:"undo,omitempty"`
+	// Timeout is a Go duration such as "30s"
+	Timeout string            `json:"timeout,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// Hooks run before the files are installed (Pre) and after the update is
+// otherwise complete (Post)
+type Hooks struct {
+	Pre  []Hook `json:"pre,omitempty"`
+	Post []Hook `json:"post,omitempty"`
+}
+
+// Packages lists the .deb packages the bundle depends on and where the
+// bundle keeps them
+type Packages struct {
+	// Repo is the bundle directory holding the .deb files, a path like src
+	Repo    string    `json:"repo"`
+	Require []Package `json:"require"`
+}
+
+// Package is one dependency
+type Package struct {
+	Name string `json:"name"`
+	// Version is the minimum version; empty accepts any installed version
+	Version string `json:"version,omitempty"`
+	// Optional packages are installed when possible; failures only warn
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Hugepages is the hugepage reservation the bundle needs, applied by
+// update --sys
+type Hugepages struct {
+	// Size is "2M" or "1G"
+	Size string `json:"size"`
+	// Count is the number of pages, per NUMA node when PerNUMA is set
+	Count   int  `json:"count"`
+	PerNUMA bool `json:"per_numa,omitempty"`
+}
+
+// Config is the parsed config.json
+type Config struct {
+	Schema   int             `json:"schema"`
+	Version  *VersionMapping `json:"version,omitempty"`
+	Hooks    *Hooks          `json:"hooks,omitempty"`
+	Packages *Packages       `json:"packages,omitempty"`
+	// Hugepages is optional; without it update --sys leaves hugepages alone
+	Hugepages *Hugepages `json:"hugepages,omitempty"`
+	// Values are default template variables, overridden on the node
+	Values       map[string]string `json:"values,omitempty"`
+	FileMappings []FileMapping     `json:"file_mappings"`
+}
+
+// Host describes the node conditions are evaluated against
+type Host struct {
+	// ID and VersionID as in os-release, e.g. "ubuntu" and "20.04"
+	ID        string
+	VersionID string
+}
+
+// Skipped is a mapping left out because its condition does not hold
+type Skipped struct {
+	Mapping FileMapping
+	Reason  string
+}
+
+// LoadConfig reads and parses the config.json at path
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig decodes and validates config.json. Version 1 files (no schema
+// field, "version" as a [src, dst] array) are converted to the current schema.
+func ParseConfig(data []byte) (*Config, error) {
+	var raw struct {
+		Schema       int             `json:"schema"`
+		Version      json.RawMessage `json:"version"`
+		FileMappings json.RawMessage `json:"file_mappings"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	switch {
+	case raw.Schema == 0:
+		return parseV1(raw.Version, raw.FileMappings)
+	case raw.Schema > ConfigSchema:
+		return nil, fmt.Errorf("config schema %d is newer than this mimo supports (%d); update mimo first", raw.Schema, ConfigSchema)
+	case raw.Schema < 0:
+		return nil, fmt.Errorf("invalid config schema %d", raw.Schema)
+	}
+
+	// schema 2: reject unknown fields so typos do not go unnoticed
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// parseV1 converts the original format, whose version section is read by
+// position: the first entry's src is the bundled file, the second's dst the
+// installed one. Schema stays 0 so callers can tell the file was migrated.
+func parseV1(version, mappings json.RawMessage) (*Config, error) {
+	cfg := &Config{}
+	if len(mappings) > 0 {
+		var v1 []struct {
+			Src string `json:"src"`
+			Dst string `json:"dst"`
+		}
+		if err := json.Unmarshal(mappings, &v1); err != nil {
+			return nil, fmt.Errorf("parse config: file_mappings: %w", err)
+		}
+		for _, m := range v1 {
+			cfg.FileMappings = append(cfg.FileMappings, FileMapping{Src: m.Src, Dst: m.Dst})
+		}
+	}
+	if len(version) > 0 && string(version) != "null" {
+		var v1 []VersionMapping
+		if err := json.Unmarshal(version, &v1); err != nil {
+			return nil, fmt.Errorf("parse config: version: %w", err)
+		}
+		vm := &VersionMapping{}
+		for _, e := range v1 {
+			if vm.Src == "" {
+				vm.Src = e.Src
+			}
+			if e.Dst != "" {
+				vm.Dst = e.Dst
+			}
+		}
+		cfg.Version = vm
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks every mapping and reports all problems at once
+func (c *Config) Validate() error {
+	var problems []string
+	bad := func(i int, m FileMapping, format string, args ...any) {
+		name := m.Dst
+		if name == "" {
+			name = m.Src
+		}
+		problems = append(problems, fmt.Sprintf("file_mappings[%d] (%s): %s", i, name, fmt.Sprintf(format, args...)))
+	}
+	seen := map[string]int{}
+	for i, m := range c.FileMappings {
+		switch {
+		case m.Dst == "":
+			bad(i, m, "dst is required")
+		case !filepath.IsAbs(m.Dst):
+			bad(i, m, "dst must be an absolute path")
+		default:
+			if j, ok := seen[filepath.Clean(m.Dst)]; ok {
+				bad(i, m, "dst already used by file_mappings[%d]", j)
+			}
+			seen[filepath.Clean(m.Dst)] = i
+		}
+		switch m.Type {
+		case "", TypeFile, TypeDir:
+			if m.Src == "" {
+				bad(i, m, "src is required")
+			}
+			if m.Target != "" {
+				bad(i, m, "target is only valid for type %q", TypeSymlink)
+			}
+		case TypeSymlink:
+			if m.Src != "" {
+				bad(i, m, "a symlink has no src, use target")
+			}
+			if m.Target == "" {
+				bad(i, m, "target is required for a symlink")
+			}
+			if m.Mode != "" || m.Config || m.Template || len(m.Include)+len(m.Exclude) > 0 {
+				bad(i, m, "mode, config, template, include and exclude do not apply to symlinks")
+			}
+		default:
+			bad(i, m, "unknown type %q (want %s, %s or %s)", m.Type, TypeFile, TypeDir, TypeSymlink)
+		}
+		if m.Mode != "" {
+			if _, err := parseMode(m.Mode); err != nil {
+				bad(i, m, "%v", err)
+			}
+		}
+		if m.Type == TypeFile && len(m.Include)+len(m.Exclude) > 0 {
+			bad(i, m, "include and exclude only apply to directories")
+		}
+		if m.Type == TypeDir && m.Template {
+			bad(i, m, "template only applies to files")
+		}
+		for _, p := range append(append([]string{}, m.Include...), m.Exclude...) {
+			if _, err := path.Match(p, ""); err != nil || p == "" || strings.HasPrefix(p, "/") {
+				bad(i, m, "invalid pattern %q", p)
+			}
+		}
+		if m.When != nil {
+			if m.When.Exists != "" && !filepath.IsAbs(m.When.Exists) {
+				bad(i, m, "when.exists must be an absolute path")
+			}
+			for _, o := range m.When.OS {
+				if f := strings.Fields(o); len(f) == 0 || len(f) > 2 {
+					bad(i, m, "when.os entry %q is not \"<id>\" or \"<id> <version>\"", o)
+				}
+			}
+		}
+	}
+	if c.Version != nil && (c.Version.Src == "") != (c.Version.Dst == "") {
+		problems = append(problems, "version: src and dst are both required")
+	}
+	if c.Packages != nil {
+		if c.Packages.Repo == "" && len(c.Packages.Require) > 0 {
+			problems = append(problems, "packages: repo is required")
+		}
+		names := map[string]bool{}
+		for i, p := range c.Packages.Require {
+			switch {
+			case p.Name == "" || strings.ContainsAny(p.Name, " _/"):
+				problems = append(problems, fmt.Sprintf("packages.require[%d]: invalid package name %q", i, p.Name))
+			case names[p.Name]:
+				problems = append(problems, fmt.Sprintf("packages.require[%d]: %s is listed twice", i, p.Name))
+			}
+			names[p.Name] = true
+		}
+	}
+	if h := c.Hugepages; h != nil {
+		if h.Size != "2M" && h.Size != "1G" {
+			problems = append(problems, fmt.Sprintf("hugepages: size %q is not 2M or 1G", h.Size))
+		}
+		if h.Count <= 0 {
+			problems = append(problems, "hugepages: count must be positive")
+		}
+	}
+	if c.Hooks != nil {
+		phases := []struct {
+			name  string
+			hooks []Hook
+		}{{"pre", c.Hooks.Pre}, {"post", c.Hooks.Post}}
+		for _, ph := range phases {
+			for i, h := range ph.hooks {
+				for _, p := range h.check() {
+					problems = append(problems, fmt.Sprintf("hooks.%s[%d] (%s): %s", ph.name, i, h.Title(), p))
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (h Hook) check() []string {
+	var problems []string
+	if h.Script == "" {
+		problems = append(problems, "script is required")
+	}
+	if h.Timeout != "" {
+		if d, err := time.ParseDuration(h.Timeout); err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("timeout %q is not a positive duration like \"30s\"", h.Timeout))
+		}
+	}
+	for k := range h.Env {
+		if k == "" || strings.ContainsAny(k, "= ") {
+			problems = append(problems, fmt.Sprintf("invalid env name %q", k))
+		}
+	}
+	return problems
+}
+
+// Title returns the hook's name, or its script name if it has none
+func (h Hook) Title() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return path.Base(h.Script)
+}
+
+// TimeoutDuration returns the hook's timeout or DefaultHookTimeout
+func (h Hook) TimeoutDuration() time.Duration {
+	if d, err := time.ParseDuration(h.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultHookTimeout
+}
+
+// Marshal returns the config in the current schema
+func (c *Config) Marshal() ([]byte, error) {
+	out := *c
+	out.Schema = ConfigSchema
+	data, err := json.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 07777 {
+		return 0, fmt.Errorf("mode %q is not an octal permission like \"0644\"", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// FileMode returns the mode set on the mapping, if any
+func (m FileMapping) FileMode() (os.FileMode, bool) {
+	if m.Mode == "" {
+		return 0, false
+	}
+	mode, err := parseMode(m.Mode)
+	return mode, err == nil
+}
+
+// Selects reports whether the file at rel (slash separated, relative to Src)
+// is installed by a dir mapping. A pattern matches the path, any of its parent
+// directories, or - without a slash - the base name; exclusions win.
+func (m FileMapping) Selects(rel string) bool {
+	if len(m.Include) > 0 && !matchAny(m.Include, rel) {
+		return false
+	}
+	return !matchAny(m.Exclude, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if !strings.Contains(p, "/") {
+			if ok, _ := path.Match(p, path.Base(rel)); ok {
+				return true
+			}
+		}
+		for q := rel; q != "." && q != "/"; q = path.Dir(q) {
+			if ok, _ := path.Match(p, q); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check reports whether the condition holds on h and why not
+func (c *Condition) Check(h Host) (bool, string) {
+	if c == nil {
+		return true, ""
+	}
+	if c.Exists != "" {
+		if _, err := os.Lstat(c.Exists); err != nil {
+			return false, c.Exists + " does not exist"
+		}
+	}
+	if len(c.OS) > 0 {
+		ok := false
+		for _, o := range c.OS {
+			f := strings.Fields(o)
+			if len(f) > 0 && f[0] == h.ID && (len(f) == 1 || f[1] == h.VersionID) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false, fmt.Sprintf("host is %s %s, mapping is for %s", h.ID, h.VersionID, strings.Join(c.OS, ", "))
+		}
+	}
+	return true, ""
+}
+
+// Applicable returns a copy of c without the mappings whose condition does
+// not hold on h, and the mappings that were left out
+func (c *Config) Applicable(h Host) (*Config, []Skipped) {
+	out := *c
+	out.FileMappings = nil
+	var skipped []Skipped
+	for _, m := range c.FileMappings {
+		if ok, why := m.When.Check(h); !ok {
+			skipped = append(skipped, Skipped{Mapping: m, Reason: why})
+			continue
+		}
+		out.FileMappings = append(out.FileMappings, m)
+	}
+	return &out, skipped
+}
+
+// Owns reports whether target is installed by one of c's mappings
+func (c *Config) Owns(target string) bool {
+	for _, m := range c.FileMappings {
+		if within(target, filepath.Clean(m.Dst)) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupOwner resolves owner and group names (or numeric ids); -1 leaves
+// the id unchanged
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		if n, err := strconv.Atoi(owner); err == nil {
+			uid = n
+		} else {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, fmt.Errorf("owner %s: %w", owner, err)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if group != "" {
+		if n, err := strconv.Atoi(group); err == nil {
+			gid = n
+		} else {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("group %s: %w", group, err)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// Chown applies owner and group (names or ids, empty leaves as is) to p
+func Chown(p, owner, group string) error {
+	if owner == "" && group == "" {
+		return nil
+	}
+	uid, gid, err := lookupOwner(owner, group)
+	if err != nil {
+		return err
+	}
+	if err := os.Lchown(p, uid, gid); err != nil {
+		return fmt.Errorf("chown %s: %w", p, err)
+	}
+	return nil
+}
+
+// ownerState returns "" when fi is owned as requested, otherwise the difference
+func ownerState(fi os.FileInfo, owner, group string) string {
+	if owner == "" && group == "" {
+		return ""
+	}
+	uid, gid, err := lookupOwner(owner, group)
+	if err != nil {
+		return err.Error()
+	}
+	cur, ok := statOwner(fi)
+	if !ok {
+		return ""
+	}
+	if (uid >= 0 && cur[0] != uid) || (gid >= 0 && cur[1] != gid) {
+		return fmt.Sprintf("owner %d:%d, expected %s:%s", cur[0], cur[1], orAny(owner), orAny(group))
+	}
+	return ""
+}
+
+func orAny(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
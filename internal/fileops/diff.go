@@ -0,0 +1,179 @@
+package fileops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxDiffLines limits how many individual paths a directory diff lists
+const maxDiffLines = 20
+
+// FileDigest returns the hex SHA-256 of a regular file
+func FileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DescribeCopy reports, without modifying anything, what copying src over
+// dst would change.
+func DescribeCopy(src, dst string) []string {
+	src = filepath.Clean(src)
+	dst = filepath.Clean(dst)
+
+	sfi, err := os.Stat(src)
+	if err != nil {
+		return []string{fmt.Sprintf("source missing: %v", err)}
+	}
+	dfi, err := os.Stat(dst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if sfi.IsDir() {
+				n, size := countTree(src)
+				return []string{fmt.Sprintf("new directory: %d files, %d bytes", n, size)}
+			}
+			return []string{fmt.Sprintf("new file: %d bytes, mode %s", sfi.Size(), sfi.Mode().Perm())}
+		}
+		return []string{fmt.Sprintf("cannot stat destination: %v", err)}
+	}
+
+	if !sfi.IsDir() {
+		if dfi.IsDir() {
+			return []string{"replaces existing directory with a file"}
+		}
+		return []string{describeFile(src, dst, sfi, dfi)}
+	}
+	if !dfi.IsDir() {
+		return []string{"replaces existing file with a directory"}
+	}
+	return describeDir(src, dst)
+}
+
+func describeFile(src, dst string, sfi, dfi os.FileInfo) string {
+	same, err := sameContent(src, dst, sfi, dfi)
+	if err != nil {
+		return fmt.Sprintf("cannot compare: %v", err)
+	}
+	mode := ""
+	if sfi.Mode().Perm() != dfi.Mode().Perm() {
+		mode = fmt.Sprintf(", mode %s -> %s", dfi.Mode().Perm(), sfi.Mode().Perm())
+	}
+	if same {
+		if mode != "" {
+			return "content unchanged" + mode
+		}
+		return "unchanged"
+	}
+	if sfi.Size() == dfi.Size() {
+		return fmt.Sprintf("changed: content differs (%d bytes)%s", sfi.Size(), mode)
+	}
+	return fmt.Sprintf("changed: size %d -> %d bytes%s", dfi.Size(), sfi.Size(), mode)
+}
+
+func sameContent(src, dst string, sfi, dfi os.FileInfo) (bool, error) {
+	if sfi.Size() != dfi.Size() {
+		return false, nil
+	}
+	a, err := FileDigest(src)
+	if err != nil {
+		return false, err
+	}
+	b, err := FileDigest(dst)
+	if err != nil {
+		return false, err
+	}
+	return a == b, nil
+}
+
+func describeDir(src, dst string) []string {
+	var added, changed, removed []string
+	unchanged := 0
+
+	seen := map[string]bool{}
+	_ = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(src, path)
+		seen[rel] = true
+		target := filepath.Join(dst, rel)
+		tfi, err := os.Stat(target)
+		if err != nil {
+			added = append(added, rel)
+			return nil
+		}
+		if same, err := sameContent(path, target, info, tfi); err == nil && same && info.Mode().Perm() == tfi.Mode().Perm() {
+			unchanged++
+			return nil
+		}
+		changed = append(changed, rel)
+		return nil
+	})
+	_ = filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dst, path)
+		if !seen[rel] {
+			removed = append(removed, rel)
+		}
+		return nil
+	})
+
+	out := []string{fmt.Sprintf("directory: %d new, %d changed, %d removed, %d unchanged",
+		len(added), len(changed), len(removed), unchanged)}
+	out = append(out, listPaths("+", added)...)
+	out = append(out, listPaths("~", changed)...)
+	out = append(out, listPaths("-", removed)...)
+	return out
+}
+
+func listPaths(prefix string, paths []string) []string {
+	sort.Strings(paths)
+	var out []string
+	for i, p := range paths {
+		if i == maxDiffLines {
+			out = append(out, fmt.Sprintf("%s ... and %d more", prefix, len(paths)-maxDiffLines))
+			break
+		}
+		out = append(out, prefix+" "+p)
+	}
+	return out
+}
+
+func countTree(dir string) (files int, size int64) {
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size
+}
+
+// FormatSize formats a byte count with binary units (KiB, MiB, ...)
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
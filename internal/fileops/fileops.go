@@ -2,6 +2,8 @@
 Changes:
 - Implement CopyFile and CopyDir with proper path cleaning and mode preservation.
 - Provide RegisterCopyActions to register copy/undo actions into a Transaction.
+- Undo restores a snapshot of the replaced destination instead of deleting it.
+- Copy actions honour the schema 2 mapping options (type, mode, owner, filters, config).
 - Purpose: make file copy operations reusable and transactional.
 */
 package fileops
@@ -16,27 +18,6 @@ import (
 	"mimo/internal/transaction"
 )
 
-type FileMapping struct {
-	Src string `json:"src"`
-	Dst string `json:"dst"`
-}
-
-type Config struct {
-	FileMappings []FileMapping `json:"file_mappings"`
-}
-
-func LoadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, err
-	}
-	return &cfg, nil
-}
-
 // CopyFile copies a single file from src to dst, creating parent dirs and preserving file mode.
 func CopyFile(src, dst string) error {
 	src = filepath.Clean(src)
@@ -120,43 +101,184 @@ func CopyDir(src, dst string) error {
 }
 
 // RegisterCopyActions registers copy actions described by cfg into txn.
-// Each action Do performs copy; Undo removes the destination.
+// Each action Do snapshots the destination and then copies; Undo restores
+// the snapshot, bringing back exactly what was there before.
 func RegisterCopyActions(txn *transaction.Transaction, cfg *Config) error {
 	if txn == nil || cfg == nil {
 		return fmt.Errorf("nil txn or cfg")
 	}
+	stateDir, err := txn.StateDir()
+	if err != nil {
+		return err
+	}
+	backupDir := filepath.Join(stateDir, "snapshots")
 	for _, m := range cfg.FileMappings {
-		src := filepath.Clean(m.Src)
-		dst := filepath.Clean(m.Dst)
-		name := fmt.Sprintf("copy %s -> %s", src, dst)
-
-		// capture variables for closure
-		s, d := src, dst
-		action := &transaction.Action{
-			Name: name,
-			Do: func() error {
-				info, err := os.Stat(s)
-				if err != nil {
-					return fmt.Errorf("stat source %s: %w", s, err)
-				}
-				// remove existing dst first to ensure clean replace
-				_ = os.RemoveAll(d)
-				if info.IsDir() {
-					return CopyDir(s, d)
-				}
-				return CopyFile(s, d)
-			},
-			Undo: func() error {
-				// best-effort remove destination
-				if err := os.RemoveAll(d); err != nil {
-					return fmt.Errorf("undo remove %s: %w", d, err)
-				}
-				return nil
-			},
+		txn.Add(copyAction(m, backupDir))
+	}
+	return nil
+}
+
+// copyAction replaces m.Dst with a copy of m.Src, snapshotting the old content
+func copyAction(m FileMapping, backupDir string) *transaction.Action {
+	if m.Type == TypeSymlink {
+		return linkAction(m, backupDir)
+	}
+	s := filepath.Clean(m.Src)
+	dst := filepath.Clean(m.Dst)
+	kept := m.Config && editedConfig(s, dst)
+	if kept {
+		dst += NewSuffix
+	}
+	snap := NewSnapshot(dst, backupDir)
+	return &transaction.Action{
+		Name: fmt.Sprintf("copy %s -> %s", s, snap.Path),
+		Kind: undoSnapshotKind,
+		State: func() (any, error) {
+			return snap, nil
+		},
+		Describe: func() []string {
+			if kept {
+				return []string{fmt.Sprintf("%s was edited locally and is kept", filepath.Clean(m.Dst))}
+			}
+			return DescribeCopy(s, snap.Path)
+		},
+		Do: func() error {
+			info, err := os.Stat(s)
+			if err != nil {
+				return fmt.Errorf("stat source %s: %w", s, err)
+			}
+			// move existing dst aside so it can be restored
+			if err := snap.Take(); err != nil {
+				return fmt.Errorf("snapshot %s: %w", snap.Path, err)
+			}
+			if info.IsDir() {
+				return copyMapping(m, s, snap.Path)
+			}
+			if err := CopyFile(s, snap.Path); err != nil {
+				return err
+			}
+			if kept {
+				fmt.Printf("WARN: kept locally modified %s, new version written to %s\n", filepath.Clean(m.Dst), snap.Path)
+			}
+			return applyMapping(snap.Path, m)
+		},
+		Undo: snap.Restore,
+	}
+}
+
+// editedConfig reports whether dst is a regular file that differs from src.
+// Without a manifest there is no record of what was installed, so any
+// difference counts as a local edit.
+func editedConfig(src, dst string) bool {
+	sfi, err := os.Stat(src)
+	if err != nil || !sfi.Mode().IsRegular() {
+		return false
+	}
+	dfi, err := os.Lstat(dst)
+	if err != nil || !dfi.Mode().IsRegular() {
+		return false
+	}
+	same, err := sameContent(src, dst, sfi, dfi)
+	return err == nil && !same
+}
+
+// copyMapping copies the files of a dir mapping selected by its include and
+// exclude patterns, applying its mode and owner
+func copyMapping(m FileMapping, src, dst string) error {
+	err := filepath.Walk(src, func(p string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		if !m.Selects(filepath.ToSlash(rel)) {
+			return nil
+		}
+		if err := CopyFile(p, target); err != nil {
+			return err
+		}
+		return applyMapping(target, m)
+	})
+	if err != nil {
+		return fmt.Errorf("copydir %s -> %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+// applyMapping sets the mode and owner requested by m on an installed file
+func applyMapping(p string, m FileMapping) error {
+	if err := Chown(p, m.Owner, m.Group); err != nil {
+		return err
+	}
+	// chmod after chown: chown clears setuid/setgid bits
+	if mode, ok := m.FileMode(); ok {
+		if err := os.Chmod(p, mode); err != nil {
+			return fmt.Errorf("chmod %s: %w", p, err)
 		}
-		txn.Add(action)
 	}
 	return nil
 }
 
+// linkAction points m.Dst at m.Target, snapshotting what was there
+func linkAction(m FileMapping, backupDir string) *transaction.Action {
+	snap := NewSnapshot(filepath.Clean(m.Dst), backupDir)
+	return &transaction.Action{
+		Name: fmt.Sprintf("link %s -> %s", snap.Path, m.Target),
+		Kind: undoSnapshotKind,
+		State: func() (any, error) {
+			return snap, nil
+		},
+		Describe: func() []string {
+			cur, err := os.Readlink(snap.Path)
+			switch {
+			case err == nil && cur == m.Target:
+				return []string{"unchanged"}
+			case err == nil:
+				return []string{fmt.Sprintf("changed: was a link to %s", cur)}
+			}
+			if _, err := os.Lstat(snap.Path); err == nil {
+				return []string{"replaces the existing file"}
+			}
+			return []string{"new link"}
+		},
+		Do: func() error {
+			if err := snap.Take(); err != nil {
+				return fmt.Errorf("snapshot %s: %w", snap.Path, err)
+			}
+			if err := os.MkdirAll(filepath.Dir(snap.Path), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(m.Target, snap.Path); err != nil {
+				return err
+			}
+			return Chown(snap.Path, m.Owner, m.Group)
+		},
+		Undo: snap.Restore,
+	}
+}
+
+// linkCurrent reports whether m.Dst already is the requested symlink
+func linkCurrent(m FileMapping) bool {
+	cur, err := os.Readlink(filepath.Clean(m.Dst))
+	return err == nil && cur == m.Target
+}
+
 // ========== 辅助函数 ==========
+
+const undoSnapshotKind = "fileops.snapshot"
+
+func init() {
+	transaction.RegisterUndo(undoSnapshotKind, func(raw json.RawMessage) error {
+		var snap Snapshot
+		if err := json.Unmarshal(raw, &snap); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return snap.Restore()
+	})
+}
@@ -0,0 +1,337 @@
+package fileops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Snapshot records what a path looked like before it was replaced, so that
+// exactly that state (contents, modes, ownership, symlinks) can be restored.
+//
+// The backup is moved aside with rename when possible and copied otherwise.
+// A small meta file next to the backup tracks progress, which keeps Restore
+// correct even when the process died halfway through taking the snapshot.
+type Snapshot struct {
+	Path   string `json:"path"`
+	Backup string `json:"backup"`
+}
+
+type snapshotMeta struct {
+	Existed  bool `json:"existed"`
+	Complete bool `json:"complete"`
+}
+
+// NewSnapshot returns a snapshot of path whose backup lives under dir.
+// Nothing is touched until Take is called.
+func NewSnapshot(path, dir string) *Snapshot {
+	path = filepath.Clean(path)
+	sum := sha256.Sum256([]byte(path))
+	return &Snapshot{
+		Path:   path,
+		Backup: filepath.Join(filepath.Clean(dir), hex.EncodeToString(sum[:8])),
+	}
+}
+
+// Take moves the current content of Path into the backup location. When Take
+// returns, Path no longer exists.
+func (s *Snapshot) Take() error {
+	if _, err := os.Lstat(s.Path); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("stat %s: %w", s.Path, err)
+		}
+		return s.writeMeta(snapshotMeta{Existed: false, Complete: true})
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Backup), 0700); err != nil {
+		return fmt.Errorf("mkdir backup dir: %w", err)
+	}
+	_ = os.RemoveAll(s.Backup)
+	if err := s.writeMeta(snapshotMeta{Existed: true}); err != nil {
+		return err
+	}
+	if err := os.Rename(s.Path, s.Backup); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("move %s aside: %w", s.Path, err)
+		}
+		// different filesystem: copy, then drop the original
+		if err := CopyTree(s.Path, s.Backup); err != nil {
+			return fmt.Errorf("backup %s: %w", s.Path, err)
+		}
+		if err := s.writeMeta(snapshotMeta{Existed: true, Complete: true}); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(s.Path); err != nil {
+			return fmt.Errorf("remove %s: %w", s.Path, err)
+		}
+		return nil
+	}
+	return s.writeMeta(snapshotMeta{Existed: true, Complete: true})
+}
+
+// Restore puts the snapshotted state back in place of whatever is at Path now.
+// It is safe to call whether Take finished, was interrupted, or never ran.
+func (s *Snapshot) Restore() error {
+	meta, err := s.readMeta()
+	if err != nil {
+		if os.IsNotExist(err) {
+			// snapshot never started, Path was not touched
+			return nil
+		}
+		return err
+	}
+	if !meta.Existed {
+		if err := os.RemoveAll(s.Path); err != nil {
+			return fmt.Errorf("remove %s: %w", s.Path, err)
+		}
+		return nil
+	}
+	if !meta.Complete {
+		// a rename is atomic: if the backup exists and Path is gone, it happened
+		_, pathErr := os.Lstat(s.Path)
+		_, bakErr := os.Lstat(s.Backup)
+		if !(os.IsNotExist(pathErr) && bakErr == nil) {
+			// partial copy, original still in place
+			_ = os.RemoveAll(s.Backup)
+			return nil
+		}
+	}
+	if err := os.RemoveAll(s.Path); err != nil {
+		return fmt.Errorf("remove %s: %w", s.Path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(s.Path), err)
+	}
+	if err := os.Rename(s.Backup, s.Path); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("restore %s: %w", s.Path, err)
+		}
+		if err := CopyTree(s.Backup, s.Path); err != nil {
+			return fmt.Errorf("restore %s: %w", s.Path, err)
+		}
+		_ = os.RemoveAll(s.Backup)
+	}
+	return nil
+}
+
+func (s *Snapshot) metaPath() string {
+	return s.Backup + ".json"
+}
+
+func (s *Snapshot) writeMeta(m snapshotMeta) error {
+	if err := os.MkdirAll(filepath.Dir(s.Backup), 0700); err != nil {
+		return fmt.Errorf("mkdir backup dir: %w", err)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.metaPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("write snapshot meta: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("write snapshot meta: %w", err)
+	}
+	return f.Sync()
+}
+
+func (s *Snapshot) readMeta() (snapshotMeta, error) {
+	var m snapshotMeta
+	data, err := os.ReadFile(s.metaPath())
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("parse snapshot meta: %w", err)
+	}
+	return m, nil
+}
+
+// CopyTree copies src to dst without following symlinks, preserving modes,
+// ownership and modification times. src may be a file, directory or symlink.
+func CopyTree(src, dst string) error {
+	src = filepath.Clean(src)
+	dst = filepath.Clean(dst)
+
+	type dirTime struct {
+		path string
+		info os.FileInfo
+	}
+	var dirs []dirTime
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			// modes and times are applied after the contents are written
+			dirs = append(dirs, dirTime{target, info})
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return lchown(target, info)
+		case info.Mode().IsRegular():
+			if err := copyRegular(path, target, info); err != nil {
+				return err
+			}
+			return applyAttrs(target, info)
+		default:
+			// devices, sockets and fifos are not expected in managed paths
+			return fmt.Errorf("unsupported file type %s", path)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("copytree %s -> %s: %w", src, dst, err)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := applyAttrs(dirs[i].path, dirs[i].info); err != nil {
+			return fmt.Errorf("copytree %s -> %s: %w", src, dst, err)
+		}
+	}
+	return nil
+}
+
+// LinkTree recreates the directory tree src at dst with regular files
+// hard-linked instead of copied, so an unchanged file costs no data writes.
+// Files must be replaced (not modified in place) afterwards, otherwise the
+// change shows up in both trees. src itself may be a symlink to a directory.
+func LinkTree(src, dst string) error {
+	src, err := filepath.EvalSymlinks(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	dst = filepath.Clean(dst)
+
+	var dirs []string
+	var infos []os.FileInfo
+	err = filepath.Walk(src, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			dirs, infos = append(dirs, target), append(infos, info)
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return lchown(target, info)
+		case info.Mode().IsRegular():
+			if err := os.Link(path, target); err != nil {
+				if !errors.Is(err, syscall.EXDEV) {
+					return err
+				}
+				if err := copyRegular(path, target, info); err != nil {
+					return err
+				}
+				return applyAttrs(target, info)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unsupported file type %s", path)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("linktree %s -> %s: %w", src, dst, err)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := applyAttrs(dirs[i], infos[i]); err != nil {
+			return fmt.Errorf("linktree %s -> %s: %w", src, dst, err)
+		}
+	}
+	return nil
+}
+
+func copyRegular(src, dst string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// applyAttrs sets mode, owner and mtime of path from info
+func applyAttrs(path string, info os.FileInfo) error {
+	if err := lchown(path, info); err != nil {
+		return err
+	}
+	// chmod after chown: chown clears setuid/setgid bits
+	if err := os.Chmod(path, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	mtime := info.ModTime()
+	return os.Chtimes(path, time.Now(), mtime)
+}
+
+func lchown(path string, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if err := os.Lchown(path, int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
+
+// statOwner returns the uid and gid recorded in info
+func statOwner(info os.FileInfo) ([2]int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return [2]int{}, false
+	}
+	return [2]int{int(st.Uid), int(st.Gid)}, true
+}
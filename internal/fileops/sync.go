@@ -0,0 +1,421 @@
+package fileops
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mimo/internal/manifest"
+	"mimo/internal/transaction"
+)
+
+const undoSyncKind = "fileops.sync"
+
+// syncPlan is what it takes to bring one mapping destination in line with
+// the manifest of the next version.
+type syncPlan struct {
+	src, dst  string
+	out       string // where files are written, dst unless redirected
+	added     []manifest.File
+	changed   []manifest.File
+	removed   []string
+	unchanged int
+	// kept are config files edited locally; the shipped version is written
+	// next to them with NewSuffix
+	kept []manifest.File
+}
+
+// syncState is journaled so recovery can put every touched path back
+type syncState struct {
+	Snapshots []*Snapshot `json:"snapshots"`
+}
+
+// RegisterSyncActions registers one action per mapping that copies only the
+// files whose content or mode differs from the manifest, and deletes files
+// the installed version shipped but next no longer does.
+//
+// stage is the directory the bundle was extracted to. Without an installed
+// manifest nothing is known about orphans, so mappings are copied whole.
+//
+// redirect maps a mapping destination to the directory the files are
+// actually written to (a new install slot seeded from the destination);
+// comparisons are still made against the destination.
+func RegisterSyncActions(txn *transaction.Transaction, cfg *Config, stage string, next, installed *manifest.Manifest, redirect map[string]string) error {
+	if txn == nil || cfg == nil || next == nil {
+		return fmt.Errorf("nil txn, cfg or manifest")
+	}
+	if next.IsDelta() && installed == nil {
+		return fmt.Errorf("delta bundle for %s requires an installed manifest; install a full bundle first", next.BaseVersion)
+	}
+	stateDir, err := txn.StateDir()
+	if err != nil {
+		return err
+	}
+	backupDir := filepath.Join(stateDir, "snapshots")
+
+	if installed == nil {
+		fmt.Println("WARN: no installed manifest, copying all mappings")
+		for _, m := range cfg.FileMappings {
+			if out, ok := redirect[filepath.Clean(m.Dst)]; ok {
+				m.Dst = out
+			}
+			txn.Add(copyAction(m, backupDir))
+		}
+		return nil
+	}
+
+	plans, orphans, err := planSync(cfg, stage, next, installed)
+	if err != nil {
+		return err
+	}
+	for _, m := range cfg.FileMappings {
+		if m.Type != TypeSymlink {
+			continue
+		}
+		if linkCurrent(m) {
+			fmt.Printf("INFO: %s unchanged (link to %s)\n", filepath.Clean(m.Dst), m.Target)
+			continue
+		}
+		txn.Add(linkAction(m, backupDir))
+	}
+	for _, p := range plans {
+		if len(p.added)+len(p.changed)+len(p.removed)+len(p.kept) == 0 {
+			fmt.Printf("INFO: %s unchanged (%d files)\n", p.dst, p.unchanged)
+			continue
+		}
+		if out, ok := redirect[p.dst]; ok {
+			p.out = filepath.Clean(out)
+		}
+		txn.Add(syncAction(p, stage, backupDir))
+	}
+	if len(orphans) > 0 {
+		txn.Add(syncAction(&syncPlan{dst: "/", out: "/", removed: orphans}, stage, backupDir))
+	}
+	return nil
+}
+
+// planSync compares next with the files on disk. Files of the installed
+// manifest that are missing from next and not under any mapping are
+// returned separately.
+func planSync(cfg *Config, stage string, next, installed *manifest.Manifest) ([]*syncPlan, []string, error) {
+	var plans []*syncPlan
+	var dsts []string
+	for _, m := range cfg.FileMappings {
+		if m.Type == TypeSymlink {
+			continue
+		}
+		p := &syncPlan{src: filepath.Clean(m.Src), dst: filepath.Clean(m.Dst)}
+		p.out = p.dst
+		plans = append(plans, p)
+		dsts = append(dsts, p.dst)
+	}
+	owner := func(target string) *syncPlan {
+		best := -1
+		for i, d := range dsts {
+			if within(target, d) && (best < 0 || len(d) > len(dsts[best])) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return nil
+		}
+		return plans[best]
+	}
+
+	was := installed.ByTarget()
+	fmt.Printf("INFO: comparing %d files with the installed version...\n", len(next.Files))
+	var stale []string
+	shipped := map[string]bool{}
+	for _, f := range next.Files {
+		if f.Target == "" {
+			continue
+		}
+		shipped[f.Target] = true
+		p := owner(f.Target)
+		if p == nil {
+			continue
+		}
+		state := diskState(f)
+		switch {
+		case state == "":
+			p.unchanged++
+		case f.Unchanged:
+			// a delta bundle does not carry this file, it must already be right
+			stale = append(stale, fmt.Sprintf("%s: %s", f.Target, state))
+		case state == "missing":
+			p.added = append(p.added, f)
+		default:
+			if _, err := os.Stat(filepath.Join(stage, filepath.FromSlash(f.Path))); err != nil {
+				return nil, nil, fmt.Errorf("bundle file %s: %w", f.Path, err)
+			}
+			if f.Config && editedLocally(f, was[f.Target]) {
+				p.kept = append(p.kept, f)
+				continue
+			}
+			p.changed = append(p.changed, f)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		return nil, nil, fmt.Errorf("delta bundle expects files of %s, but the installed files differ:\n  %s",
+			next.BaseVersion, strings.Join(stale, "\n  "))
+	}
+
+	var orphans []string
+	for _, f := range installed.Files {
+		if f.Target == "" || shipped[f.Target] {
+			continue
+		}
+		if _, err := os.Lstat(f.Target); err != nil {
+			continue
+		}
+		if p := owner(f.Target); p != nil {
+			p.removed = append(p.removed, f.Target)
+		} else {
+			orphans = append(orphans, f.Target)
+		}
+	}
+	sort.Strings(orphans)
+	return plans, orphans, nil
+}
+
+// editedLocally reports whether the file at f.Target differs both from what
+// the installed version shipped (old, may be nil) and from f
+func editedLocally(f manifest.File, old *manifest.File) bool {
+	sum, err := FileDigest(f.Target)
+	if err != nil || sum == f.SHA256 {
+		return false
+	}
+	return old == nil || sum != old.SHA256
+}
+
+// diskState returns "" when target matches f, otherwise why it does not
+func diskState(f manifest.File) string {
+	fi, err := os.Lstat(f.Target)
+	switch {
+	case os.IsNotExist(err):
+		return "missing"
+	case err != nil:
+		return err.Error()
+	case !fi.Mode().IsRegular():
+		return "not a regular file"
+	case fi.Size() != f.Size:
+		return fmt.Sprintf("size %d, expected %d", fi.Size(), f.Size)
+	case fi.Mode().Perm() != f.Mode.Perm():
+		return fmt.Sprintf("mode %s, expected %s", fi.Mode().Perm(), f.Mode.Perm())
+	}
+	if st := ownerState(fi, f.Owner, f.Group); st != "" {
+		return st
+	}
+	sum, err := FileDigest(f.Target)
+	if err != nil {
+		return err.Error()
+	}
+	if sum != f.SHA256 {
+		return "content differs"
+	}
+	return ""
+}
+
+// topMissing returns the outermost ancestor of path (or path itself) that
+// does not exist, so that undoing a new file also removes new directories.
+func topMissing(path string) string {
+	top := path
+	for dir := filepath.Dir(path); dir != top; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		top = dir
+	}
+	return top
+}
+
+func syncAction(p *syncPlan, stage, backupDir string) *transaction.Action {
+	// outPath maps a target under dst to the path actually written
+	outPath := func(target string) string {
+		if p.out == p.dst {
+			return target
+		}
+		return filepath.Join(p.out, strings.TrimPrefix(target, p.dst))
+	}
+	var snaps []*Snapshot
+	seen := map[string]bool{}
+	snapshot := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			snaps = append(snaps, NewSnapshot(path, backupDir))
+		}
+	}
+	for _, f := range p.changed {
+		snapshot(outPath(f.Target))
+	}
+	for _, f := range p.added {
+		// a redirected tree is seeded from dst, so what is missing there is missing in out
+		snapshot(outPath(topMissing(f.Target)))
+	}
+	for _, t := range p.removed {
+		snapshot(outPath(t))
+	}
+	for _, f := range p.kept {
+		snapshot(outPath(f.Target) + NewSuffix)
+	}
+	copies := append(append([]manifest.File{}, p.changed...), p.added...)
+
+	name := fmt.Sprintf("sync %s -> %s", p.src, p.out)
+	if p.src == "" {
+		name = "remove files no longer shipped"
+	}
+	return &transaction.Action{
+		Name: name,
+		Kind: undoSyncKind,
+		State: func() (any, error) {
+			return syncState{Snapshots: snaps}, nil
+		},
+		Describe: func() []string {
+			return describeSync(p)
+		},
+		Do: func() error {
+			for _, s := range snaps {
+				if err := s.Take(); err != nil {
+					return fmt.Errorf("snapshot %s: %w", s.Path, err)
+				}
+			}
+			for _, f := range copies {
+				if err := installFile(stage, f, outPath(f.Target)); err != nil {
+					return err
+				}
+			}
+			for _, f := range p.kept {
+				if err := installFile(stage, f, outPath(f.Target)+NewSuffix); err != nil {
+					return err
+				}
+				fmt.Printf("WARN: kept locally modified %s, new version written to %s%s\n", f.Target, f.Target, NewSuffix)
+			}
+			if p.src != "" {
+				// orphans outside any mapping live in system dirs, leave those alone
+				for _, t := range p.removed {
+					pruneEmptyDirs(filepath.Dir(outPath(t)), p.out)
+				}
+			}
+			return nil
+		},
+		Undo: func() error {
+			return restoreAll(snaps)
+		},
+	}
+}
+
+// installFile copies f from the extracted bundle to dst with f's owner
+func installFile(stage string, f manifest.File, dst string) error {
+	if err := CopyFile(filepath.Join(stage, filepath.FromSlash(f.Path)), dst); err != nil {
+		return err
+	}
+	if err := Chown(dst, f.Owner, f.Group); err != nil {
+		return err
+	}
+	return os.Chmod(dst, f.Mode.Perm())
+}
+
+func describeSync(p *syncPlan) []string {
+	var added, changed []string
+	for _, f := range p.added {
+		added = append(added, f.Target)
+	}
+	for _, f := range p.changed {
+		changed = append(changed, f.Target)
+	}
+	out := []string{fmt.Sprintf("files: %d new, %d changed, %d removed, %d unchanged",
+		len(added), len(changed), len(p.removed), p.unchanged)}
+	for _, f := range p.kept {
+		out = append(out, fmt.Sprintf("! %s edited locally, kept; new version written to %s%s", f.Target, f.Target, NewSuffix))
+	}
+	if p.out != p.dst {
+		out = append(out, fmt.Sprintf("compared with %s, written to %s", p.dst, p.out))
+	}
+	out = append(out, listPaths("+", added)...)
+	out = append(out, listPaths("~", changed)...)
+	out = append(out, listPaths("-", append([]string{}, p.removed...))...)
+	return out
+}
+
+// pruneEmptyDirs removes dir and its parents while they are empty, stopping at root
+func pruneEmptyDirs(dir, root string) {
+	for within(dir, root) && dir != root {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// restoreAll restores snapshots in reverse order, continuing past failures
+func restoreAll(snaps []*Snapshot) error {
+	var errs []string
+	for i := len(snaps) - 1; i >= 0; i-- {
+		if err := snaps[i].Restore(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("restore failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func within(path, dir string) bool {
+	if dir == "/" {
+		return strings.HasPrefix(path, "/")
+	}
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// RegisterManifestAction records m as the installed manifest at path, or
+// removes a stale record when m is nil. Undo restores the previous record.
+func RegisterManifestAction(txn *transaction.Transaction, path string, m *manifest.Manifest) error {
+	stateDir, err := txn.StateDir()
+	if err != nil {
+		return err
+	}
+	snap := NewSnapshot(path, filepath.Join(stateDir, "snapshots"))
+	name := "record installed manifest"
+	if m == nil {
+		name = "remove installed manifest"
+	}
+	txn.Add(&transaction.Action{
+		Name: name,
+		Kind: undoSnapshotKind,
+		State: func() (any, error) {
+			return snap, nil
+		},
+		Describe: func() []string {
+			if m == nil {
+				return []string{"bundle has no manifest, next update copies everything"}
+			}
+			return []string{fmt.Sprintf("%s: version %s, %d files", path, m.Version, len(m.Files))}
+		},
+		Do: func() error {
+			if err := snap.Take(); err != nil {
+				return fmt.Errorf("snapshot %s: %w", path, err)
+			}
+			if m == nil {
+				return nil
+			}
+			return m.Save(path)
+		},
+		Undo: snap.Restore,
+	})
+	return nil
+}
+
+func init() {
+	transaction.RegisterUndo(undoSyncKind, func(raw json.RawMessage) error {
+		var st syncState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return restoreAll(st.Snapshots)
+	})
+}
@@ -0,0 +1,244 @@
+package fileops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"mimo/internal/manifest"
+)
+
+// spec is one manifest file, rel is relative to the test root
+type spec struct {
+	rel, content string
+	config       bool
+	unchanged    bool
+	mode         os.FileMode
+}
+
+// planWant is the expected plan of one mapping, targets relative to the test root
+type planWant struct {
+	added, changed, removed, kept []string
+	unchanged                     int
+}
+
+func TestPlanSync(t *testing.T) {
+	tests := []struct {
+		name string
+		// disk holds the files present on the node
+		disk      map[string]string
+		installed []spec
+		next      []spec
+		delta     bool
+		// noStage leaves these bundle files out of the extracted bundle
+		noStage []string
+		want    map[string]planWant
+		orphans []string
+		wantErr string
+	}{
+		{
+			name: "added changed removed unchanged",
+			disk: map[string]string{"app/a": "v1", "app/b": "v1", "app/old": "v1", "app/etc/c.conf": "v1"},
+			installed: []spec{
+				{rel: "app/a", content: "v1"}, {rel: "app/b", content: "v1"},
+				{rel: "app/old", content: "v1"}, {rel: "app/etc/c.conf", content: "v1"},
+				{rel: "app/gone", content: "v1"},
+			},
+			next: []spec{
+				{rel: "app/a", content: "v1"}, {rel: "app/b", content: "v2"},
+				{rel: "app/new", content: "v1"}, {rel: "app/etc/c.conf", content: "v1"},
+			},
+			want: map[string]planWant{
+				"app":     {added: []string{"app/new"}, changed: []string{"app/b"}, removed: []string{"app/old"}, unchanged: 1},
+				"app/etc": {unchanged: 1},
+			},
+		},
+		{
+			name:      "mode differs",
+			disk:      map[string]string{"app/a": "v1"},
+			installed: []spec{{rel: "app/a", content: "v1"}},
+			next:      []spec{{rel: "app/a", content: "v1", mode: 0755}},
+			want:      map[string]planWant{"app": {changed: []string{"app/a"}}},
+		},
+		{
+			name:      "removed under nested mapping",
+			disk:      map[string]string{"app/etc/x.conf": "v1"},
+			installed: []spec{{rel: "app/etc/x.conf", content: "v1"}},
+			want:      map[string]planWant{"app/etc": {removed: []string{"app/etc/x.conf"}}},
+		},
+		{
+			name:      "orphans outside every mapping",
+			disk:      map[string]string{"other/x": "v1", "other/y": "v1"},
+			installed: []spec{{rel: "other/y", content: "v1"}, {rel: "other/x", content: "v1"}, {rel: "other/gone", content: "v1"}},
+			orphans:   []string{"other/x", "other/y"},
+		},
+		{
+			name:      "config edited locally is kept",
+			disk:      map[string]string{"app/etc/c.conf": "local"},
+			installed: []spec{{rel: "app/etc/c.conf", content: "v1", config: true}},
+			next:      []spec{{rel: "app/etc/c.conf", content: "v2", config: true}},
+			want:      map[string]planWant{"app/etc": {kept: []string{"app/etc/c.conf"}}},
+		},
+		{
+			name:      "config not edited is updated",
+			disk:      map[string]string{"app/etc/c.conf": "v1"},
+			installed: []spec{{rel: "app/etc/c.conf", content: "v1", config: true}},
+			next:      []spec{{rel: "app/etc/c.conf", content: "v2", config: true}},
+			want:      map[string]planWant{"app/etc": {changed: []string{"app/etc/c.conf"}}},
+		},
+		{
+			name: "config without an installed record is kept",
+			disk: map[string]string{"app/etc/c.conf": "site"},
+			next: []spec{{rel: "app/etc/c.conf", content: "v2", config: true}},
+			want: map[string]planWant{"app/etc": {kept: []string{"app/etc/c.conf"}}},
+		},
+		{
+			name:      "delta unchanged file matches",
+			disk:      map[string]string{"app/a": "v1", "app/b": "v1"},
+			installed: []spec{{rel: "app/a", content: "v1"}, {rel: "app/b", content: "v1"}},
+			next:      []spec{{rel: "app/a", content: "v1", unchanged: true}, {rel: "app/b", content: "v2"}},
+			delta:     true,
+			want:      map[string]planWant{"app": {changed: []string{"app/b"}, unchanged: 1}},
+		},
+		{
+			name:      "delta unchanged file drifted",
+			disk:      map[string]string{"app/a": "hacked"},
+			installed: []spec{{rel: "app/a", content: "v1"}},
+			next:      []spec{{rel: "app/a", content: "v1", unchanged: true}},
+			delta:     true,
+			wantErr:   "delta bundle expects files of 1.0",
+		},
+		{
+			name:      "delta unchanged file missing",
+			installed: []spec{{rel: "app/a", content: "v1"}},
+			next:      []spec{{rel: "app/a", content: "v1", unchanged: true}},
+			delta:     true,
+			wantErr:   "missing",
+		},
+		{
+			name:      "changed file missing from the bundle",
+			disk:      map[string]string{"app/a": "v1"},
+			installed: []spec{{rel: "app/a", content: "v1"}},
+			next:      []spec{{rel: "app/a", content: "v2"}},
+			noStage:   []string{"app/a"},
+			wantErr:   "bundle file file/app/a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			stage := filepath.Join(root, "stage")
+			abs := func(rel string) string { return filepath.Join(root, rel) }
+			for rel, content := range tt.disk {
+				writeFile(t, abs(rel), content)
+			}
+			manifestOf := func(specs []spec) *manifest.Manifest {
+				m := &manifest.Manifest{Schema: manifest.Schema, Version: "1.0"}
+				for _, s := range specs {
+					sum := sha256.Sum256([]byte(s.content))
+					mode := s.mode
+					if mode == 0 {
+						mode = 0644
+					}
+					m.Files = append(m.Files, manifest.File{
+						Path: "file/" + s.rel, Target: abs(s.rel), Size: int64(len(s.content)), Mode: mode,
+						SHA256: hex.EncodeToString(sum[:]), Config: s.config, Unchanged: s.unchanged,
+					})
+				}
+				return m
+			}
+			installed := manifestOf(tt.installed)
+			next := manifestOf(tt.next)
+			next.Version = "2.0"
+			if tt.delta {
+				next.BaseVersion = "1.0"
+			}
+			for _, s := range tt.next {
+				if !s.unchanged && !slices.Contains(tt.noStage, s.rel) {
+					writeFile(t, filepath.Join(stage, "file", s.rel), s.content)
+				}
+			}
+			cfg := &Config{FileMappings: []FileMapping{
+				{Src: "file/app", Dst: abs("app"), Type: TypeDir},
+				{Src: "file/app/etc", Dst: abs("app/etc"), Type: TypeDir},
+				{Dst: abs("link"), Type: TypeSymlink, Target: abs("app")},
+			}}
+
+			plans, orphans, err := planSync(cfg, stage, next, installed)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("planSync error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rels := func(paths []string) []string {
+				var out []string
+				for _, p := range paths {
+					rel, _ := filepath.Rel(root, p)
+					out = append(out, rel)
+				}
+				sort.Strings(out)
+				return out
+			}
+			targets := func(fs []manifest.File) []string {
+				var out []string
+				for _, f := range fs {
+					out = append(out, f.Target)
+				}
+				return rels(out)
+			}
+			if len(plans) != 2 {
+				t.Fatalf("planSync returned %d plans, want one per non-symlink mapping", len(plans))
+			}
+			for _, p := range plans {
+				dst, _ := filepath.Rel(root, p.dst)
+				w := tt.want[dst]
+				got := planWant{
+					added: targets(p.added), changed: targets(p.changed), removed: rels(p.removed),
+					kept: targets(p.kept), unchanged: p.unchanged,
+				}
+				for _, c := range []struct {
+					what      string
+					got, want []string
+				}{
+					{"added", got.added, w.added},
+					{"changed", got.changed, w.changed},
+					{"removed", got.removed, w.removed},
+					{"kept", got.kept, w.kept},
+				} {
+					if !slices.Equal(c.got, c.want) {
+						t.Errorf("%s %s = %q, want %q", dst, c.what, c.got, c.want)
+					}
+				}
+				if got.unchanged != w.unchanged {
+					t.Errorf("%s unchanged = %d, want %d", dst, got.unchanged, w.unchanged)
+				}
+			}
+			if got := rels(orphans); !slices.Equal(got, tt.orphans) {
+				t.Errorf("orphans = %q, want %q", got, tt.orphans)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, p, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(p, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,253 @@
+package fileops
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mimo/internal/manifest"
+	"mimo/internal/transaction"
+)
+
+// Kinds of drift between the installed manifest and the disk
+const (
+	DriftModified = "modified"
+	DriftMissing  = "missing"
+	DriftExtra    = "extra"
+	// DriftEdited is a config file changed locally; updates keep such
+	// edits, so it is reported but not repaired
+	DriftEdited = "edited"
+)
+
+// Drift is one installed path that does not match the installed manifest
+type Drift struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+	// Attrs is set when only mode or owner differ; the content is intact
+	Attrs bool           `json:"-"`
+	File  *manifest.File `json:"-"`
+}
+
+// Repairable reports whether repair restores d
+func (d Drift) Repairable() bool {
+	return d.Kind == DriftModified || d.Kind == DriftMissing
+}
+
+// Verify compares every installed file of m with the disk (content, mode
+// and owner), and lists the files under cfg's directory mappings that m
+// does not know about. The result is sorted by path.
+func Verify(cfg *Config, m *manifest.Manifest) ([]Drift, error) {
+	var out []Drift
+	known := map[string]bool{}
+	for i := range m.Files {
+		f := &m.Files[i]
+		if f.Target == "" {
+			continue
+		}
+		known[f.Target] = true
+		if f.Config {
+			known[f.Target+NewSuffix] = true
+		}
+		if d, ok := checkFile(f); !ok {
+			out = append(out, d)
+		}
+	}
+
+	extra, err := extraFiles(cfg, known)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, extra...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// checkFile returns the drift of f's target, ok is true when it matches
+func checkFile(f *manifest.File) (Drift, bool) {
+	d := Drift{Kind: DriftModified, Path: f.Target, File: f}
+	fi, err := os.Lstat(f.Target)
+	switch {
+	case os.IsNotExist(err):
+		d.Kind = DriftMissing
+		return d, false
+	case err != nil:
+		d.Detail = err.Error()
+		return d, false
+	case !fi.Mode().IsRegular():
+		d.Detail = "not a regular file"
+		return d, false
+	}
+
+	content := ""
+	if fi.Size() != f.Size {
+		content = fmt.Sprintf("size %d, expected %d", fi.Size(), f.Size)
+	} else if sum, err := FileDigest(f.Target); err != nil {
+		content = err.Error()
+	} else if sum != f.SHA256 {
+		content = "content differs"
+	}
+	var attrs []string
+	if fi.Mode().Perm() != f.Mode.Perm() {
+		attrs = append(attrs, fmt.Sprintf("mode %s, expected %s", fi.Mode().Perm(), f.Mode.Perm()))
+	}
+	if st := ownerState(fi, f.Owner, f.Group); st != "" {
+		attrs = append(attrs, st)
+	}
+
+	switch {
+	case content != "" && f.Config:
+		d.Kind, d.Detail = DriftEdited, content
+	case content != "":
+		d.Detail = strings.Join(append([]string{content}, attrs...), "; ")
+	case len(attrs) > 0:
+		d.Detail, d.Attrs = strings.Join(attrs, "; "), true
+	default:
+		return d, true
+	}
+	return d, false
+}
+
+// extraFiles walks every directory mapping of cfg and returns the files the
+// mapping would install (per include/exclude) that are not in known. Files
+// under a nested mapping are left to that mapping.
+func extraFiles(cfg *Config, known map[string]bool) ([]Drift, error) {
+	var dsts []string
+	for _, m := range cfg.FileMappings {
+		if m.Type != TypeSymlink {
+			dsts = append(dsts, filepath.Clean(m.Dst))
+		}
+	}
+	owner := func(p string) string {
+		best := ""
+		for _, d := range dsts {
+			if within(p, d) && len(d) > len(best) {
+				best = d
+			}
+		}
+		return best
+	}
+
+	var out []Drift
+	for _, m := range cfg.FileMappings {
+		dst := filepath.Clean(m.Dst)
+		if m.Type == TypeSymlink {
+			continue
+		}
+		// the mapping may be a link to the active version slot
+		root, err := filepath.EvalSymlinks(dst)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+			continue
+		}
+		err = filepath.WalkDir(root, func(p string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if e.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dst, rel)
+			if known[target] || owner(target) != dst || !m.Selects(filepath.ToSlash(rel)) {
+				return nil
+			}
+			out = append(out, Drift{Kind: DriftExtra, Path: target})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", dst, err)
+		}
+	}
+	return out, nil
+}
+
+// RegisterRepairAction registers one action that restores the repairable
+// drifts: files whose content differs or that are missing are copied from
+// the extracted bundle in stage, files whose mode or owner differ get them
+// reset. Every touched path is snapshotted first so a failure rolls back.
+func RegisterRepairAction(txn *transaction.Transaction, stage string, drifts []Drift) error {
+	stateDir, err := txn.StateDir()
+	if err != nil {
+		return err
+	}
+	backupDir := filepath.Join(stateDir, "snapshots")
+
+	var fix []Drift
+	var snaps []*Snapshot
+	for _, d := range drifts {
+		if !d.Repairable() {
+			continue
+		}
+		if !d.Attrs {
+			if _, err := os.Stat(filepath.Join(stage, filepath.FromSlash(d.File.Path))); err != nil {
+				return fmt.Errorf("bundle file %s: %w", d.File.Path, err)
+			}
+		}
+		p := d.Path
+		if d.Kind == DriftMissing {
+			p = topMissing(p)
+		}
+		fix = append(fix, d)
+		snaps = append(snaps, NewSnapshot(p, backupDir))
+	}
+	if len(fix) == 0 {
+		return nil
+	}
+
+	txn.Add(&transaction.Action{
+		Name: fmt.Sprintf("repair %d installed file(s)", len(fix)),
+		Kind: undoSyncKind,
+		State: func() (any, error) {
+			return syncState{Snapshots: snaps}, nil
+		},
+		Describe: func() []string {
+			out := make([]string, 0, len(fix))
+			for _, d := range fix {
+				switch {
+				case d.Kind == DriftMissing:
+					out = append(out, "+ "+d.Path)
+				case d.Attrs:
+					out = append(out, fmt.Sprintf("~ %s (reset %s)", d.Path, d.Detail))
+				default:
+					out = append(out, "~ "+d.Path)
+				}
+			}
+			return out
+		},
+		Do: func() error {
+			for _, s := range snaps {
+				if err := s.Take(); err != nil {
+					return fmt.Errorf("snapshot %s: %w", s.Path, err)
+				}
+			}
+			for _, d := range fix {
+				var err error
+				if d.Attrs {
+					if err = Chown(d.Path, d.File.Owner, d.File.Group); err == nil {
+						err = os.Chmod(d.Path, d.File.Mode.Perm())
+					}
+				} else {
+					err = installFile(stage, *d.File, d.Path)
+				}
+				if err != nil {
+					return fmt.Errorf("repair %s: %w", d.Path, err)
+				}
+				fmt.Printf("INFO: repaired %s\n", d.Path)
+			}
+			return nil
+		},
+		Undo: func() error {
+			return restoreAll(snaps)
+		},
+	})
+	return nil
+}
@@ -0,0 +1,387 @@
+package grub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DropinDir holds grub defaults snippets sourced after GrubFile
+const DropinDir = "/etc/default/grub.d"
+
+// The variables update-grub joins into the kernel command line:
+// VarLinux applies to every entry, VarDefault to normal boots only
+const (
+	VarLinux   = "GRUB_CMDLINE_LINUX"
+	VarDefault = "GRUB_CMDLINE_LINUX_DEFAULT"
+)
+
+var cmdlineVars = []string{VarLinux, VarDefault}
+
+// Param is one kernel parameter, key or key=value
+type Param struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	// HasValue tells "key=" from a bare "key"
+	HasValue bool `json:"-"`
+}
+
+// ParseParam parses "key" or "key=value"
+func ParseParam(s string) (Param, error) {
+	k, v, ok := strings.Cut(s, "=")
+	if k == "" || strings.ContainsAny(k, " \t\n\"") {
+		return Param{}, fmt.Errorf("invalid kernel parameter %q", s)
+	}
+	return Param{Key: k, Value: v, HasValue: ok}, nil
+}
+
+func (p Param) String() string {
+	if p.HasValue {
+		return p.Key + "=" + p.Value
+	}
+	return p.Key
+}
+
+// splitParams splits a command line on whitespace outside double quotes
+func splitParams(s string) []Param {
+	var out []Param
+	var cur strings.Builder
+	quoted := false
+	flush := func() {
+		if cur.Len() > 0 {
+			p, err := ParseParam(cur.String())
+			if err != nil {
+				// keep what the kernel would see, even if we cannot key it
+				p = Param{Key: cur.String()}
+			}
+			out = append(out, p)
+			cur.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return out
+}
+
+// Join returns ps as a command line
+func Join(ps []Param) string {
+	s := make([]string, len(ps))
+	for i, p := range ps {
+		s[i] = p.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// assignment is one "VAR=value" line of a defaults file
+type assignment struct {
+	file *defaultsFile
+	line int
+	// prefix is everything up to and including "=", suffix what follows the
+	// value (usually a comment); both are kept when the line is rewritten
+	prefix, suffix string
+}
+
+// defaultsFile is a grub defaults file as read from disk
+type defaultsFile struct {
+	path   string
+	lines  []string
+	orig   []byte
+	exists bool
+}
+
+// Cmdline is the kernel command line as configured in GrubFile and its
+// drop-ins. Edits are made on the effective value of each variable and
+// written back to the assignment that takes effect (the last one sourced),
+// so parameters the site added elsewhere are kept.
+type Cmdline struct {
+	files  []*defaultsFile
+	values map[string][]Param
+	// last is the assignment that sets each variable, nil if none does
+	last map[string]*assignment
+	// orig holds the values as read, to report what changed
+	orig map[string][]Param
+}
+
+var assignRe = regexp.MustCompile(`^(\s*(?:export\s+)?(GRUB_CMDLINE_LINUX(?:_DEFAULT)?)=)(.*)$`)
+
+// ReadCmdline reads grubFile and then the *.cfg drop-ins in dropinDir in
+// the order update-grub sources them
+func ReadCmdline(grubFile, dropinDir string) (*Cmdline, error) {
+	paths := []string{grubFile}
+	dropins, err := filepath.Glob(filepath.Join(dropinDir, "*.cfg"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dropins)
+	paths = append(paths, dropins...)
+
+	c := &Cmdline{values: map[string][]Param{}, last: map[string]*assignment{}, orig: map[string][]Param{}}
+	vars := map[string]string{}
+	for _, p := range paths {
+		f := &defaultsFile{path: p}
+		data, err := os.ReadFile(p)
+		switch {
+		case err == nil:
+			f.orig, f.exists = data, true
+			f.lines = strings.Split(string(data), "\n")
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+		c.files = append(c.files, f)
+		for i, line := range f.lines {
+			m := assignRe.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			value, rest, err := shellWord(m[3], vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", p, i+1, err)
+			}
+			vars[m[2]] = value
+			c.last[m[2]] = &assignment{file: f, line: i, prefix: m[1], suffix: rest}
+		}
+	}
+	for _, v := range cmdlineVars {
+		c.values[v] = splitParams(vars[v])
+		c.orig[v] = c.values[v]
+	}
+	return c, nil
+}
+
+// CurrentCmdline reads the command line from the system's grub defaults
+func CurrentCmdline() (*Cmdline, error) {
+	return ReadCmdline(GrubFile, DropinDir)
+}
+
+// shellWord reads the shell word at the start of s (quoted and unquoted
+// parts, $VAR expansion of the cmdline variables) and returns it and the
+// rest of the line
+func shellWord(s string, vars map[string]string) (string, string, error) {
+	var out strings.Builder
+	i := 0
+	expand := func() {
+		j := i + 1
+		name := ""
+		if j < len(s) && s[j] == '{' {
+			if k := strings.IndexByte(s[j:], '}'); k > 0 {
+				name, i = s[j+1:j+k], j+k+1
+			}
+		} else {
+			k := j
+			for k < len(s) && (s[k] == '_' || s[k] >= 'A' && s[k] <= 'Z' || s[k] >= 'a' && s[k] <= 'z' || s[k] >= '0' && s[k] <= '9') {
+				k++
+			}
+			name, i = s[j:k], k
+		}
+		if name == "" {
+			out.WriteByte('$')
+			i = j
+			return
+		}
+		out.WriteString(vars[name])
+	}
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == ';':
+			return out.String(), s[i:], nil
+		case c == '\'':
+			k := strings.IndexByte(s[i+1:], '\'')
+			if k < 0 {
+				return "", "", fmt.Errorf("unterminated quote (multi-line values are not supported)")
+			}
+			out.WriteString(s[i+1 : i+1+k])
+			i += k + 2
+		case c == '"':
+			i++
+			for {
+				if i >= len(s) {
+					return "", "", fmt.Errorf("unterminated quote (multi-line values are not supported)")
+				}
+				c := s[i]
+				if c == '"' {
+					i++
+					break
+				}
+				switch {
+				case c == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0:
+					out.WriteByte(s[i+1])
+					i += 2
+				case c == '$':
+					expand()
+				default:
+					out.WriteByte(c)
+					i++
+				}
+			}
+		case c == '\\' && i+1 < len(s):
+			out.WriteByte(s[i+1])
+			i += 2
+		case c == '$':
+			expand()
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String(), "", nil
+}
+
+// quote returns s as a double-quoted shell word
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+	return `"` + r.Replace(s) + `"`
+}
+
+// Value returns the effective value of variable v
+func (c *Cmdline) Value(v string) []Param {
+	return c.values[v]
+}
+
+// Params returns the parameters of a normal boot, VarLinux first
+func (c *Cmdline) Params() []Param {
+	return append(append([]Param{}, c.values[VarLinux]...), c.values[VarDefault]...)
+}
+
+// Source returns the file that sets v, empty when no file does
+func (c *Cmdline) Source(v string) string {
+	if a := c.last[v]; a != nil {
+		return a.file.path
+	}
+	return ""
+}
+
+// Lookup returns the parameters with key and the variable each is in
+func (c *Cmdline) Lookup(key string) (ps []Param, vars []string) {
+	for _, v := range cmdlineVars {
+		for _, p := range c.values[v] {
+			if p.Key == key {
+				ps, vars = append(ps, p), append(vars, v)
+			}
+		}
+	}
+	return ps, vars
+}
+
+// Set makes p the only parameter with its key. It is updated in the
+// variable(s) that already have it; a new parameter is appended to v.
+func (c *Cmdline) Set(p Param, v string) {
+	found := false
+	for _, name := range cmdlineVars {
+		var out []Param
+		seen := false
+		for _, q := range c.values[name] {
+			if q.Key != p.Key {
+				out = append(out, q)
+				continue
+			}
+			if !seen {
+				out = append(out, p)
+				seen, found = true, true
+			}
+		}
+		c.values[name] = out
+	}
+	if !found {
+		c.values[v] = append(append([]Param{}, c.values[v]...), p)
+	}
+}
+
+// Unset removes every parameter with p's key, or only the exact
+// key=value when p has a value (e.g. one of several console= entries)
+func (c *Cmdline) Unset(p Param) {
+	for _, name := range cmdlineVars {
+		var out []Param
+		for _, q := range c.values[name] {
+			if q.Key == p.Key && (!p.HasValue || q == p) {
+				continue
+			}
+			out = append(out, q)
+		}
+		c.values[name] = out
+	}
+}
+
+// Change is the before and after of one variable
+type Change struct {
+	Var, File string
+	Old, New  string
+}
+
+// Changes lists the variables whose value was edited
+func (c *Cmdline) Changes() []Change {
+	var out []Change
+	for _, v := range cmdlineVars {
+		o, n := Join(c.orig[v]), Join(c.values[v])
+		if o == n {
+			continue
+		}
+		file := GrubFile
+		if a := c.last[v]; a != nil {
+			file = a.file.path
+		} else if len(c.files) > 0 {
+			file = c.files[0].path
+		}
+		out = append(out, Change{Var: v, File: file, Old: o, New: n})
+	}
+	return out
+}
+
+// render returns the new content of every file an edit touches. Each
+// changed variable's effective assignment is rewritten with the full new
+// value; a variable no file sets is appended to the first file.
+func (c *Cmdline) render() map[*defaultsFile][]byte {
+	changed := map[*defaultsFile][]string{}
+	lines := func(f *defaultsFile) []string {
+		if l, ok := changed[f]; ok {
+			return l
+		}
+		l := append([]string{}, f.lines...)
+		changed[f] = l
+		return l
+	}
+	for _, ch := range c.Changes() {
+		a := c.last[ch.Var]
+		if a == nil {
+			f := c.files[0]
+			l := lines(f)
+			if n := len(l); n > 0 && l[n-1] == "" {
+				l = l[:n-1]
+			}
+			changed[f] = append(l, ch.Var+"="+quote(ch.New), "")
+			continue
+		}
+		l := lines(a.file)
+		l[a.line] = a.prefix + quote(ch.New) + a.suffix
+	}
+	out := map[*defaultsFile][]byte{}
+	for f, l := range changed {
+		out[f] = []byte(strings.Join(l, "\n"))
+	}
+	return out
+}
+
+// Files returns GrubFile and the drop-ins whose assignment takes effect,
+// the files an edit may rewrite
+func (c *Cmdline) Files() []string {
+	out := []string{c.files[0].path}
+	for _, v := range cmdlineVars {
+		if a := c.last[v]; a != nil && !slices.Contains(out, a.file.path) {
+			out = append(out, a.file.path)
+		}
+	}
+	return out
+}
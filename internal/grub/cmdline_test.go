@@ -0,0 +1,384 @@
+package grub
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mimo/internal/transaction"
+)
+
+// writeDefaults writes files (relative path -> content) under a temporary
+// directory and returns the grub file and drop-in dir to read them from.
+// A nil content leaves the file out.
+func writeDefaults(t *testing.T, files map[string]*string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if content == nil {
+			continue
+		}
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(*content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "grub"), filepath.Join(dir, "grub.d")
+}
+
+func str(s string) *string { return &s }
+
+func TestShellWord(t *testing.T) {
+	vars := map[string]string{VarLinux: "console=ttyS0", "EMPTY": ""}
+	tests := []struct {
+		name, in   string
+		want, rest string
+		wantErr    bool
+	}{
+		{name: "double quoted", in: `"quiet splash"`, want: "quiet splash"},
+		{name: "single quoted", in: `'quiet $X'`, want: "quiet $X"},
+		{name: "unquoted", in: `quiet`, want: "quiet"},
+		{name: "empty", in: `""`, want: ""},
+		{name: "mixed parts", in: `"a b"'c'd`, want: "a bcd"},
+		{name: "escapes in double quotes", in: `"a \"b\" \$c \\ \x"`, want: `a "b" $c \ \x`},
+		{name: "escape unquoted", in: `a\ b`, want: "a b"},
+		{name: "expand", in: `"$GRUB_CMDLINE_LINUX quiet"`, want: "console=ttyS0 quiet"},
+		{name: "expand braces", in: `"${GRUB_CMDLINE_LINUX}x"`, want: "console=ttyS0x"},
+		{name: "expand unquoted", in: `$GRUB_CMDLINE_LINUX`, want: "console=ttyS0"},
+		{name: "expand unset", in: `"$UNSET quiet"`, want: " quiet"},
+		{name: "lone dollar", in: `"a$ b"`, want: "a$ b"},
+		{name: "trailing comment", in: `"quiet" # keep me`, want: "quiet", rest: " # keep me"},
+		{name: "trailing command", in: `"quiet"; export X`, want: "quiet", rest: "; export X"},
+		{name: "unterminated double", in: `"quiet`, wantErr: true},
+		{name: "unterminated single", in: `'quiet`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest, err := shellWord(tt.in, vars)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("shellWord(%q) = %q, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("shellWord(%q): %v", tt.in, err)
+			}
+			if got != tt.want || rest != tt.rest {
+				t.Errorf("shellWord(%q) = %q, %q, want %q, %q", tt.in, got, rest, tt.want, tt.rest)
+			}
+		})
+	}
+}
+
+func TestReadCmdline(t *testing.T) {
+	tests := []struct {
+		name               string
+		files              map[string]*string
+		linux, def         string
+		linuxFrom, defFrom string
+	}{
+		{
+			name: "quoted",
+			files: map[string]*string{"grub": str(`GRUB_DEFAULT=0
+GRUB_CMDLINE_LINUX_DEFAULT="quiet splash"
+GRUB_CMDLINE_LINUX="console=tty0 console=ttyS0,115200n8"
+`)},
+			linux: "console=tty0 console=ttyS0,115200n8", def: "quiet splash",
+			linuxFrom: "grub", defFrom: "grub",
+		},
+		{
+			name:  "unquoted and export",
+			files: map[string]*string{"grub": str("export GRUB_CMDLINE_LINUX=iommu=pt\n  GRUB_CMDLINE_LINUX_DEFAULT=''\n")},
+			linux: "iommu=pt", def: "", linuxFrom: "grub", defFrom: "grub",
+		},
+		{
+			name:  "commented out",
+			files: map[string]*string{"grub": str("#GRUB_CMDLINE_LINUX=\"quiet\"\n")},
+		},
+		{
+			name: "drop-in appends",
+			files: map[string]*string{
+				"grub":          str("GRUB_CMDLINE_LINUX=\"console=ttyS0\"\n"),
+				"grub.d/50.cfg": str("GRUB_CMDLINE_LINUX=\"$GRUB_CMDLINE_LINUX intel_iommu=on\"\n"),
+			},
+			linux: "console=ttyS0 intel_iommu=on", linuxFrom: "grub.d/50.cfg",
+		},
+		{
+			name: "drop-ins in order",
+			files: map[string]*string{
+				"grub":              str("GRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\n"),
+				"grub.d/90-b.cfg":   str("GRUB_CMDLINE_LINUX_DEFAULT=\"${GRUB_CMDLINE_LINUX_DEFAULT} b\"\n"),
+				"grub.d/10-a.cfg":   str("GRUB_CMDLINE_LINUX_DEFAULT=\"$GRUB_CMDLINE_LINUX_DEFAULT a\"\n"),
+				"grub.d/ignored.sh": str("GRUB_CMDLINE_LINUX_DEFAULT=\"nope\"\n"),
+			},
+			def: "quiet a b", defFrom: "grub.d/90-b.cfg",
+		},
+		{
+			name: "drop-in replaces",
+			files: map[string]*string{
+				"grub":          str("GRUB_CMDLINE_LINUX=\"console=ttyS0\"\n"),
+				"grub.d/50.cfg": str("GRUB_CMDLINE_LINUX=\"nomodeset\"\n"),
+			},
+			linux: "nomodeset", linuxFrom: "grub.d/50.cfg",
+		},
+		{
+			name:  "missing grub file",
+			files: map[string]*string{"grub": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grubFile, dropins := writeDefaults(t, tt.files)
+			c, err := ReadCmdline(grubFile, dropins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Dir(grubFile)
+			for _, v := range []struct{ name, want, from string }{
+				{VarLinux, tt.linux, tt.linuxFrom},
+				{VarDefault, tt.def, tt.defFrom},
+			} {
+				if got := Join(c.Value(v.name)); got != v.want {
+					t.Errorf("%s = %q, want %q", v.name, got, v.want)
+				}
+				from := ""
+				if v.from != "" {
+					from = filepath.Join(dir, v.from)
+				}
+				if got := c.Source(v.name); got != from {
+					t.Errorf("%s source = %q, want %q", v.name, got, from)
+				}
+			}
+		})
+	}
+}
+
+func TestReadCmdlineUnterminated(t *testing.T) {
+	grubFile, dropins := writeDefaults(t, map[string]*string{"grub": str("GRUB_CMDLINE_LINUX=\"quiet\n\"\n")})
+	if _, err := ReadCmdline(grubFile, dropins); err == nil {
+		t.Fatal("want error for a multi-line value")
+	}
+}
+
+func param(t *testing.T, s string) Param {
+	t.Helper()
+	p, err := ParseParam(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCmdlineEdit(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]*string
+		edit  func(t *testing.T, c *Cmdline)
+		// want is the content of every file the edit rewrites
+		want       map[string]string
+		linux, def string
+	}{
+		{
+			name:  "set new keeps comment",
+			files: map[string]*string{"grub": str("GRUB_DEFAULT=0\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet splash\" # site default\n")},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "loglevel=0"), VarDefault)
+			},
+			want: map[string]string{"grub": "GRUB_DEFAULT=0\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet splash loglevel=0\" # site default\n"},
+			def:  "quiet splash loglevel=0",
+		},
+		{
+			name:  "set existing in other variable",
+			files: map[string]*string{"grub": str("GRUB_CMDLINE_LINUX=\"\"\nGRUB_CMDLINE_LINUX_DEFAULT='quiet loglevel=3'\n")},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "loglevel=0"), VarLinux)
+			},
+			want: map[string]string{"grub": "GRUB_CMDLINE_LINUX=\"\"\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet loglevel=0\"\n"},
+			def:  "quiet loglevel=0",
+		},
+		{
+			name:  "unquoted value",
+			files: map[string]*string{"grub": str("export GRUB_CMDLINE_LINUX=iommu=pt\n")},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "hugepages=1024"), VarLinux)
+			},
+			want:  map[string]string{"grub": "export GRUB_CMDLINE_LINUX=\"iommu=pt hugepages=1024\"\n"},
+			linux: "iommu=pt hugepages=1024",
+		},
+		{
+			name: "unset key and exact value",
+			files: map[string]*string{"grub": str(
+				"GRUB_CMDLINE_LINUX=\"console=tty0 console=ttyS0 nomodeset quiet\"\n")},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Unset(param(t, "console=tty0"))
+				c.Unset(param(t, "nomodeset"))
+			},
+			want:  map[string]string{"grub": "GRUB_CMDLINE_LINUX=\"console=ttyS0 quiet\"\n"},
+			linux: "console=ttyS0 quiet",
+		},
+		{
+			name: "drop-in re-assigns",
+			files: map[string]*string{
+				"grub":          str("GRUB_CMDLINE_LINUX=\"console=ttyS0\"\n"),
+				"grub.d/50.cfg": str("GRUB_CMDLINE_LINUX=\"$GRUB_CMDLINE_LINUX intel_iommu=on\"\n"),
+			},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "iommu=pt"), VarLinux)
+			},
+			// the effective assignment is rewritten in full, the grub file is left alone
+			want:  map[string]string{"grub.d/50.cfg": "GRUB_CMDLINE_LINUX=\"console=ttyS0 intel_iommu=on iommu=pt\"\n"},
+			linux: "console=ttyS0 intel_iommu=on iommu=pt",
+		},
+		{
+			name: "missing variable appended to first file",
+			files: map[string]*string{
+				"grub":          str("GRUB_DEFAULT=0\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\n"),
+				"grub.d/50.cfg": str("GRUB_TIMEOUT=5\n"),
+			},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "hugepages=8"), VarLinux)
+			},
+			want:  map[string]string{"grub": "GRUB_DEFAULT=0\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\nGRUB_CMDLINE_LINUX=\"hugepages=8\"\n"},
+			linux: "hugepages=8", def: "quiet",
+		},
+		{
+			name:  "missing variable without trailing newline",
+			files: map[string]*string{"grub": str("GRUB_DEFAULT=0")},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "quiet"), VarDefault)
+			},
+			want: map[string]string{"grub": "GRUB_DEFAULT=0\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\n"},
+			def:  "quiet",
+		},
+		{
+			name:  "missing grub file",
+			files: map[string]*string{"grub": nil},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "quiet"), VarDefault)
+			},
+			want: map[string]string{"grub": "GRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\n"},
+			def:  "quiet",
+		},
+		{
+			name:  "special characters are quoted",
+			files: map[string]*string{"grub": str("GRUB_CMDLINE_LINUX=\"\"\n")},
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, `dyndbg="file x.c +p"`), VarLinux)
+				c.Set(param(t, "x=$HOME"), VarLinux)
+			},
+			want:  map[string]string{"grub": "GRUB_CMDLINE_LINUX=\"dyndbg=\\\"file x.c +p\\\" x=\\$HOME\"\n"},
+			linux: `dyndbg="file x.c +p" x=$HOME`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grubFile, dropins := writeDefaults(t, tt.files)
+			dir := filepath.Dir(grubFile)
+			c, err := ReadCmdline(grubFile, dropins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(t, c)
+
+			got := map[string]string{}
+			for f, data := range c.render() {
+				rel, _ := filepath.Rel(dir, f.path)
+				got[rel] = string(data)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("rewrote %d file(s), want %d: %q", len(got), len(tt.want), got)
+			}
+			for rel, want := range tt.want {
+				if got[rel] != want {
+					t.Errorf("%s =\n%s\nwant\n%s", rel, got[rel], want)
+				}
+			}
+
+			// what was written reads back as the edited command line
+			for rel, data := range got {
+				if err := os.WriteFile(filepath.Join(dir, rel), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			back, err := ReadCmdline(grubFile, dropins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l := Join(back.Value(VarLinux)); l != tt.linux {
+				t.Errorf("%s read back = %q, want %q", VarLinux, l, tt.linux)
+			}
+			if d := Join(back.Value(VarDefault)); d != tt.def {
+				t.Errorf("%s read back = %q, want %q", VarDefault, d, tt.def)
+			}
+			if ch := back.Changes(); len(ch) != 0 {
+				t.Errorf("fresh read reports changes: %v", ch)
+			}
+		})
+	}
+}
+
+func TestRegisterCmdlineAction(t *testing.T) {
+	content := "GRUB_CMDLINE_LINUX_DEFAULT=\"quiet loglevel=0\"\n"
+	tests := []struct {
+		name    string
+		edit    func(t *testing.T, c *Cmdline)
+		changed bool
+	}{
+		{name: "no edit"},
+		{
+			name: "set to current value",
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "quiet"), VarLinux)
+				c.Set(param(t, "loglevel=0"), VarDefault)
+			},
+		},
+		{
+			name: "unset absent",
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Unset(param(t, "nomodeset"))
+				c.Unset(param(t, "loglevel=3"))
+			},
+		},
+		{
+			name: "set new",
+			edit: func(t *testing.T, c *Cmdline) {
+				c.Set(param(t, "nomodeset"), VarDefault)
+			},
+			changed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grubFile, dropins := writeDefaults(t, map[string]*string{"grub": str(content)})
+			c, err := ReadCmdline(grubFile, dropins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != nil {
+				tt.edit(t, c)
+			}
+			txn := transaction.NewNamed("test")
+			if got := RegisterCmdlineAction(txn, c); got != tt.changed {
+				t.Errorf("RegisterCmdlineAction = %v, want %v", got, tt.changed)
+			}
+			var plan bytes.Buffer
+			txn.Plan(&plan)
+			want := "(0 actions)"
+			if tt.changed {
+				want = "(1 actions)"
+			}
+			if !strings.Contains(plan.String(), want) {
+				t.Errorf("plan:\n%s\nwant %s", plan.String(), want)
+			}
+			if data, _ := os.ReadFile(grubFile); string(data) != content {
+				t.Errorf("registering rewrote %s", grubFile)
+			}
+		})
+	}
+}
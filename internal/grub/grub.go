@@ -1,94 +1,240 @@
 /*
 Changes summary:
-- Converted user-visible outputs to concise English.
-- Use transaction.Action objects (matches internal/transaction) instead of old txn.Add signature.
-- Normalize paths with filepath.Clean, preserve original content for Undo.
-- Keep behavior: modify /etc/default/grub and add initramfs script, run update-grub/update-initramfs.
-- Why: clearer, consistent transaction API, safer rollback, less noisy output, easier maintenance.
-- Difference: previous file used a different txn.Add call shape and printed Chinese messages; now it's English, transactional, and returns proper errors.
+  - Kernel command line edits are merged: MIMO's parameters (and hugepage settings) are set with
+    Cmdline.Set/Unset on the effective GRUB_CMDLINE_LINUX[_DEFAULT] of /etc/default/grub and its
+    grub.d drop-ins (see cmdline.go), so parameters the site added are kept.
+  - Use transaction.Action objects; each action journals the original content of the files it
+    rewrites so it can be undone, also from another process.
+  - update-grub and update-initramfs only run when the command line or the initramfs script change.
+  - User-visible outputs are concise English and errors are returned, not printed.
 */
 package grub
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
 
 	"mimo/internal/transaction"
 )
 
-func RegisterGrubAndInitActions(txn *transaction.Transaction) error {
-	if txn == nil {
-		return fmt.Errorf("nil transaction")
+// mimoParams are the kernel parameters MIMO sets for normal boots
+var mimoParams = []string{"quiet", "loglevel=0", "systemd.show_status=0"}
+
+const (
+	// GrubFile is the grub defaults file whose cmdline MIMO sets
+	GrubFile = "/etc/default/grub"
+	// InitScript is the initramfs script MIMO adds
+	InitScript = "/etc/initramfs-tools/scripts/init-top/mimo-msg"
+)
+
+const initContent = `#!/bin/sh
+echo ">>> Initializing MIMO Live Server (initramfs) <<<" > /dev/console
+`
+
+// Paths returns the files RegisterGrubAndInitActions modifies
+func Paths() []string {
+	files := []string{GrubFile}
+	if c, err := CurrentCmdline(); err == nil {
+		files = c.Files()
 	}
+	return append(files, InitScript)
+}
 
+// IsMimoInitScript reports whether data is the initramfs script MIMO installs
+func IsMimoInitScript(data []byte) bool {
+	return string(data) == initContent
+}
 
-	grubFile := filepath.Clean("/etc/default/grub")
-	var origGrub []byte
-	if b, err := os.ReadFile(grubFile); err == nil {
-		origGrub = b
+// Rebuild regenerates the grub config and the initramfs
+func Rebuild() error {
+	if out, err := exec.Command("update-grub").CombinedOutput(); err != nil {
+		return fmt.Errorf("update-grub failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.Command("update-initramfs", "-u").CombinedOutput(); err != nil {
+		return fmt.Errorf("update-initramfs failed: %v: %s", err, strings.TrimSpace(string(out)))
 	}
+	return nil
+}
 
-	modifyGrub := &transaction.Action{
-		Name: "modify grub cmdline",
-		Do: func() error {
-			data := string(origGrub)
-			re := regexp.MustCompile(`(?m)^GRUB_CMDLINE_LINUX_DEFAULT=.*$`)
-			newLine := `GRUB_CMDLINE_LINUX_DEFAULT="quiet loglevel=0 systemd.show_status=0"`
-
-			if re.MatchString(data) {
-				data = re.ReplaceAllString(data, newLine)
-			} else {
-				if !strings.HasSuffix(data, "\n") {
-					data += "\n"
-				}
-				data += newLine + "\n"
-			}
+const (
+	undoInitKind    = "grub.initramfs-restore"
+	undoCmdlineKind = "grub.cmdline-restore"
+)
 
-			if err := os.WriteFile(grubFile, []byte(data), 0644); err != nil {
-				return fmt.Errorf("write grub file: %w", err)
-			}
+// fileState 保存被修改文件的原始内容，写入事务日志用于撤销
+type fileState struct {
+	Path string `json:"path"`
+	Orig []byte `json:"orig,omitempty"`
+	// Absent is set when the file did not exist
+	Absent bool `json:"absent,omitempty"`
+}
 
-			// update-grub: surface concise error on failure
-			if out, err := exec.Command("update-grub").CombinedOutput(); err != nil {
-				return fmt.Errorf("update-grub failed: %v: %s", err, strings.TrimSpace(string(out)))
+// cmdlineState is journaled by the cmdline action: every file it rewrites
+type cmdlineState struct {
+	Files []fileState `json:"files"`
+}
+
+func init() {
+	transaction.RegisterUndo(undoInitKind, decodeUndo(restoreInit))
+	transaction.RegisterUndo(undoCmdlineKind, func(raw json.RawMessage) error {
+		var st cmdlineState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return st.restore()
+	})
+}
+
+func decodeUndo(fn func(fileState) error) func(json.RawMessage) error {
+	return func(raw json.RawMessage) error {
+		var st fileState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return fn(st)
+	}
+}
+
+// restore puts back every rewritten file and regenerates the grub config
+func (st *cmdlineState) restore() error {
+	var errs []string
+	for _, f := range st.Files {
+		var err error
+		if f.Absent {
+			err = os.Remove(f.Path)
+			if os.IsNotExist(err) {
+				err = nil
 			}
+		} else {
+			err = os.WriteFile(f.Path, f.Orig, 0644)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("restore grub defaults: %s", strings.Join(errs, "; "))
+	}
+	// best-effort update-grub, ignore error
+	_ = exec.Command("update-grub").Run()
+	return nil
+}
 
-			fmt.Println("INFO: grub updated")
-			return nil
+// RegisterCmdlineAction registers an action that writes c's edits and runs
+// update-grub. It adds nothing and returns false when c is unchanged.
+func RegisterCmdlineAction(txn *transaction.Transaction, c *Cmdline) bool {
+	changes := c.Changes()
+	if len(changes) == 0 {
+		return false
+	}
+	rendered := c.render()
+	st := &cmdlineState{}
+	for f := range rendered {
+		st.Files = append(st.Files, fileState{Path: f.path, Orig: f.orig, Absent: !f.exists})
+	}
+	sort.Slice(st.Files, func(i, j int) bool { return st.Files[i].Path < st.Files[j].Path })
+
+	txn.Add(&transaction.Action{
+		Name: "update kernel command line",
+		Kind: undoCmdlineKind,
+		State: func() (any, error) {
+			return st, nil
 		},
-		Undo: func() error {
-			// restore original grub file if existed
-			if len(origGrub) > 0 {
-				if err := os.WriteFile(grubFile, origGrub, 0644); err != nil {
-					return fmt.Errorf("restore grub file: %w", err)
+		Describe: func() []string {
+			var out []string
+			for _, ch := range changes {
+				old := ch.Old
+				if old == "" {
+					old = "(empty)"
+				}
+				out = append(out, fmt.Sprintf("%s in %s", ch.Var, ch.File), "- "+old, "+ "+ch.New)
+			}
+			return append(out, "run update-grub")
+		},
+		Do: func() error {
+			for f, data := range rendered {
+				if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+					return err
+				}
+				if err := os.WriteFile(f.path, data, 0644); err != nil {
+					return fmt.Errorf("write %s: %w", f.path, err)
 				}
-				// best-effort update-grub, ignore error
-				_ = exec.Command("update-grub").Run()
 			}
+			// update-grub: surface concise error on failure
+			if out, err := exec.Command("update-grub").CombinedOutput(); err != nil {
+				return fmt.Errorf("update-grub failed: %v: %s", err, strings.TrimSpace(string(out)))
+			}
+			fmt.Println("INFO: grub updated, the new kernel command line takes effect after a reboot")
 			return nil
 		},
+		Undo: st.restore,
+	})
+	return true
+}
+
+// restoreInit restores the original initramfs script or removes ours
+func restoreInit(st fileState) error {
+	if len(st.Orig) > 0 {
+		if err := os.WriteFile(st.Path, st.Orig, 0755); err != nil {
+			return fmt.Errorf("restore init script: %w", err)
+		}
+	} else {
+		_ = os.Remove(st.Path)
+	}
+	_ = exec.Command("update-initramfs", "-u").Run()
+	return nil
+}
+
+// RegisterGrubAndInitActions merges MIMO's kernel parameters and any
+// further edits into the grub defaults and installs the initramfs script.
+// Each action is only added when it changes something, so update-grub and
+// update-initramfs do not run on every update.
+func RegisterGrubAndInitActions(txn *transaction.Transaction, edits ...func(*Cmdline)) error {
+	if txn == nil {
+		return fmt.Errorf("nil transaction")
+	}
+
+	// merge MIMO's parameters into the site's command line
+	c, err := CurrentCmdline()
+	if err != nil {
+		return fmt.Errorf("read kernel command line: %w", err)
+	}
+	for _, s := range mimoParams {
+		p, _ := ParseParam(s)
+		c.Set(p, VarDefault)
+	}
+	for _, edit := range edits {
+		edit(c)
+	}
+	if !RegisterCmdlineAction(txn, c) {
+		fmt.Println("INFO: kernel command line unchanged")
 	}
-	txn.Add(modifyGrub)
 
 	// -------------------------------
 	// 2. initramfs script to show brief message (init-top)
 	// -------------------------------
-	initPath := filepath.Clean("/etc/initramfs-tools/scripts/init-top/mimo-msg")
+	initPath := filepath.Clean(InitScript)
 	var origInit []byte
 	if b, err := os.ReadFile(initPath); err == nil {
 		origInit = b
 	}
+	if string(origInit) == initContent {
+		fmt.Println("INFO: initramfs script unchanged")
+		return nil
+	}
 
-	initContent := `#!/bin/sh
-echo ">>> Initializing MIMO Live Server (initramfs) <<<" > /dev/console
-`
-
+	initState := fileState{Path: initPath, Orig: origInit}
 	addInit := &transaction.Action{
 		Name: "add initramfs mimo-msg",
+		Kind: undoInitKind,
+		State: func() (any, error) {
+			return initState, nil
+		},
 		Do: func() error {
 			if err := os.MkdirAll(filepath.Dir(initPath), 0755); err != nil {
 				return fmt.Errorf("mkdir init path: %w", err)
@@ -103,16 +249,14 @@ echo ">>> Initializing MIMO Live Server (initramfs) <<<" > /dev/console
 			return nil
 		},
 		Undo: func() error {
-			// restore original or remove
+			return restoreInit(initState)
+		},
+		Describe: func() []string {
+			state := "create"
 			if len(origInit) > 0 {
-				if err := os.WriteFile(initPath, origInit, 0755); err != nil {
-					return fmt.Errorf("restore init script: %w", err)
-				}
-			} else {
-				_ = os.Remove(initPath)
+				state = "overwrite"
 			}
-			_ = exec.Command("update-initramfs", "-u").Run()
-			return nil
+			return []string{fmt.Sprintf("%s %s", state, initPath), "run update-initramfs -u"}
 		},
 	}
 	txn.Add(addInit)
@@ -0,0 +1,143 @@
+// Package history keeps an append-only record of every update run on the
+// node, one JSON object per line, so support can tell months later what was
+// installed, by whom and with what result.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mimo/internal/transaction"
+)
+
+// DefaultPath 节点上历史记录的位置
+const DefaultPath = "/var/lib/mimo/history.jsonl"
+
+// 更新结果
+const (
+	OutcomeSuccess    = "success"
+	OutcomeFailed     = "failed"
+	OutcomeRolledBack = "rolled-back"
+	OutcomeCancelled  = "cancelled"
+	OutcomeNoOp       = "no-op"
+)
+
+// Entry 一次更新的记录
+type Entry struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	User     string    `json:"user"`
+	Host     string    `json:"host,omitempty"`
+	Command  []string  `json:"command,omitempty"`
+
+	OldVersion   string `json:"old_version,omitempty"`
+	NewVersion   string `json:"new_version,omitempty"`
+	Bundle       string `json:"bundle,omitempty"`
+	BundleSHA256 string `json:"bundle_sha256,omitempty"`
+
+	Transaction   string             `json:"transaction,omitempty"`
+	Actions       []transaction.Step `json:"actions,omitempty"`
+	Outcome       string             `json:"outcome"`
+	Error         string             `json:"error,omitempty"`
+	RollbackError string             `json:"rollback_error,omitempty"`
+}
+
+// NewID 返回按时间排序的记录标识
+func NewID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(b))
+}
+
+// CurrentUser 返回执行更新的用户：经 sudo 执行时为原用户
+func CurrentUser() string {
+	name := os.Getenv("USER")
+	if sudo := os.Getenv("SUDO_USER"); sudo != "" {
+		if name == "" {
+			name = "root"
+		}
+		return fmt.Sprintf("%s (via sudo as %s)", sudo, name)
+	}
+	if name == "" {
+		name = fmt.Sprintf("uid %d", os.Getuid())
+	}
+	return name
+}
+
+// Append 将记录追加到 path 并同步到磁盘
+func Append(path string, e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Load 读取全部记录（按写入顺序）。无法解析的行（如断电截断）被跳过并计数。
+func Load(path string) (entries []Entry, skipped int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, skipped, sc.Err()
+}
+
+// Find 按标识（允许唯一前缀）查找记录
+func Find(entries []Entry, id string) (*Entry, error) {
+	var found *Entry
+	for i := range entries {
+		if entries[i].ID == id {
+			return &entries[i], nil
+		}
+		if strings.HasPrefix(entries[i].ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("id %q is ambiguous", id)
+			}
+			found = &entries[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no update %q in history", id)
+	}
+	return found, nil
+}
@@ -0,0 +1,142 @@
+// Package hooks runs the pre- and post-install scripts declared in
+// config.json. Each hook is a transaction action: a failing hook rolls the
+// update back, and a hook's undo script runs when a later step fails.
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"mimo/internal/fileops"
+	"mimo/internal/transaction"
+)
+
+const undoKind = "hooks.undo"
+
+// 阶段，传给脚本的 MIMO_HOOK_PHASE
+const (
+	Pre  = "pre"
+	Post = "post"
+)
+
+// EnvPhase 传给脚本的环境变量：当前阶段
+const EnvPhase = "MIMO_HOOK_PHASE"
+
+// undoState 撤销数据。撤销脚本的内容写入事务日志，暂存目录删除后仍可进程外回滚。
+type undoState struct {
+	Name    string        `json:"name"`
+	Script  []byte        `json:"script"`
+	Env     []string      `json:"env"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+func init() {
+	transaction.RegisterUndo(undoKind, func(raw json.RawMessage) error {
+		var st undoState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return st.run()
+	})
+}
+
+// Register 为 phase 阶段的每个钩子注册一个事务动作。env 为附加的环境变量（KEY=VALUE），
+// 钩子自身的 env 优先。撤销脚本在注册时读入，之后不再依赖暂存目录。
+func Register(txn *transaction.Transaction, phase string, hooks []fileops.Hook, env []string) error {
+	for _, h := range hooks {
+		hookEnv := append(append([]string{EnvPhase + "=" + phase}, env...), envList(h.Env)...)
+		name := fmt.Sprintf("%s hook: %s", phase, h.Title())
+
+		var st *undoState
+		if h.Undo != "" {
+			script, err := os.ReadFile(h.Undo)
+			if err != nil {
+				return fmt.Errorf("%s: read undo script: %w", name, err)
+			}
+			st = &undoState{Name: name, Script: script, Env: hookEnv, Timeout: h.TimeoutDuration()}
+		}
+
+		a := &transaction.Action{
+			Name: name,
+			Describe: func() []string {
+				out := []string{fmt.Sprintf("run %s (timeout %s)", h.Script, h.TimeoutDuration())}
+				if h.Undo != "" {
+					out = append(out, "undo: run "+h.Undo)
+				}
+				return out
+			},
+			Do: func() error {
+				fmt.Printf("INFO: running %s\n", name)
+				return run(h.Script, hookEnv, h.TimeoutDuration())
+			},
+		}
+		if st != nil {
+			a.Kind = undoKind
+			a.State = func() (any, error) { return st, nil }
+			a.Undo = st.run
+		}
+		txn.Add(a)
+	}
+	return nil
+}
+
+// run 撤销脚本写入临时文件后执行
+func (st *undoState) run() error {
+	f, err := os.CreateTemp("", "mimo-hook-undo-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(st.Script); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("INFO: undoing %s\n", st.Name)
+	return run(f.Name(), st.Env, st.Timeout)
+}
+
+// run 用 bash 执行 script，超时后结束其整个进程组
+func run(script string, env []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "bash", script)
+	cmd.Dir = filepath.Dir(script)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s", filepath.Base(script), timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(script), err)
+	}
+	return nil
+}
+
+func envList(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k, v := range m {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,328 @@
+// Package hugepages reserves the hugepages SPDK needs. A reservation is
+// made persistent on the kernel command line and applied at once through
+// sysfs, and the current allocation is reported per NUMA node.
+package hugepages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mimo/internal/grub"
+	"mimo/internal/transaction"
+)
+
+// 支持的页大小
+const (
+	Size2M = "2M"
+	Size1G = "1G"
+)
+
+const (
+	sysfsDir = "/sys/kernel/mm/hugepages"
+	nodeDir  = "/sys/devices/system/node"
+	undoKind = "hugepages.restore"
+)
+
+// Setting 大页配置
+type Setting struct {
+	// Size 页大小，Size2M 或 Size1G
+	Size string `json:"size"`
+	// Count 页数；PerNUMA 为 true 时为每个 NUMA 节点的页数
+	Count   int  `json:"count"`
+	PerNUMA bool `json:"per_numa,omitempty"`
+}
+
+func (s Setting) String() string {
+	if s.PerNUMA {
+		return fmt.Sprintf("%d x %s per NUMA node", s.Count, s.Size)
+	}
+	return fmt.Sprintf("%d x %s", s.Count, s.Size)
+}
+
+// ParseSize 规范化页大小（2M、2MB、1g 等），返回 Size2M 或 Size1G
+func ParseSize(s string) (string, error) {
+	switch strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B") {
+	case "2M":
+		return Size2M, nil
+	case "1G":
+		return Size1G, nil
+	}
+	return "", fmt.Errorf("unsupported hugepage size %q (want %s or %s)", s, Size2M, Size1G)
+}
+
+// Validate 检查并规范化 s
+func (s *Setting) Validate() error {
+	size, err := ParseSize(s.Size)
+	if err != nil {
+		return err
+	}
+	if s.Count < 0 {
+		return fmt.Errorf("hugepage count must not be negative")
+	}
+	s.Size = size
+	return nil
+}
+
+func sizeKB(size string) int {
+	if size == Size1G {
+		return 1024 * 1024
+	}
+	return 2048
+}
+
+// sizeName 返回 kB 数对应的页大小名称
+func sizeName(kb int) string {
+	switch {
+	case kb%(1024*1024) == 0:
+		return fmt.Sprintf("%dG", kb/(1024*1024))
+	case kb%1024 == 0:
+		return fmt.Sprintf("%dM", kb/1024)
+	}
+	return fmt.Sprintf("%dkB", kb)
+}
+
+func poolDir(size string) string {
+	return fmt.Sprintf("hugepages-%dkB", sizeKB(size))
+}
+
+// Nodes 返回 NUMA 节点编号；没有 NUMA 信息时返回 nil
+func Nodes() []int {
+	dirs, _ := filepath.Glob(filepath.Join(nodeDir, "node[0-9]*"))
+	var out []int
+	for _, d := range dirs {
+		if n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(d), "node")); err == nil {
+			out = append(out, n)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// Pool 一种页大小在一个 NUMA 节点上的分配情况
+type Pool struct {
+	Size string `json:"size"`
+	// Node 为 -1 表示整机（没有 NUMA 信息）
+	Node  int `json:"node"`
+	Total int `json:"total"`
+	Free  int `json:"free"`
+	// Surplus 超出预留、按需分配的页
+	Surplus int `json:"surplus"`
+}
+
+// Used 已使用的页数
+func (p Pool) Used() int {
+	return p.Total - p.Free
+}
+
+// Status 返回每种页大小在每个 NUMA 节点上的分配与使用情况
+func Status() ([]Pool, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsDir, "hugepages-*kB"))
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("%s not found, the kernel has no hugepage support", sysfsDir)
+	}
+	nodes := Nodes()
+	var out []Pool
+	for _, d := range dirs {
+		kb, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(d), "hugepages-"), "kB"))
+		if err != nil {
+			continue
+		}
+		name := sizeName(kb)
+		if len(nodes) == 0 {
+			out = append(out, readPool(d, name, -1))
+			continue
+		}
+		for _, n := range nodes {
+			out = append(out, readPool(filepath.Join(nodeDir, fmt.Sprintf("node%d", n), "hugepages", filepath.Base(d)), name, n))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Size != out[j].Size {
+			return sizeKBOf(out[i].Size) < sizeKBOf(out[j].Size)
+		}
+		return out[i].Node < out[j].Node
+	})
+	return out, nil
+}
+
+func sizeKBOf(name string) int {
+	if s, err := ParseSize(name); err == nil {
+		return sizeKB(s)
+	}
+	return 0
+}
+
+func readPool(dir, size string, node int) Pool {
+	return Pool{
+		Size:    size,
+		Node:    node,
+		Total:   readInt(filepath.Join(dir, "nr_hugepages")),
+		Free:    readInt(filepath.Join(dir, "free_hugepages")),
+		Surplus: readInt(filepath.Join(dir, "surplus_hugepages")),
+	}
+}
+
+func readInt(p string) int {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return n
+}
+
+// Boot 返回内核命令行中的大页配置，没有配置时返回 nil。
+// 命令行只记录总页数，PerNUMA 总为 false。
+func Boot(c *grub.Cmdline) *Setting {
+	var s Setting
+	found := false
+	for _, p := range c.Params() {
+		switch p.Key {
+		case "hugepagesz", "default_hugepagesz":
+			if size, err := ParseSize(p.Value); err == nil {
+				s.Size = size
+			}
+		case "hugepages":
+			if n, err := strconv.Atoi(p.Value); err == nil {
+				s.Count, found = n, true
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	if s.Size == "" {
+		s.Size = Size2M
+	}
+	return &s
+}
+
+// SetCmdline 用 s 替换 c 中的大页参数，使配置在重启后保持。按节点配置时写入节点数乘以每节点页数，
+// 内核启动时在各节点间平均分配。
+func SetCmdline(c *grub.Cmdline, s Setting) {
+	for _, k := range []string{"default_hugepagesz", "hugepagesz", "hugepages"} {
+		c.Unset(grub.Param{Key: k})
+	}
+	if s.Count == 0 {
+		return
+	}
+	total := s.Count
+	if n := len(Nodes()); s.PerNUMA && n > 1 {
+		total *= n
+	}
+	for _, p := range []grub.Param{
+		{Key: "default_hugepagesz", Value: s.Size, HasValue: true},
+		{Key: "hugepagesz", Value: s.Size, HasValue: true},
+		{Key: "hugepages", Value: strconv.Itoa(total), HasValue: true},
+	} {
+		c.Set(p, grub.VarLinux)
+	}
+}
+
+// applyState 撤销数据：调整前每个 nr_hugepages 文件的值
+type applyState struct {
+	Counts map[string]int `json:"counts"`
+}
+
+func init() {
+	transaction.RegisterUndo(undoKind, func(raw json.RawMessage) error {
+		var st applyState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return st.restore()
+	})
+}
+
+func (st *applyState) restore() error {
+	var errs []string
+	for p, n := range st.Counts {
+		if err := writeInt(p, n); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("restore hugepages: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func writeInt(p string, n int) error {
+	return os.WriteFile(p, []byte(strconv.Itoa(n)), 0644)
+}
+
+// targets 返回要写入的 nr_hugepages 文件及其目标值
+func targets(s Setting) map[string]int {
+	nodes := Nodes()
+	if !s.PerNUMA || len(nodes) == 0 {
+		return map[string]int{filepath.Join(sysfsDir, poolDir(s.Size), "nr_hugepages"): s.Count}
+	}
+	out := map[string]int{}
+	for _, n := range nodes {
+		out[filepath.Join(nodeDir, fmt.Sprintf("node%d", n), "hugepages", poolDir(s.Size), "nr_hugepages")] = s.Count
+	}
+	return out
+}
+
+// RegisterApplyAction 注册立即按 s 调整大页数量的动作，撤销时恢复原来的数量。
+// 当前数量已满足 s 时不注册，返回 false。内存碎片可能使立即分配不足，此时只告警，完整的预留在重启后生效。
+func RegisterApplyAction(txn *transaction.Transaction, s Setting) (bool, error) {
+	want := targets(s)
+	st := &applyState{Counts: map[string]int{}}
+	changed := false
+	for p, n := range want {
+		if _, err := os.Stat(p); err != nil {
+			return false, fmt.Errorf("%s hugepages are not supported by this kernel: %w", s.Size, err)
+		}
+		cur := readInt(p)
+		st.Counts[p] = cur
+		changed = changed || cur != n
+	}
+	if !changed {
+		return false, nil
+	}
+	paths := make([]string, 0, len(want))
+	for p := range want {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	txn.Add(&transaction.Action{
+		Name: "reserve hugepages",
+		Kind: undoKind,
+		State: func() (any, error) {
+			return st, nil
+		},
+		Describe: func() []string {
+			out := make([]string, 0, len(paths))
+			for _, p := range paths {
+				out = append(out, fmt.Sprintf("%s: %d -> %d", p, st.Counts[p], want[p]))
+			}
+			return out
+		},
+		Do: func() error {
+			for _, p := range paths {
+				if err := writeInt(p, want[p]); err != nil {
+					return fmt.Errorf("write %s: %w", p, err)
+				}
+				if got := readInt(p); got < want[p] {
+					fmt.Printf("WARN: only %d of %d %s pages could be reserved now (%s); the rest are reserved at boot\n", got, want[p], s.Size, p)
+				} else if got > want[p] {
+					fmt.Printf("WARN: %d %s pages are still in use (%s); they are released when no longer used\n", got, s.Size, p)
+				}
+			}
+			fmt.Printf("INFO: reserved %s\n", s)
+			return nil
+		},
+		Undo: st.restore,
+	})
+	return true, nil
+}
@@ -0,0 +1,101 @@
+// Package lock provides the system-wide update lock: an flock on a file in
+// /run/lock that also records who holds it, so a second update can report
+// what it is waiting for instead of racing the first one.
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultPath 更新锁文件
+const DefaultPath = "/run/lock/mimo-update.lock"
+
+// Holder 持有锁的进程
+type Holder struct {
+	PID     int       `json:"pid"`
+	User    string    `json:"user"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+func (h *Holder) String() string {
+	return fmt.Sprintf("pid %d (%s) started by %s at %s", h.PID, h.Command, h.User, h.Since.Local().Format("2006-01-02 15:04:05"))
+}
+
+// BusyError 锁已被其它进程持有
+type BusyError struct {
+	Path string
+	// Holder 锁文件中记录的持有者，无法读取时为 nil
+	Holder *Holder
+}
+
+func (e *BusyError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("another update is in progress (%s is locked)", e.Path)
+	}
+	return fmt.Sprintf("another update is in progress: %s", e.Holder)
+}
+
+// Lock 已获得的锁
+type Lock struct {
+	f *os.File
+}
+
+// Acquire 以非阻塞方式获取 path 上的排他锁，并将 h 写入锁文件。
+// 锁随进程退出自动释放，不会因崩溃而残留。
+func Acquire(path string, h Holder) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &BusyError{Path: path, Holder: Read(path)}
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+
+	data, _ := json.Marshal(h)
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt(append(data, '\n'), 0)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Read 返回锁文件中记录的持有者；文件为空或无法解析时返回 nil
+func Read(path string) *Holder {
+	data, err := os.ReadFile(path)
+	if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+		return nil
+	}
+	var h Holder
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil
+	}
+	return &h
+}
+
+// Release 清空持有者记录并释放锁
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	_ = l.f.Truncate(0)
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
@@ -0,0 +1,163 @@
+// Package manifest describes the files shipped in an update bundle: one
+// entry per regular file with its hash, mode and install path.
+package manifest
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FileName 清单在资源包中的文件名（位于包根目录）
+const FileName = "manifest.json"
+
+// Schema 当前清单格式版本
+const Schema = 1
+
+// InstalledPath 节点上记录已安装版本清单的位置
+const InstalledPath = "/var/lib/mimo/manifest.json"
+
+// File 资源包中的单个文件
+type File struct {
+	// Path 包内路径，如 file/SPDK_for_MIMO/build/bin/spdk_tgt
+	Path string `json:"path"`
+	// Target 安装路径；不属于任何映射的文件为空
+	Target string      `json:"target,omitempty"`
+	Size   int64       `json:"size"`
+	Mode   os.FileMode `json:"mode"`
+	SHA256 string      `json:"sha256"`
+	// Owner 与 Group 为映射指定的属主（用户名或数字 id），为空表示不指定
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+	// Config 配置文件：用户修改过的已安装副本不被覆盖
+	Config bool `json:"config,omitempty"`
+	// Template 安装前在节点上渲染的模板；SHA256 与 Size 在渲染后更新为实际安装的内容
+	Template bool `json:"template,omitempty"`
+	// Unchanged 表示增量包中该文件与基础版本相同，未包含在归档中
+	Unchanged bool `json:"unchanged,omitempty"`
+}
+
+// Manifest 资源包清单
+type Manifest struct {
+	Schema  int    `json:"schema"`
+	Version string `json:"version"`
+	// BaseVersion 非空表示增量包，只能安装在该版本之上
+	BaseVersion  string `json:"base_version,omitempty"`
+	Created      string `json:"created,omitempty"`
+	UnpackedSize int64  `json:"unpacked_size"`
+	Files        []File `json:"files"`
+}
+
+// Parse 解析清单内容
+func Parse(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if m.Schema == 0 || m.Schema > Schema {
+		return nil, fmt.Errorf("unsupported manifest schema %d", m.Schema)
+	}
+	return &m, nil
+}
+
+// Load 从文件读取清单
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Marshal 以稳定的格式序列化清单（文件按包内路径排序）
+func (m *Manifest) Marshal() ([]byte, error) {
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Save 写入清单文件
+func (m *Manifest) Save(path string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ByTarget 返回安装路径到文件的索引
+func (m *Manifest) ByTarget() map[string]*File {
+	idx := make(map[string]*File, len(m.Files))
+	for i := range m.Files {
+		if m.Files[i].Target != "" {
+			idx[m.Files[i].Target] = &m.Files[i]
+		}
+	}
+	return idx
+}
+
+// IsDelta 是否为增量包清单
+func (m *Manifest) IsDelta() bool {
+	return m.BaseVersion != ""
+}
+
+// Installed 返回安装完成后应记录的完整清单（去掉增量标记）
+func (m *Manifest) Installed() *Manifest {
+	out := *m
+	out.BaseVersion = ""
+	out.Files = make([]File, len(m.Files))
+	for i, f := range m.Files {
+		f.Unchanged = false
+		out.Files[i] = f
+	}
+	return &out
+}
+
+// Filter 返回只包含 keep 为 true 的文件的清单
+func (m *Manifest) Filter(keep func(File) bool) *Manifest {
+	out := *m
+	out.Files = nil
+	for _, f := range m.Files {
+		if keep(f) {
+			out.Files = append(out.Files, f)
+		}
+	}
+	return &out
+}
+
+// ReadArchive 从 tar.gz 资源归档中读取清单；归档中没有清单时返回 os.ErrNotExist
+func ReadArchive(r io.Reader) (*Manifest, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: %w", FileName, os.ErrNotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Clean(hdr.Name) == FileName {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			return Parse(data)
+		}
+	}
+}
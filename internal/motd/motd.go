@@ -7,6 +7,7 @@ Changes:
 package motd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -20,6 +21,9 @@ const (
 	motdBakRoot = "/var/lib/mimo/motd-backup"
 )
 
+// BackupDir is where the original motd scripts are kept.
+const BackupDir = motdBakRoot
+
 // RegisterMOTDActions registers an action that backs up motd scripts and clears the directory.
 // Undo restores from backup.
 func RegisterMOTDActions(txn *transaction.Transaction) error {
@@ -29,6 +33,7 @@ func RegisterMOTDActions(txn *transaction.Transaction) error {
 
 	action := &transaction.Action{
 		Name: "motd backup and disable",
+		Kind: undoKind,
 		Do: func() error {
 			// ensure backup dir
 			if err := os.MkdirAll(motdBakRoot, 0755); err != nil {
@@ -52,23 +57,20 @@ func RegisterMOTDActions(txn *transaction.Transaction) error {
 			}
 			return nil
 		},
-		Undo: func() error {
-			entries, err := os.ReadDir(motdBakRoot)
-			if err != nil && !os.IsNotExist(err) {
-				return fmt.Errorf("read backup: %w", err)
+		Undo: restoreMotd,
+		Describe: func() []string {
+			entries, err := os.ReadDir(motdDir)
+			if err != nil {
+				return []string{fmt.Sprintf("nothing to move (%v)", err)}
 			}
+			if len(entries) == 0 {
+				return []string{"nothing to move: " + motdDir + " is empty"}
+			}
+			out := make([]string, 0, len(entries))
 			for _, e := range entries {
-				src := filepath.Join(motdBakRoot, e.Name())
-				dst := filepath.Join(motdDir, e.Name())
-				if err := os.MkdirAll(motdDir, 0755); err != nil {
-					return err
-				}
-				if err := copyFile(src, dst); err != nil {
-					return err
-				}
+				out = append(out, fmt.Sprintf("move %s -> %s", filepath.Join(motdDir, e.Name()), filepath.Join(motdBakRoot, e.Name())))
 			}
-			// best-effort: leave backup in place
-			return nil
+			return out
 		},
 	}
 
@@ -76,6 +78,40 @@ func RegisterMOTDActions(txn *transaction.Transaction) error {
 	return nil
 }
 
+const undoKind = "motd.restore"
+
+func init() {
+	// the backup lives under /var/lib/mimo, so no extra state is journaled
+	transaction.RegisterUndo(undoKind, func(json.RawMessage) error {
+		return restoreMotd()
+	})
+}
+
+// restoreMotd copies backed up scripts back into /etc/update-motd.d
+func restoreMotd() error {
+	entries, err := os.ReadDir(motdBakRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read backup: %w", err)
+	}
+	for _, e := range entries {
+		src := filepath.Join(motdBakRoot, e.Name())
+		dst := filepath.Join(motdDir, e.Name())
+		if err := os.MkdirAll(motdDir, 0755); err != nil {
+			return err
+		}
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+	// best-effort: leave backup in place
+	return nil
+}
+
+// Restore puts the motd scripts saved by the first update back in place.
+func Restore() error {
+	return restoreMotd()
+}
+
 // DisableMotd removes all files in /etc/update-motd.d
 func DisableMotd() error {
 	entries, err := os.ReadDir(motdDir)
@@ -0,0 +1,212 @@
+// Package originals keeps the pristine copy of every system file the
+// installer replaces, taken the first time MIMO overwrites it. Later updates
+// never refresh it, so it always shows what the host looked like before MIMO.
+package originals
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mimo/internal/fileops"
+	"mimo/internal/transaction"
+)
+
+// DefaultDir 原始文件的保存位置，其下按绝对路径存放
+const DefaultDir = "/var/lib/mimo/originals"
+
+// absentSuffix 标记文件：MIMO 安装前该路径不存在
+const absentSuffix = ".mimo-absent"
+
+const undoKeepKind = "originals.keep"
+
+// Store 原始文件库
+type Store struct {
+	Dir string
+}
+
+// Default 返回默认位置的原始文件库
+func Default() Store {
+	return Store{Dir: DefaultDir}
+}
+
+// Path 返回 p 的原始副本位置
+func (s Store) Path(p string) string {
+	return filepath.Join(s.Dir, filepath.Clean("/"+p))
+}
+
+// Has 报告 p 是否已保存过原始状态（包括“原本不存在”）
+func (s Store) Has(p string) bool {
+	for _, q := range []string{s.Path(p), s.Path(p) + absentSuffix} {
+		if _, err := os.Lstat(q); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Existed 报告 p 在 MIMO 安装前是否存在；未保存过时 ok 为 false
+func (s Store) Existed(p string) (existed, ok bool) {
+	if _, err := os.Lstat(s.Path(p)); err == nil {
+		return true, true
+	}
+	if _, err := os.Lstat(s.Path(p) + absentSuffix); err == nil {
+		return false, true
+	}
+	return false, false
+}
+
+// Keep 保存 p 当前的状态，已保存过时不做任何事。返回本次是否新保存。
+func (s Store) Keep(p string) (bool, error) {
+	if s.Has(p) {
+		return false, nil
+	}
+	dst := s.Path(p)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return false, err
+	}
+	if _, err := os.Lstat(p); os.IsNotExist(err) {
+		return true, os.WriteFile(dst+absentSuffix, nil, 0644)
+	}
+	if err := fileops.CopyTree(p, dst); err != nil {
+		_ = os.RemoveAll(dst)
+		return false, fmt.Errorf("keep original %s: %w", p, err)
+	}
+	return true, nil
+}
+
+// Restore 将 p 恢复为保存的原始状态：原本存在则复制回原位，原本不存在则删除 p。
+// 未保存过时返回 os.ErrNotExist。
+func (s Store) Restore(p string) error {
+	existed, ok := s.Existed(p)
+	if !ok {
+		return fmt.Errorf("no original kept for %s: %w", p, os.ErrNotExist)
+	}
+	if err := os.RemoveAll(p); err != nil {
+		return err
+	}
+	if !existed {
+		return nil
+	}
+	if err := fileops.CopyTree(s.Path(p), p); err != nil {
+		return fmt.Errorf("restore %s: %w", p, err)
+	}
+	return nil
+}
+
+// Forget 删除 p 的原始记录
+func (s Store) Forget(p string) error {
+	if err := os.RemoveAll(s.Path(p)); err != nil {
+		return err
+	}
+	if err := os.Remove(s.Path(p) + absentSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ReadFile 读取 p 的原始内容。原始文件是符号链接时按其在原位置的解析读取目标。
+func (s Store) ReadFile(p string) ([]byte, error) {
+	q := s.Path(p)
+	fi, err := os.Lstat(q)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return os.ReadFile(q)
+	}
+	target, err := os.Readlink(q)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(filepath.Clean(p)), target)
+	}
+	return os.ReadFile(target)
+}
+
+// List 返回已保存原始状态的全部路径
+func (s Store) List() ([]string, error) {
+	var out []string
+	err := filepath.Walk(s.Dir, func(q string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && q == s.Dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		p := "/" + strings.TrimPrefix(strings.TrimSuffix(q, absentSuffix), s.Dir+"/")
+		out = append(out, p)
+		return nil
+	})
+	return out, err
+}
+
+// keepState 撤销数据：本次新保存的路径
+type keepState struct {
+	Dir  string   `json:"dir"`
+	Kept []string `json:"kept"`
+}
+
+func init() {
+	transaction.RegisterUndo(undoKeepKind, func(raw json.RawMessage) error {
+		var st keepState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return st.forget()
+	})
+}
+
+func (st *keepState) forget() error {
+	s := Store{Dir: st.Dir}
+	for _, p := range st.Kept {
+		if err := s.Forget(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterKeepAction 在覆盖 paths 之前保存它们的原始状态；只保存尚未保存过的路径，
+// 撤销时删除本次新保存的副本
+func RegisterKeepAction(txn *transaction.Transaction, s Store, paths []string) {
+	var todo []string
+	for _, p := range paths {
+		if !s.Has(p) {
+			todo = append(todo, p)
+		}
+	}
+	if len(todo) == 0 {
+		return
+	}
+	st := &keepState{Dir: s.Dir, Kept: todo}
+	txn.Add(&transaction.Action{
+		Name: "keep original system files",
+		Kind: undoKeepKind,
+		State: func() (any, error) {
+			return st, nil
+		},
+		Describe: func() []string {
+			out := make([]string, 0, len(todo))
+			for _, p := range todo {
+				out = append(out, fmt.Sprintf("%s -> %s", p, s.Path(p)))
+			}
+			return out
+		},
+		Do: func() error {
+			for _, p := range todo {
+				if _, err := s.Keep(p); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Undo: st.forget,
+	})
+}
@@ -0,0 +1,761 @@
+// Package pack builds update bundles on the build host: it validates
+// config.json against the source tree, writes a reproducible resources
+// archive with a manifest, signs it and produces the standalone bundle.
+package pack
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mimo/internal/debs"
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/manifest"
+	"mimo/internal/render"
+	"mimo/internal/signature"
+)
+
+// StagingRoot 是 config.json 中 src 路径的前缀
+const StagingRoot = env.StagingRoot
+
+// 资源包内的文件名，与 decompress.ArchiveName/HashName/SignatureName 一致
+const (
+	archiveName   = "resources.tar.gz"
+	hashName      = "resources.sha256"
+	signatureName = "resources.sig"
+)
+
+// EnvSourceDateEpoch 指定归档中的文件时间（秒），未设置时使用 0
+const EnvSourceDateEpoch = "SOURCE_DATE_EPOCH"
+
+// Options 打包参数；相对路径均相对于 Root
+type Options struct {
+	Root       string
+	Config     string
+	SrcDir     string
+	OutDir     string
+	EmbedDir   string
+	BinDir     string
+	SigningKey string
+	// Base 非空时构建增量包：基础版本的 manifest.json 或独立资源包路径
+	Base string
+	// Binary 为 true 时编译带版本号的可执行文件
+	Binary bool
+	Epoch  time.Time
+}
+
+// DefaultOptions 返回与原打包脚本一致的目录布局
+func DefaultOptions() Options {
+	return Options{
+		Root:     ".",
+		Config:   "config.json",
+		SrcDir:   "file",
+		OutDir:   "resources",
+		EmbedDir: "internal/decompress",
+		BinDir:   "bin",
+		Binary:   true,
+	}
+}
+
+// EpochFromEnv 读取 SOURCE_DATE_EPOCH
+func EpochFromEnv() (time.Time, error) {
+	s := strings.TrimSpace(os.Getenv(EnvSourceDateEpoch))
+	if s == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q", EnvSourceDateEpoch, s)
+	}
+	return time.Unix(n, 0).UTC(), nil
+}
+
+// Result 打包产物
+type Result struct {
+	Version   string
+	Digest    string
+	Files     int
+	Size      int64
+	Archive   string
+	Hash      string
+	Signature string
+	Signed    bool
+	Bundle    string
+	Binary    string
+	// Base 增量包的基础版本，完整包为空
+	Base string
+}
+
+// entry 待归档的文件或目录
+type entry struct {
+	name string // 包内路径（以 / 分隔）
+	path string // 构建主机上的路径
+	info os.FileInfo
+}
+
+// Build 执行完整的打包流程
+func Build(opts Options) (*Result, error) {
+	abs := func(p string) string {
+		if filepath.IsAbs(p) {
+			return filepath.Clean(p)
+		}
+		return filepath.Join(opts.Root, p)
+	}
+
+	cfgPath := abs(opts.Config)
+	cfg, err := fileops.LoadConfig(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Schema == 0 {
+		fmt.Printf("WARN: %s uses the old format, run \"mimo-pack migrate-config\" to convert it\n", cfgPath)
+	}
+
+	srcDir := filepath.ToSlash(filepath.Clean(opts.SrcDir))
+	targets, err := checkMappings(opts.Root, srcDir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHooks(opts.Root, srcDir, cfg.Hooks); err != nil {
+		return nil, err
+	}
+	if err := checkPackages(opts.Root, srcDir, cfg.Packages); err != nil {
+		return nil, err
+	}
+
+	version, versionFile := readVersion(opts.Root, srcDir, cfg.Version)
+	res := &Result{Version: version}
+	fmt.Printf("INFO: version %s\n", res.Version)
+	if err := checkTemplates(opts.Root, cfg, version, versionFile); err != nil {
+		return nil, err
+	}
+
+	entries, err := collect(opts.Root, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, entry{name: path.Base(filepath.ToSlash(opts.Config)), path: cfgPath})
+	if entries[len(entries)-1].info, err = os.Stat(cfgPath); err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	entries = selected(entries, targets)
+	m, err := buildManifest(entries, targets, res.Version, opts.Epoch)
+	if err != nil {
+		return nil, err
+	}
+	res.Files, res.Size = len(m.Files), m.UnpackedSize
+
+	outDir := abs(opts.OutDir)
+	if opts.Base != "" {
+		base, err := loadBase(abs(opts.Base))
+		if err != nil {
+			return nil, fmt.Errorf("load base manifest: %w", err)
+		}
+		always := hookScripts(cfg.Hooks)
+		always[versionFile] = true
+		entries = delta(m, base, entries, always)
+		res.Base = base.Version
+		fmt.Printf("INFO: delta against %s: %d of %d files changed\n", base.Version, countShipped(entries), res.Files)
+		// 增量包单独输出，不替换内嵌的完整资源包
+		outDir = filepath.Join(outDir, "delta")
+		opts.Binary = false
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	res.Archive = filepath.Join(outDir, archiveName)
+	res.Hash = filepath.Join(outDir, hashName)
+	res.Signature = filepath.Join(outDir, signatureName)
+
+	fmt.Printf("INFO: packing %d files (%d bytes)...\n", res.Files, res.Size)
+	if res.Digest, err = writeArchive(res.Archive, m, entries, opts.Epoch); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(res.Hash, []byte(res.Digest+"\n"), 0644); err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	if opts.SigningKey != "" {
+		if sig, err = SignDigest(opts.SigningKey, res.Digest); err != nil {
+			return nil, err
+		}
+		res.Signed = true
+	} else {
+		fmt.Println("WARN: MIMO_SIGNING_KEY not set, bundle is unsigned (nodes need --insecure-skip-signature)")
+	}
+	if err := os.WriteFile(res.Signature, sig, 0644); err != nil {
+		return nil, err
+	}
+
+	if res.Base == "" {
+		embedDir := abs(opts.EmbedDir)
+		for _, name := range []string{archiveName, hashName, signatureName} {
+			if err := copyPlain(filepath.Join(outDir, name), filepath.Join(embedDir, name)); err != nil {
+				return nil, fmt.Errorf("copy %s to %s: %w", name, embedDir, err)
+			}
+		}
+	}
+
+	binDir := abs(opts.BinDir)
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return nil, err
+	}
+	res.Bundle = filepath.Join(binDir, fmt.Sprintf("mimo-bundle-%s.tar", res.Version))
+	if res.Base != "" {
+		res.Bundle = filepath.Join(binDir, fmt.Sprintf("mimo-bundle-%s-delta-%s.tar", res.Version, res.Base))
+	}
+	if err := writeBundle(res.Bundle, outDir, opts.Epoch); err != nil {
+		return nil, err
+	}
+
+	if opts.Binary {
+		res.Binary = filepath.Join(binDir, "mimo"+res.Version)
+		if err := goBuild(opts.Root, res.Binary, res.Version); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// localSource 将 config.json 中的 src（位于 StagingRoot 下）换算为包内路径
+func localSource(src string) (string, error) {
+	src = filepath.ToSlash(filepath.Clean(src))
+	rel := strings.TrimPrefix(src, StagingRoot+"/")
+	if rel == src {
+		return "", fmt.Errorf("source %s is not under %s", src, StagingRoot)
+	}
+	return rel, nil
+}
+
+// target 包内路径对应的安装位置
+type target struct {
+	dst string
+	fm  fileops.FileMapping
+}
+
+// checkMappings 检查每个映射的源文件均存在于 srcDir 中且与声明的类型一致，
+// 返回包内路径到映射的对应关系；符号链接映射没有源文件，不在其中
+func checkMappings(root, srcDir string, cfg *fileops.Config) (map[string]target, error) {
+	targets := map[string]target{}
+	var problems []string
+	for _, fm := range cfg.FileMappings {
+		if fm.Type == fileops.TypeSymlink {
+			continue
+		}
+		rel, err := localSource(fm.Src)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if rel != srcDir && !strings.HasPrefix(rel, srcDir+"/") {
+			problems = append(problems, fmt.Sprintf("source %s is outside %s/", fm.Src, srcDir))
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("source %s missing: %v", fm.Src, err))
+			continue
+		}
+		switch {
+		case fm.Type == fileops.TypeFile && !fi.Mode().IsRegular():
+			problems = append(problems, fmt.Sprintf("source %s is not a regular file", fm.Src))
+			continue
+		case fm.Type == fileops.TypeDir && !fi.IsDir():
+			problems = append(problems, fmt.Sprintf("source %s is not a directory", fm.Src))
+			continue
+		case fm.Template && !fi.Mode().IsRegular():
+			problems = append(problems, fmt.Sprintf("template %s is not a regular file", fm.Src))
+			continue
+		}
+		targets[rel] = target{dst: filepath.Clean(fm.Dst), fm: fm}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid file mappings:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return targets, nil
+}
+
+// checkHooks 检查钩子脚本均存在于 srcDir 中
+func checkHooks(root, srcDir string, hooks *fileops.Hooks) error {
+	if hooks == nil {
+		return nil
+	}
+	var problems []string
+	for _, h := range append(append([]fileops.Hook{}, hooks.Pre...), hooks.Post...) {
+		for _, script := range []string{h.Script, h.Undo} {
+			if script == "" {
+				continue
+			}
+			rel, err := localSource(script)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("hook %s: %v", h.Title(), err))
+				continue
+			}
+			if rel != srcDir && !strings.HasPrefix(rel, srcDir+"/") {
+				problems = append(problems, fmt.Sprintf("hook %s: script %s is outside %s/", h.Title(), script, srcDir))
+				continue
+			}
+			fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("hook %s: script %s missing: %v", h.Title(), script, err))
+				continue
+			}
+			if !fi.Mode().IsRegular() {
+				problems = append(problems, fmt.Sprintf("hook %s: script %s is not a regular file", h.Title(), script))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid hooks:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// checkPackages 检查每个必需包都能从资源包中的仓库满足（节点为 amd64）
+func checkPackages(root, srcDir string, pkgs *fileops.Packages) error {
+	if pkgs == nil || len(pkgs.Require) == 0 {
+		fmt.Println("WARN: config.json declares no packages; nodes will fall back to 'apt update' and pkgdep.sh, which needs network")
+		return nil
+	}
+	rel, err := localSource(pkgs.Repo)
+	if err != nil {
+		return fmt.Errorf("packages: %w", err)
+	}
+	if rel != srcDir && !strings.HasPrefix(rel, srcDir+"/") {
+		return fmt.Errorf("packages: repository %s is outside %s/", pkgs.Repo, srcDir)
+	}
+	repo, err := debs.Repo(filepath.Join(root, filepath.FromSlash(rel)), "amd64")
+	if err != nil {
+		return fmt.Errorf("packages: %w", err)
+	}
+	// 按“节点上尚未安装”检查，即每个包都必须在仓库中
+	plan := debs.NewPlan(pkgs.Require, nil, repo)
+	for _, s := range plan.Skipped {
+		fmt.Printf("WARN: optional package %s\n", s)
+	}
+	if len(plan.Missing) > 0 {
+		return fmt.Errorf("invalid packages:\n  %s", strings.Join(plan.Missing, "\n  "))
+	}
+	fmt.Printf("INFO: %d package(s) in %s\n", len(plan.Install), rel)
+	return nil
+}
+
+// hookScripts 返回钩子脚本的包内路径
+func hookScripts(hooks *fileops.Hooks) map[string]bool {
+	out := map[string]bool{}
+	if hooks == nil {
+		return out
+	}
+	for _, h := range append(append([]fileops.Hook{}, hooks.Pre...), hooks.Post...) {
+		for _, script := range []string{h.Script, h.Undo} {
+			if rel, err := localSource(script); err == nil && script != "" {
+				out[rel] = true
+			}
+		}
+	}
+	return out
+}
+
+// readVersion 读取版本文件中的 MIMO 字段，缺失时使用默认版本；同时返回版本文件的包内路径
+func readVersion(root, srcDir string, vm *fileops.VersionMapping) (string, string) {
+	rel := path.Join(srcDir, "SPDK_for_MIMO/VERSION.json")
+	if vm != nil {
+		if r, err := localSource(vm.Src); err == nil {
+			rel = r
+		}
+	}
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	data, err := os.ReadFile(p)
+	if err != nil {
+		fmt.Printf("WARN: version file %s not found, using default version\n", p)
+	}
+	return env.ParseMimoVersion(data), rel
+}
+
+// checkTemplates 用示例变量渲染每个模板，提前发现语法错误和引用不存在的变量
+func checkTemplates(root string, cfg *fileops.Config, version, versionFile string) error {
+	data := &render.Data{
+		Version:     version,
+		VersionInfo: map[string]any{},
+		Hostname:    "localhost",
+		Bundle:      render.Bundle{Version: version},
+		Values:      render.Merge(cfg.Values),
+	}
+	if raw, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(versionFile))); err == nil {
+		if info, err := render.ParseVersionInfo(raw); err == nil {
+			data.VersionInfo = info
+		}
+	}
+	var problems []string
+	for _, fm := range cfg.FileMappings {
+		if !fm.Template {
+			continue
+		}
+		rel, err := localSource(fm.Src)
+		if err != nil {
+			continue // checkMappings 已报告
+		}
+		text, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			continue
+		}
+		if _, err := render.Render(rel, text, data); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid templates:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// loadBase 读取基础版本的清单：manifest.json 或独立资源包
+func loadBase(p string) (*manifest.Manifest, error) {
+	if m, err := manifest.Load(p); err == nil {
+		return m, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s is neither a manifest nor a bundle", p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s is neither a manifest nor a bundle: %w", p, err)
+		}
+		if hdr.Name == archiveName {
+			return manifest.ReadArchive(tr)
+		}
+	}
+}
+
+// delta 标记与基础版本相同的文件，返回只包含变化文件（及其上级目录）的条目。
+// 不属于任何映射的文件（config.json）与 always 中的文件（版本文件、钩子脚本）总是包含在内，
+// 安装时需要读取它们；
+// 模板总是包含在内，节点上需要用新版本的变量重新渲染。
+func delta(m, base *manifest.Manifest, entries []entry, always map[string]bool) []entry {
+	m.BaseVersion = base.Version
+	old := make(map[string]manifest.File, len(base.Files))
+	for _, f := range base.Files {
+		old[f.Path] = f
+	}
+	ship := map[string]bool{}
+	for i := range m.Files {
+		f := &m.Files[i]
+		o, ok := old[f.Path]
+		if ok && f.Target != "" && !always[f.Path] && !f.Template &&
+			o.Target == f.Target && o.SHA256 == f.SHA256 && o.Mode == f.Mode {
+			f.Unchanged = true
+			continue
+		}
+		for dir := f.Path; dir != "."; dir = path.Dir(dir) {
+			ship[dir] = true
+		}
+	}
+	var out []entry
+	for _, e := range entries {
+		if ship[e.name] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func countShipped(entries []entry) int {
+	n := 0
+	for _, e := range entries {
+		if e.info.Mode().IsRegular() {
+			n++
+		}
+	}
+	return n
+}
+
+// collect 遍历 srcDir，跟随符号链接（与 tar -h 一致）
+func collect(root, srcDir string) ([]entry, error) {
+	var out []entry
+	visiting := map[string]bool{}
+	var walk func(name, p string) error
+	walk = func(name, p string) error {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", p, err)
+		}
+		switch {
+		case fi.Mode().IsRegular():
+			out = append(out, entry{name: name, path: p, info: fi})
+			return nil
+		case !fi.IsDir():
+			fmt.Printf("WARN: skipping %s: unsupported file type %s\n", p, fi.Mode().Type())
+			return nil
+		}
+		real, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return err
+		}
+		if visiting[real] {
+			return fmt.Errorf("symlink loop at %s", p)
+		}
+		visiting[real] = true
+		defer delete(visiting, real)
+
+		out = append(out, entry{name: name, path: p, info: fi})
+		children, err := os.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, c := range children {
+			if err := walk(name+"/"+c.Name(), filepath.Join(p, c.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(srcDir, filepath.Join(root, filepath.FromSlash(srcDir))); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// mappingOf 返回包内路径所属的映射（最长前缀匹配）及其在映射源中的相对路径
+func mappingOf(name string, targets map[string]target) (*target, string) {
+	best := ""
+	for src := range targets {
+		if (name == src || strings.HasPrefix(name, src+"/")) && len(src) > len(best) {
+			best = src
+		}
+	}
+	if best == "" {
+		return nil, ""
+	}
+	t := targets[best]
+	return &t, strings.TrimPrefix(strings.TrimPrefix(name, best), "/")
+}
+
+// targetOf 返回包内路径对应的安装路径
+func targetOf(name string, targets map[string]target) string {
+	t, rel := mappingOf(name, targets)
+	if t == nil {
+		return ""
+	}
+	return filepath.Join(t.dst, filepath.FromSlash(rel))
+}
+
+// selected 去掉被目录映射的 include/exclude 排除的文件
+func selected(entries []entry, targets map[string]target) []entry {
+	out := entries[:0]
+	for _, e := range entries {
+		if e.info.Mode().IsRegular() {
+			if t, rel := mappingOf(e.name, targets); t != nil && rel != "" && !t.fm.Selects(rel) {
+				continue
+			}
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func buildManifest(entries []entry, targets map[string]target, ver string, epoch time.Time) (*manifest.Manifest, error) {
+	m := &manifest.Manifest{Schema: manifest.Schema, Version: ver}
+	if epoch.Unix() != 0 {
+		m.Created = epoch.Format(time.RFC3339)
+	}
+	for _, e := range entries {
+		if !e.info.Mode().IsRegular() {
+			continue
+		}
+		sum, err := fileops.FileDigest(e.path)
+		if err != nil {
+			return nil, fmt.Errorf("hash %s: %w", e.path, err)
+		}
+		f := manifest.File{
+			Path:   e.name,
+			Target: targetOf(e.name, targets),
+			Size:   e.info.Size(),
+			Mode:   e.info.Mode().Perm(),
+			SHA256: sum,
+		}
+		if t, _ := mappingOf(e.name, targets); t != nil {
+			if mode, ok := t.fm.FileMode(); ok {
+				f.Mode = mode
+			}
+			f.Owner, f.Group, f.Config, f.Template = t.fm.Owner, t.fm.Group, t.fm.Config, t.fm.Template
+		}
+		m.Files = append(m.Files, f)
+		m.UnpackedSize += e.info.Size()
+	}
+	return m, nil
+}
+
+// header 返回不含主机相关信息（属主、时间）的 tar 头
+func header(name string, mode os.FileMode, size int64, epoch time.Time) *tar.Header {
+	hdr := &tar.Header{Name: name, Mode: int64(mode.Perm()), Size: size, ModTime: epoch, Typeflag: tar.TypeReg}
+	if mode.IsDir() {
+		hdr.Name += "/"
+		hdr.Typeflag = tar.TypeDir
+		hdr.Size = 0
+	}
+	return hdr
+}
+
+// writeArchive 写入可复现的 tar.gz：manifest.json 在前，其余按路径排序，返回 SHA-256
+func writeArchive(dst string, m *manifest.Manifest, entries []entry, epoch time.Time) (string, error) {
+	data, err := m.Marshal()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	gw, _ := gzip.NewWriterLevel(io.MultiWriter(f, h), gzip.BestCompression)
+	tw := tar.NewWriter(gw)
+
+	if err := tw.WriteHeader(header(manifest.FileName, 0644, int64(len(data)), epoch)); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return "", err
+	}
+	modes := make(map[string]os.FileMode, len(m.Files))
+	for _, f := range m.Files {
+		modes[f.Path] = f.Mode
+	}
+	for _, e := range entries {
+		mode := e.info.Mode()
+		if fm, ok := modes[e.name]; ok {
+			mode = fm
+		}
+		if err := tw.WriteHeader(header(e.name, mode, e.info.Size(), epoch)); err != nil {
+			return "", fmt.Errorf("write header %s: %w", e.name, err)
+		}
+		if e.info.IsDir() {
+			continue
+		}
+		if err := copyInto(tw, e.path); err != nil {
+			return "", fmt.Errorf("pack %s: %w", e.path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gw.Close(); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyInto(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeBundle 生成独立资源包（未压缩 tar，包含归档、哈希与签名）
+func writeBundle(dst, dir string, epoch time.Time) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, name := range []string{archiveName, hashName, signatureName} {
+		p := filepath.Join(dir, name)
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(header(name, 0644, fi.Size(), epoch)); err != nil {
+			return err
+		}
+		if err := copyInto(tw, p); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// SignDigest 使用 keyPath 中的私钥对摘要签名，返回签名文件内容
+func SignDigest(keyPath, digest string) ([]byte, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	priv, err := signature.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	return signature.Sign(priv, digest), nil
+}
+
+func copyPlain(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// goBuild 编译带版本号的 mimo 可执行文件
+func goBuild(root, out, ver string) error {
+	fmt.Println("INFO: building", out)
+	abs, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "build", "-trimpath",
+		"-ldflags", "-X mimo/internal/version.BuildVersion="+ver,
+		"-o", abs, ".")
+	cmd.Dir = root
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go build: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Package render fills in resource files marked as templates. Templates use
+// Go text/template syntax and are rendered on the node at install time, so
+// version and branding strings always match what was actually installed.
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ValuesPath 节点上用户提供的模板变量（JSON 对象），覆盖 config.json 中的默认值
+const ValuesPath = "/etc/mimo/values.json"
+
+// Data 模板可用的变量
+type Data struct {
+	// Version 新版本号（VERSION.json 中的 MIMO 字段）
+	Version string
+	// VersionInfo VERSION.json 的全部字段
+	VersionInfo map[string]any
+	// Hostname 节点主机名
+	Hostname string
+	// Bundle 资源包信息
+	Bundle Bundle
+	// Values 用户变量：config.json 的 values，被 ValuesPath 与 --set 覆盖
+	Values map[string]string
+}
+
+// Bundle 资源包元数据（来自包内清单）
+type Bundle struct {
+	Version string
+	// Base 增量包的基础版本，完整包为空
+	Base    string
+	Created string
+}
+
+var funcs = template.FuncMap{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+}
+
+// Parse 解析模板；引用不存在的变量时渲染失败，而不是输出 "<no value>"
+func Parse(name string, text []byte) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(string(text))
+}
+
+// Render 用 data 渲染模板内容
+func Render(name string, text []byte, data *Data) ([]byte, error) {
+	t, err := Parse(name, text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// File 原地渲染 path，保留文件权限
+func File(path string, data *Data) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("template %s is not a regular file", path)
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out, err := Render(path, text, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, fi.Mode().Perm())
+}
+
+// ParseVersionInfo 解析 VERSION.json
+func ParseVersionInfo(data []byte) (map[string]any, error) {
+	info := map[string]any{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("parse version file: %w", err)
+	}
+	return info, nil
+}
+
+// LoadValues 读取 path 中的用户变量；文件不存在时返回空
+func LoadValues(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return v, nil
+}
+
+// ParseSet 解析 --set 给出的 KEY=VALUE
+func ParseSet(args []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid value %q (want KEY=VALUE)", a)
+		}
+		out[strings.TrimSpace(k)] = v
+	}
+	return out, nil
+}
+
+// Merge 按顺序合并变量，后者覆盖前者
+func Merge(layers ...map[string]string) map[string]string {
+	out := map[string]string{}
+	for _, l := range layers {
+		for k, v := range l {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Keys 返回排好序的变量名
+func Keys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
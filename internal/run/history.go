@@ -0,0 +1,113 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"mimo/internal/decompress"
+	"mimo/internal/history"
+	"mimo/internal/transaction"
+)
+
+// audit 收集一次更新的历史记录，结束时追加到历史文件。
+// dry-run 不记录，此时为 nil，所有方法均为空操作。
+type audit struct {
+	entry history.Entry
+	txn   *transaction.Transaction
+}
+
+// newAudit 开始记录一次 kind 类型的更新
+func newAudit(kind string, opts Options) *audit {
+	if opts.DryRun {
+		return nil
+	}
+	host, _ := os.Hostname()
+	return &audit{entry: history.Entry{
+		ID:      history.NewID(),
+		Kind:    kind,
+		Started: time.Now().UTC(),
+		User:    history.CurrentUser(),
+		Host:    host,
+		Command: os.Args,
+	}}
+}
+
+// bundle 记录资源包来源、哈希以及新旧版本
+func (a *audit) bundle(b *decompress.Bundle) {
+	if a == nil || b == nil {
+		return
+	}
+	v := CurrentVersions(b)
+	a.entry.Bundle = b.Source
+	a.entry.BundleSHA256 = b.Digest()
+	a.versions(v.Installed, v.Bundle)
+}
+
+// versions 记录更新前后的版本
+func (a *audit) versions(old, next string) {
+	if a == nil {
+		return
+	}
+	a.entry.OldVersion, a.entry.NewVersion = old, next
+}
+
+// track 记录执行的事务，结束时保存其动作及状态
+func (a *audit) track(txn *transaction.Transaction) {
+	if a == nil {
+		return
+	}
+	a.txn = txn
+}
+
+// finish 按 err 判断结果并写入历史文件，原样返回 err；写入失败只告警
+func (a *audit) finish(err error) error {
+	if a == nil {
+		return err
+	}
+	e := &a.entry
+	e.Finished = time.Now().UTC()
+	e.Outcome = outcome(err)
+	if a.txn != nil {
+		e.Transaction = a.txn.ID()
+		e.Actions = a.txn.Steps()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	var re *transaction.RunError
+	if errors.As(err, &re) && re.RollbackErr != nil {
+		e.RollbackError = re.RollbackErr.Error()
+	}
+	if werr := history.Append(history.DefaultPath, e); werr != nil {
+		fmt.Printf("WARN: recording update history failed: %v\n", werr)
+	}
+	return err
+}
+
+// outcome 将更新返回的错误换算为历史记录中的结果
+func outcome(err error) string {
+	if err == nil {
+		return history.OutcomeSuccess
+	}
+	var xe *ExitError
+	if errors.As(err, &xe) {
+		switch xe.Code {
+		case ExitOK:
+			return history.OutcomeSuccess
+		case ExitCancelled:
+			return history.OutcomeCancelled
+		case ExitNoOp:
+			return history.OutcomeNoOp
+		case ExitRolledBack:
+			return history.OutcomeRolledBack
+		}
+	}
+	return history.OutcomeFailed
+}
+
+// History 返回历史记录，skipped 为无法解析而跳过的行数
+func History() (entries []history.Entry, skipped int, err error) {
+	return history.Load(history.DefaultPath)
+}
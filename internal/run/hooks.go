@@ -0,0 +1,37 @@
+package run
+
+import (
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/hooks"
+	"mimo/internal/transaction"
+)
+
+// 传给钩子脚本的环境变量
+const (
+	envHookKind       = "MIMO_UPDATE_KIND"
+	envHookStage      = "MIMO_STAGE"
+	envHookOldVersion = "MIMO_OLD_VERSION"
+	envHookNewVersion = "MIMO_NEW_VERSION"
+)
+
+// registerHooks 注册 config.json 中 phase 阶段的钩子，kind 为事务名称
+func registerHooks(txn *transaction.Transaction, cfg *fileops.Config, phase, kind, stage string) error {
+	if cfg.Hooks == nil {
+		return nil
+	}
+	list := cfg.Hooks.Pre
+	if phase == hooks.Post {
+		list = cfg.Hooks.Post
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	vars := []string{envHookKind + "=" + kind, envHookStage + "=" + stage}
+	if cfg.Version != nil {
+		vars = append(vars,
+			envHookOldVersion+"="+env.ReadMimoVersion(cfg.Version.Dst),
+			envHookNewVersion+"="+env.ReadMimoVersion(cfg.Version.Src))
+	}
+	return hooks.Register(txn, phase, list, vars)
+}
@@ -0,0 +1,173 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/manifest"
+	"mimo/internal/originals"
+	"mimo/internal/slots"
+	"mimo/internal/transaction"
+)
+
+// install 描述一次文件安装：按资源包清单增量同步，
+// /usr/local/mimo 安装到新的版本槽位后再原子切换
+type install struct {
+	next   *manifest.Manifest
+	layout slots.Layout
+	// slot 新版本的槽位目录，空表示原地安装
+	slot string
+	// recordSlot 安装完成后保存清单的槽位
+	recordSlot string
+}
+
+// prepareInstall 注册安装新文件的动作（迁移旧目录、准备槽位、同步文件），
+// 不改变当前运行的版本；切换由 finish 注册
+func prepareInstall(txn *transaction.Transaction, cfg *fileops.Config, stage string) (*install, error) {
+	in := &install{layout: slots.Default()}
+	originals.RegisterKeepAction(txn, originals.Default(), systemFiles(cfg))
+
+	next, err := manifest.Load(filepath.Join(stage, manifest.FileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		fmt.Println("WARN: bundle has no manifest, copying all mappings in place")
+		if err := fileops.RegisterCopyActions(txn, cfg); err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+	// 条件不满足的映射已从 cfg 中去掉，其文件也不安装
+	next = next.Filter(func(f manifest.File) bool {
+		return f.Target == "" || cfg.Owns(f.Target)
+	})
+	in.next = next
+
+	installed, err := manifest.Load(manifest.InstalledPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("WARN: ignoring installed manifest: %v\n", err)
+		}
+		installed = nil
+	}
+	if next.IsDelta() {
+		if installed == nil {
+			return nil, fmt.Errorf("delta bundle %s requires %s installed, but no installed manifest was found", next.Version, next.BaseVersion)
+		}
+		if installed.Version != next.BaseVersion {
+			return nil, fmt.Errorf("delta bundle %s applies to %s only, installed version is %s", next.Version, next.BaseVersion, installed.Version)
+		}
+		fmt.Printf("INFO: delta bundle %s -> %s\n", next.BaseVersion, next.Version)
+	}
+
+	redirect, err := in.prepareSlot(txn, cfg, installed)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileops.RegisterSyncActions(txn, cfg, stage, next, installed, redirect); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// prepareSlot 为新版本准备槽位，返回同步时的目录重定向（新版本已是当前版本时重定向到当前槽位）；
+// 配置中没有映射到槽位链接时返回 nil
+func (in *install) prepareSlot(txn *transaction.Transaction, cfg *fileops.Config, installed *manifest.Manifest) (map[string]string, error) {
+	l := in.layout
+	managed := false
+	for _, m := range cfg.FileMappings {
+		if filepath.Clean(m.Dst) == l.Link {
+			managed = true
+		}
+	}
+	if !managed {
+		return nil, nil
+	}
+
+	slot, err := l.SlotPath(in.next.Version)
+	if err != nil {
+		return nil, err
+	}
+	active := l.Active()
+	if active == slot {
+		// 写入槽位本身而不是链接，使链接不会被替换为目录
+		fmt.Printf("WARN: %s is the active version, updating %s in place\n", in.next.Version, slot)
+		in.recordSlot = slot
+		return map[string]string{l.Link: slot}, nil
+	}
+
+	legacy := l.IsLegacy()
+	if legacy {
+		// 旧式安装：整个目录移入以其版本命名的槽位，成为可切回的上一版本
+		name := env.ReadMimoVersion(filepath.Join(l.Link, versionFile))
+		if p, err := l.SlotPath(name); err != nil || name == in.next.Version || exists(p) {
+			name += "-legacy"
+		}
+		if err := slots.RegisterMigrateAction(txn, l, name); err != nil {
+			return nil, err
+		}
+	}
+	if installed != nil && (legacy || active != "") {
+		if err := slots.RegisterSeedAction(txn, l, slot); err != nil {
+			return nil, err
+		}
+	}
+	in.slot, in.recordSlot = slot, slot
+	return map[string]string{l.Link: slot}, nil
+}
+
+// finish 注册切换到新槽位并记录已安装清单的动作
+func (in *install) finish(txn *transaction.Transaction) error {
+	if in.slot != "" {
+		if err := slots.RegisterSwitchAction(txn, in.layout, in.slot); err != nil {
+			return err
+		}
+	}
+	if in.next == nil {
+		if exists(manifest.InstalledPath) {
+			// 旧资源包不带清单，已记录的清单不再可信
+			return fileops.RegisterManifestAction(txn, manifest.InstalledPath, nil)
+		}
+		return nil
+	}
+	return fileops.RegisterManifestAction(txn, manifest.InstalledPath, in.next.Installed())
+}
+
+// done 在事务提交后保存槽位清单并清理旧版本；失败只告警
+func (in *install) done() {
+	if in.recordSlot == "" || in.next == nil {
+		return
+	}
+	if err := in.layout.SaveManifest(in.recordSlot, in.next.Installed()); err != nil {
+		fmt.Printf("WARN: saving slot manifest failed: %v\n", err)
+	}
+	removed, err := in.layout.Prune(slots.DefaultKeep)
+	for _, p := range removed {
+		fmt.Printf("INFO: removed old version %s\n", p)
+	}
+	if err != nil {
+		fmt.Printf("WARN: pruning old versions failed: %v\n", err)
+	}
+}
+
+// systemFiles 返回需要保存原始状态的映射目标：单个文件，以及尚不存在的目录
+// （只记录“原本不存在”，卸载时整体删除；已存在的目录过大，不做副本）
+func systemFiles(cfg *fileops.Config) []string {
+	var out []string
+	for _, m := range cfg.FileMappings {
+		if fi, err := os.Stat(m.Src); err == nil && fi.IsDir() && exists(m.Dst) {
+			continue
+		}
+		out = append(out, filepath.Clean(m.Dst))
+	}
+	return out
+}
+
+func exists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
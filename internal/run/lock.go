@@ -0,0 +1,35 @@
+package run
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"mimo/internal/history"
+	"mimo/internal/lock"
+)
+
+// updateLock 本进程持有的更新锁；recover --resume 重新执行更新时复用
+var updateLock *lock.Lock
+
+// acquireLock 获取系统级更新锁并返回释放函数，本进程已持有时直接返回。
+// 其它进程持有时返回的错误说明持有者。
+func acquireLock() (func(), error) {
+	if updateLock != nil {
+		return func() {}, nil
+	}
+	l, err := lock.Acquire(lock.DefaultPath, lock.Holder{
+		PID:     os.Getpid(),
+		User:    history.CurrentUser(),
+		Command: strings.Join(os.Args, " "),
+		Since:   time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	updateLock = l
+	return func() {
+		_ = l.Release()
+		updateLock = nil
+	}, nil
+}
@@ -0,0 +1,176 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"mimo/internal/env"
+	"mimo/internal/spdk"
+	"mimo/internal/transaction"
+)
+
+// 环境变量，与对应命令行参数等价（参数优先）
+const (
+	EnvAssumeYes   = "MIMO_ASSUME_YES"
+	EnvStopRunning = "MIMO_STOP_RUNNING"
+	EnvStagingDir  = "MIMO_STAGING_DIR"
+)
+
+// StopPolicy 决定遇到正在运行的 MIMO 时如何处理
+type StopPolicy string
+
+const (
+	StopAsk   StopPolicy = "ask"   // 交互询问（--yes 时视为 auto）
+	StopAuto  StopPolicy = "auto"  // 直接停止，更新后重启
+	StopNever StopPolicy = "never" // 不停止，取消更新
+)
+
+// ParseStopPolicy 解析 --stop-running 的取值
+func ParseStopPolicy(s string) (StopPolicy, error) {
+	switch p := StopPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return StopAsk, nil
+	case StopAsk, StopAuto, StopNever:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid stop policy %q (want auto, never or ask)", s)
+}
+
+// Options 控制一次更新的执行方式
+type Options struct {
+	// DryRun 只输出完整执行计划，不修改系统
+	DryRun bool
+	// AssumeYes 对所有确认提示自动回答 yes
+	AssumeYes bool
+	// StopRunning 遇到正在运行的 MIMO 时的处理策略
+	StopRunning StopPolicy
+	// Force 忽略版本检查（允许重复安装和降级）
+	Force bool
+	// AllowDowngrade 允许安装比已安装版本更旧的版本
+	AllowDowngrade bool
+	// SkipSignature 跳过资源包签名校验（仅用于开发环境）
+	SkipSignature bool
+	// Bundle 外部资源包文件路径（"-" 表示标准输入），为空时使用内嵌资源包
+	Bundle string
+	// StartTimeout 重启后等待 MIMO 就绪并恢复全部 bdev 的时间，0 表示默认值
+	StartTimeout time.Duration
+	// StagingDir 解压资源包的父目录，每次更新在其下创建独占的子目录；空表示系统临时目录
+	StagingDir string
+	// Values 模板变量（--set），覆盖 config.json 与节点上的默认值
+	Values map[string]string
+}
+
+// OptionsFromEnv 返回由环境变量给出的默认选项
+func OptionsFromEnv() (Options, error) {
+	opts := Options{StopRunning: StopAsk}
+	if v := os.Getenv(EnvAssumeYes); v != "" {
+		opts.AssumeYes = isTrue(v)
+	}
+	if v := os.Getenv(EnvStopRunning); v != "" {
+		p, err := ParseStopPolicy(v)
+		if err != nil {
+			return opts, fmt.Errorf("%s: %w", EnvStopRunning, err)
+		}
+		opts.StopRunning = p
+	}
+	opts.StagingDir = os.Getenv(EnvStagingDir)
+	return opts, nil
+}
+
+func isTrue(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "y", "yes", "true", "on":
+		return true
+	}
+	return false
+}
+
+// confirm 询问用户，AssumeYes 时直接返回 true
+func (o Options) confirm(msg string) bool {
+	if o.AssumeYes {
+		fmt.Println(msg + "y (assumed)")
+		return true
+	}
+	return env.ConfirmPrompt(msg)
+}
+
+// startTimeout 返回重启的健康检查超时
+func (o Options) startTimeout() time.Duration {
+	if o.StartTimeout > 0 {
+		return o.StartTimeout
+	}
+	return spdk.DefaultStartTimeout
+}
+
+// stagingDir 返回暂存目录的父目录
+func (o Options) stagingDir() string {
+	if o.StagingDir != "" {
+		return o.StagingDir
+	}
+	return os.TempDir()
+}
+
+// shouldStop 按策略决定是否停止正在运行的 MIMO
+func (o Options) shouldStop() bool {
+	switch o.StopRunning {
+	case StopAuto:
+		return true
+	case StopNever:
+		return false
+	}
+	return o.confirm("Stop MIMO now? [y/N]: ")
+}
+
+// 退出码：供自动化脚本区分更新结果
+const (
+	ExitOK         = 0
+	ExitFailed     = 1 // 更新失败且未能完全回滚，或发生其它错误
+	ExitCancelled  = 2 // 用户或策略取消，系统未改动
+	ExitRolledBack = 3 // 更新失败，所有改动已回滚
+	ExitNoOp       = 4 // 无需更新
+)
+
+// ExitError 携带退出码的错误
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	switch e.Code {
+	case ExitCancelled:
+		return "update cancelled"
+	case ExitNoOp:
+		return "nothing to update"
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode 返回进程退出码
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// cancelled 返回表示取消的错误
+func cancelled() error {
+	return &ExitError{Code: ExitCancelled}
+}
+
+// txnFailed 根据回滚结果为事务错误附加退出码
+func txnFailed(err error) error {
+	var re *transaction.RunError
+	if errors.As(err, &re) && re.RolledBack() {
+		return &ExitError{Code: ExitRolledBack, Err: err}
+	}
+	return &ExitError{Code: ExitFailed, Err: err}
+}
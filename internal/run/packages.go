@@ -0,0 +1,105 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"mimo/internal/debs"
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/transaction"
+)
+
+const (
+	pkgdepScript  = "pkgdep.sh"
+	scriptsSubDir = "scripts"
+)
+
+// registerPackages 对照 dpkg 状态检查 config.json 声明的软件包依赖，注册从资源包仓库安装缺失包的动作。
+// 必需包无法从仓库满足时直接报错，不开始事务。资源包没有声明依赖时回退到 apt update 与 SPDK 的 pkgdep.sh。
+func registerPackages(txn *transaction.Transaction, cfg *fileops.Config, stage string) error {
+	if cfg.Packages == nil || len(cfg.Packages.Require) == 0 {
+		registerPkgDep(txn, stage)
+		return nil
+	}
+	pkgs := cfg.Packages.Require
+
+	installed, err := debs.Installed(debs.StatusPath)
+	if err != nil {
+		return fmt.Errorf("read dpkg status: %w", err)
+	}
+	repo, err := debs.Repo(cfg.Packages.Repo, debs.Arch())
+	if err != nil {
+		return fmt.Errorf("read package repository: %w", err)
+	}
+
+	plan := debs.NewPlan(pkgs, installed, repo)
+	for _, s := range plan.Skipped {
+		fmt.Printf("WARN: optional package %s\n", s)
+	}
+	if len(plan.Missing) > 0 {
+		return fmt.Errorf("required packages cannot be installed from the bundle:\n  %s", strings.Join(plan.Missing, "\n  "))
+	}
+	fmt.Printf("INFO: package dependencies: %d satisfied, %d to install\n", len(plan.Satisfied), len(plan.Install))
+	debs.RegisterInstallAction(txn, plan, pkgs, installed)
+	return nil
+}
+
+// findPkgDep 查找依赖安装脚本，未找到返回空串
+func findPkgDep(stage, mimoRoot string) string {
+	// look for pkgdep script in a few locations (prefer unpacked resources)
+	candidates := []string{
+		filepath.Join(stage, "file", "SPDK_for_MIMO", scriptsSubDir, pkgdepScript), // unpacked package (first run)
+		filepath.Join(mimoRoot, scriptsSubDir, pkgdepScript),                       // installed location (later runs)
+	}
+
+	for _, p := range candidates {
+		p = filepath.Clean(p)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// registerPkgDep 注册旧的依赖安装方式：apt update 后执行 pkgdep.sh，优先使用暂存目录 stage 中新版本的脚本。
+// 需要网络，失败只告警，不可撤销；资源包在 config.json 中声明 packages 后不再使用。
+func registerPkgDep(txn *transaction.Transaction, stage string) {
+	script := findPkgDep(stage, env.MimoRoot())
+	if script == "" {
+		fmt.Println("WARN: bundle declares no package dependencies and the dependency script was not found, skipping")
+		return
+	}
+	fmt.Println("WARN: bundle declares no package dependencies, falling back to 'apt update' and " + pkgdepScript + " (needs network)")
+	txn.Add(&transaction.Action{
+		Name: "install package dependencies with " + pkgdepScript,
+		Describe: func() []string {
+			return []string{"run 'apt update'", "run " + script}
+		},
+		Do: func() error {
+			fmt.Println("INFO: running 'sudo apt update'...")
+			updateCmd := exec.Command("sudo", "apt", "update")
+			updateCmd.Stdout = os.Stdout
+			updateCmd.Stderr = os.Stderr
+			if err := updateCmd.Run(); err != nil {
+				fmt.Printf("WARN: 'apt update' failed: %v\n", err)
+			} else {
+				fmt.Println("INFO: 'apt update' completed")
+			}
+
+			fmt.Println("INFO: installing package dependencies; this may take some time...")
+			cmd := exec.Command("bash", script)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				fmt.Printf("WARN: dependency installation failed: %v\n", err)
+			} else {
+				fmt.Println("INFO: dependencies installed")
+			}
+			return nil
+		},
+	})
+}
@@ -3,125 +3,339 @@ package run
 import (
 	"fmt"
 	"mimo/internal/decompress"
+	"mimo/internal/doctor"
 	"mimo/internal/env"
 	"mimo/internal/fileops"
 	"mimo/internal/grub"
+	"mimo/internal/hooks"
+	"mimo/internal/hugepages"
 	"mimo/internal/motd"
+	"mimo/internal/originals"
+	"mimo/internal/signature"
 	"mimo/internal/spdk"
 	"mimo/internal/system"
 	"mimo/internal/systemd"
 	"mimo/internal/transaction"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"strings"
 )
 
 const (
-	tmpDir        = "/tmp/mimo-output"
-	configFile    = "config.json"
-	pkgdepScript  = "pkgdep.sh"
-	scriptsSubDir = "scripts"
+	configFile = "config.json"
+
+	txnSys    = "update-sys"
+	txnTarget = "update-target"
 )
 
 // 注意：spdkSock 已移除，使用 spdk.SPDKSock() 代替
 
-func RunPkgDep() {
-	mimoRoot := env.EnsureMimoRoot()
-
-	// look for pkgdep script in a few locations (prefer unpacked resources)
-	candidates := []string{
-		filepath.Join(tmpDir, "file", "SPDK_for_MIMO", scriptsSubDir, pkgdepScript), // unpacked package (first run)
-		filepath.Join(mimoRoot, scriptsSubDir, pkgdepScript),                        // installed location (later runs)
+// newTransaction 创建更新事务；dry-run 时不写事务日志
+func newTransaction(name string, opts Options) (*transaction.Transaction, error) {
+	if opts.DryRun {
+		return transaction.NewNamed(name), nil
 	}
-
-	var pkgdepScript string
-	for _, p := range candidates {
-		p = filepath.Clean(p)
-		if _, err := os.Stat(p); err == nil {
-			pkgdepScript = p
-			break
-		}
+	txn, err := transaction.NewJournaled(transaction.DefaultJournalRoot, name)
+	if err != nil {
+		return nil, fmt.Errorf("open transaction journal failed: %w", err)
 	}
+	return txn, nil
+}
 
-	if pkgdepScript == "" {
-		fmt.Println("WARN: dependency script not found, skipping")
-		return
+// printSection 输出 dry-run 计划中事务之外的步骤
+func printSection(title string, lines []string) {
+	fmt.Printf("PLAN: %s\n", title)
+	if len(lines) == 0 {
+		fmt.Println("        (nothing)")
 	}
-	fmt.Println("INFO: running 'sudo apt update'...")
-	updateCmd := exec.Command("sudo", "apt", "update")
-	updateCmd.Stdout = os.Stdout
-	updateCmd.Stderr = os.Stderr
-	if err := updateCmd.Run(); err != nil {
-		fmt.Printf("WARN: 'apt update' failed: %v\n", err)
-	} else {
-		fmt.Println("INFO: 'apt update' completed")
+	for _, l := range lines {
+		fmt.Printf("        %s\n", l)
 	}
+}
 
-	fmt.Println("INFO: installing package dependencies; this may take some time...")
-	cmd := exec.Command("bash", pkgdepScript)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("WARN: dependency installation failed: %v\n", err)
-	} else {
-		fmt.Println("INFO: dependencies installed")
-	}
+// RunTransaction 执行系统更新事务，stage 为资源包的解压目录
+func RunTransaction(cfg *fileops.Config, stage string, opts Options) error {
+	return runTransaction(cfg, stage, opts, nil)
 }
 
-func RunTransaction(cfg *fileops.Config) error {
-	txn := transaction.New()
+// runTransaction 执行系统更新事务，rec 不为 nil 时记录执行的动作
+func runTransaction(cfg *fileops.Config, stage string, opts Options, rec *audit) error {
+	txn, err := newTransaction(txnSys, opts)
+	if err != nil {
+		return err
+	}
 	defer txn.Cleanup()
+	rec.track(txn)
+
+	if err := registerHooks(txn, cfg, hooks.Pre, txnSys, stage); err != nil {
+		return fmt.Errorf("setup hooks failed: %w", err)
+	}
+
+	if err := registerPackages(txn, cfg, stage); err != nil {
+		return fmt.Errorf("setup package dependencies failed: %w", err)
+	}
 
 	if err := motd.RegisterMOTDActions(txn); err != nil {
 		return fmt.Errorf("setup MOTD actions failed: %w", err)
 	}
 
-	if err := fileops.RegisterCopyActions(txn, cfg); err != nil {
+	in, err := prepareInstall(txn, cfg, stage)
+	if err != nil {
+		return fmt.Errorf("setup file copy actions failed: %w", err)
+	}
+	if err := in.finish(txn); err != nil {
 		return fmt.Errorf("setup file copy actions failed: %w", err)
 	}
 
-	if err := grub.RegisterGrubAndInitActions(txn); err != nil {
+	originals.RegisterKeepAction(txn, originals.Default(), grub.Paths())
+	var edits []func(*grub.Cmdline)
+	var pages *hugepages.Setting
+	if h := cfg.Hugepages; h != nil {
+		pages = &hugepages.Setting{Size: h.Size, Count: h.Count, PerNUMA: h.PerNUMA}
+		edits = append(edits, func(c *grub.Cmdline) { hugepages.SetCmdline(c, *pages) })
+	}
+	if err := grub.RegisterGrubAndInitActions(txn, edits...); err != nil {
 		return fmt.Errorf("setup GRUB actions failed: %w", err)
 	}
+	if pages != nil {
+		if _, err := hugepages.RegisterApplyAction(txn, *pages); err != nil {
+			return fmt.Errorf("setup hugepage actions failed: %w", err)
+		}
+	}
+
+	if !system.RegisterCloudInitAction(txn, false) {
+		fmt.Println("INFO: cloud-init already disabled")
+	}
+
+	if err := registerHooks(txn, cfg, hooks.Post, txnSys, stage); err != nil {
+		return fmt.Errorf("setup hooks failed: %w", err)
+	}
+
+	if opts.DryRun {
+		txn.Plan(os.Stdout)
+		return nil
+	}
 
 	if err := txn.Run(); err != nil {
-		return fmt.Errorf("executing update actions failed: %w", err)
+		return txnFailed(fmt.Errorf("executing update actions failed: %w", err))
 	}
+	in.done()
 
 	return nil
 }
 
-// extractAndVerify 提取并验证资源，返回错误
-func extractAndVerify(tmpDir string) error {
-	if err := decompress.ExtractResources(tmpDir); err != nil {
+// loadBundle 返回 --bundle 指定的资源包，未指定时使用内嵌资源包
+func loadBundle(opts Options) (*decompress.Bundle, error) {
+	if opts.Bundle == "" {
+		return decompress.Embedded(), nil
+	}
+	b, err := decompress.Open(opts.Bundle)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("INFO: using bundle from %s\n", b.Source)
+	return b, nil
+}
+
+// extractAndVerify 先校验资源包的哈希与签名，通过后才解压；资源包记入 rec
+func extractAndVerify(stage string, opts Options, rec *audit) error {
+	b, err := verifiedBundle(opts, rec)
+	if err != nil {
+		return err
+	}
+	return extract(b, stage)
+}
+
+// verifiedBundle 加载资源包并校验哈希与签名；资源包记入 rec
+func verifiedBundle(opts Options, rec *audit) (*decompress.Bundle, error) {
+	b, err := loadBundle(opts)
+	if err != nil {
+		return nil, err
+	}
+	rec.bundle(b)
+	if ok := b.VerifyHash(); !ok {
+		return nil, fmt.Errorf("resource verification failed")
+	}
+	if opts.SkipSignature {
+		fmt.Println("WARN: bundle signature check skipped (--insecure-skip-signature)")
+	} else if err := b.VerifySignature(signature.DefaultTrustedDir); err != nil {
+		return nil, fmt.Errorf("bundle signature verification failed: %w", err)
+	}
+	return b, nil
+}
+
+// extract 确认暂存目录空间足够后解压已校验的资源包
+func extract(b *decompress.Bundle, stage string) error {
+	if err := checkSpace(stage, b); err != nil {
+		return err
+	}
+	if err := b.ExtractResources(stage); err != nil {
 		return fmt.Errorf("extracting resources failed: %w", err)
 	}
-	if ok := decompress.VerifyHash(); !ok {
-		return fmt.Errorf("resource verification failed")
+	return nil
+}
+
+// preflight 执行与 mimo doctor 相同的检查，有失败项时拒绝更新（dry-run 只报告）
+func preflight(b *decompress.Bundle, opts Options) error {
+	fmt.Println("INFO: running pre-flight checks...")
+	rs := doctor.Run(doctor.Input{Bundle: b, StagingDir: opts.stagingDir()})
+	doctor.Print(os.Stdout, rs, true)
+	failed := doctor.Failed(rs)
+	if len(failed) == 0 {
+		return nil
+	}
+	if opts.DryRun {
+		fmt.Println("WARN: the update will be refused until the failed checks are fixed")
+		return nil
+	}
+	names := make([]string, 0, len(failed))
+	for _, r := range failed {
+		names = append(names, r.Name)
+	}
+	return fmt.Errorf("pre-flight checks failed: %s (run 'mimo doctor' for details)", strings.Join(names, ", "))
+}
+
+// checkPending 拒绝在存在未完成事务时开始新的更新
+func checkPending() error {
+	pending, err := transaction.ListPending(transaction.DefaultJournalRoot)
+	if err != nil {
+		return fmt.Errorf("checking interrupted updates failed: %w", err)
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("interrupted update %s (%s) found; run 'mimo update recover' first", pending[0].ID, pending[0].Name)
+	}
+	return nil
+}
+
+// Recover 回滚所有中断的更新事务；resume 为 true 时随后重新执行被中断的更新，
+// discard 为 true 时仅丢弃日志而不做任何撤销
+func Recover(resume, discard bool) error {
+	env.MustBeRoot()
+	release, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	pending, err := transaction.ListPending(transaction.DefaultJournalRoot)
+	if err != nil {
+		return fmt.Errorf("reading transaction journal failed: %w", err)
+	}
+	if len(pending) == 0 {
+		fmt.Println("INFO: no interrupted update found")
+		return nil
+	}
+
+	var rerun []string
+	for _, p := range pending {
+		fmt.Printf("INFO: recovering %s (%s, %s, started %s)\n", p.ID, p.Name, p.Status, p.Started.Local().Format("2006-01-02 15:04:05"))
+		if discard {
+			if err := p.Discard(); err != nil {
+				return err
+			}
+			fmt.Printf("WARN: journal %s discarded without rollback\n", p.ID)
+			continue
+		}
+		for i := len(p.Actions) - 1; i >= 0; i-- {
+			a := p.Actions[i]
+			if a.Undone {
+				continue
+			}
+			state := "started"
+			if a.Done {
+				state = "done"
+			}
+			fmt.Printf("INFO:   undo %s (%s)\n", a.Name, state)
+		}
+		if err := p.Rollback(); err != nil {
+			return fmt.Errorf("rolling back %s failed: %w", p.ID, err)
+		}
+		fmt.Printf("INFO: %s rolled back\n", p.ID)
+		rerun = append(rerun, p.Name)
+	}
+
+	if !resume || discard {
+		return nil
+	}
+	for _, name := range rerun {
+		fmt.Printf("INFO: resuming %s\n", name)
+		// 用户已明确要求恢复，不再逐项确认
+		opts := Options{AssumeYes: true, StopRunning: StopAuto}
+		switch name {
+		case txnSys:
+			err = RunUpdate(opts)
+		case txnTarget:
+			err = RuntgtUpdate(opts)
+		default:
+			fmt.Printf("WARN: unknown transaction %q, not resumed\n", name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func RunUpdate() error {
+func RunUpdate(opts Options) (err error) {
 	env.MustBeRoot()
+	rec := newAudit(txnSys, opts)
+	defer func() { err = rec.finish(err) }()
+
+	if !opts.DryRun {
+		release, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if err := checkPending(); err != nil {
+		if !opts.DryRun {
+			return err
+		}
+		fmt.Printf("WARN: %v\n", err)
+	}
+
+	b, err := verifiedBundle(opts, rec)
+	if err != nil {
+		return err
+	}
+	if err := preflight(b, opts); err != nil {
+		return err
+	}
 
-	env.EnsureMimoRoot()
-	cleanTmp := filepath.Clean(tmpDir)
+	if !opts.DryRun {
+		env.EnsureMimoRoot()
+	}
+	stage, err := newStaging(opts.stagingDir())
+	if err != nil {
+		return err
+	}
 	defer func() {
-		_ = os.RemoveAll(cleanTmp)
+		_ = os.RemoveAll(stage)
 	}()
 
-	if err := extractAndVerify(cleanTmp); err != nil {
+	if err := extract(b, stage); err != nil {
 		return err
 	}
 
-	RunPkgDep()
+	cfg, err := loadConfig(stage)
+	if err != nil {
+		return err
+	}
+	if err := renderTemplates(cfg, stage, opts); err != nil {
+		return err
+	}
 
-	configPath := filepath.Join(cleanTmp, configFile)
-	cfg := env.LoadFileOpsConfig(configPath)
+	if opts.DryRun {
+		if err := runTransaction(cfg, stage, opts, nil); err != nil {
+			return err
+		}
+		printSection("services to enable", systemd.PlanServices(cfg))
+		fmt.Println("INFO: dry run, nothing was changed")
+		return nil
+	}
 
-	if err := RunTransaction(cfg); err != nil {
+	if err := runTransaction(cfg, stage, opts, rec); err != nil {
 		return fmt.Errorf("executing transaction failed: %w", err)
 	}
 
@@ -129,95 +343,130 @@ func RunUpdate() error {
 		return fmt.Errorf("enabling system services failed: %w", err)
 	}
 
-	if err := system.DisableCloudInit(); err != nil {
-		return fmt.Errorf("disabling cloud-init failed: %w", err)
-	}
-
 	return nil
 }
 
-func RuntgtUpdate() error {
+func RuntgtUpdate(opts Options) (err error) {
 	env.MustBeRoot()
+	rec := newAudit(txnTarget, opts)
+	defer func() { err = rec.finish(err) }()
+
+	if !opts.DryRun {
+		release, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if err := checkPending(); err != nil {
+		if !opts.DryRun {
+			return err
+		}
+		fmt.Printf("WARN: %v\n", err)
+	}
 
-	env.EnsureMimoRoot()
-	cleanTmp := filepath.Clean(tmpDir)
+	if !opts.DryRun {
+		env.EnsureMimoRoot()
+	}
+	stage, err := newStaging(opts.stagingDir())
+	if err != nil {
+		return err
+	}
 	defer func() {
-		_ = os.RemoveAll(cleanTmp)
+		_ = os.RemoveAll(stage)
 	}()
 
 	fmt.Println("INFO: extracting package...")
-	if err := extractAndVerify(cleanTmp); err != nil {
+	if err := extractAndVerify(stage, opts, rec); err != nil {
 		return err
 	}
 
-	configPath := filepath.Join(cleanTmp, configFile)
-	cfg := env.LoadVersionConfig(configPath)
+	cfg, err := loadConfig(stage)
+	if err != nil {
+		return err
+	}
+	if cfg.Version == nil || cfg.Version.Src == "" {
+		return fmt.Errorf("%s has no version mapping", configFile)
+	}
+	if err := renderTemplates(cfg, stage, opts); err != nil {
+		return err
+	}
 
-	newVerFile := cfg.Version[0].Src
-	oldVerFile := cfg.Version[1].Dst
-	oldVer := env.ReadMimoVersion(oldVerFile)
-	newVer := env.ReadMimoVersion(newVerFile)
+	oldVer := env.ReadMimoVersion(cfg.Version.Dst)
+	newVer := env.ReadMimoVersion(cfg.Version.Src)
 
 	fmt.Printf("INFO: installed version: %s\n", oldVer)
 	fmt.Printf("INFO: new version      : %s\n", newVer)
+	rec.versions(oldVer, newVer)
 
-	if !env.ConfirmPrompt("Proceed with update? [y/N]: ") {
+	if err := checkVersions(oldVer, newVer, opts); err != nil {
+		return err
+	}
+
+	if !opts.DryRun && !opts.confirm("Proceed with update? [y/N]: ") {
 		fmt.Println("INFO: update cancelled")
-		return nil
+		return cancelled()
 	}
 
-	// Stop MIMO and save config
-	cleanSock := filepath.Clean(spdk.SPDKSock())
-	if _, err := os.Stat(cleanSock); err == nil {
+	txn, err := newTransaction(txnTarget, opts)
+	if err != nil {
+		return err
+	}
+	defer txn.Cleanup()
+	rec.track(txn)
+
+	running := spdk.IsRunning()
+	if running {
 		fmt.Println("INFO: detected running MIMO instance")
-		if env.ConfirmPrompt("Stop MIMO now? [y/N]: ") {
-			if err := spdk.SaveSpdkConfigAndGetCommand(); err != nil {
-				return fmt.Errorf("failed to save SPDK config: %w", err)
-			}
-			fmt.Println("INFO: MIMO stopped")
-		} else {
+		if !opts.DryRun && !opts.shouldStop() {
 			fmt.Println("INFO: please stop I/O before updating")
-			return nil
+			return cancelled()
 		}
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("failed to check socket: %w", err)
 	}
 
-	// Copy files according to mappings
-	fmt.Println("INFO: applying file mappings...")
-
-	fileOpsConfigPath := filepath.Join(cleanTmp, configFile)
-	fileOpsCfg := env.LoadFileOpsConfig(fileOpsConfigPath)
+	if err := registerHooks(txn, cfg, hooks.Pre, txnTarget, stage); err != nil {
+		return fmt.Errorf("setup hooks failed: %w", err)
+	}
 
-	for _, mapping := range fileOpsCfg.FileMappings {
-		srcPath := mapping.Src
-		dstPath := mapping.Dst
+	// Install the new version next to the running one
+	in, err := prepareInstall(txn, cfg, stage)
+	if err != nil {
+		return fmt.Errorf("setup file copy actions failed: %w", err)
+	}
 
-		fi, err := os.Stat(srcPath)
-		if err != nil {
-			return fmt.Errorf("source missing %s: %w", srcPath, err)
+	// Stop MIMO and save config, then switch over
+	if running {
+		if err := spdk.RegisterStopAction(txn); err != nil {
+			return fmt.Errorf("setup stop action failed: %w", err)
 		}
+	}
+	if err := in.finish(txn); err != nil {
+		return fmt.Errorf("setup switch actions failed: %w", err)
+	}
 
-		// remove old target
-		if err := os.RemoveAll(dstPath); err != nil {
-			return fmt.Errorf("failed to remove old target %s: %w", dstPath, err)
+	// Restart MIMO with saved config, rolling everything back if it does not come up
+	if running {
+		if err := spdk.RegisterRestartAction(txn, opts.startTimeout()); err != nil {
+			return fmt.Errorf("setup restart action failed: %w", err)
 		}
+	}
 
-		if fi.IsDir() {
-			if err := fileops.CopyDir(srcPath, dstPath); err != nil {
-				return fmt.Errorf("failed to copy directory %s -> %s: %w", srcPath, dstPath, err)
-			}
-		} else {
-			if err := fileops.CopyFile(srcPath, dstPath); err != nil {
-				return fmt.Errorf("failed to copy file %s -> %s: %w", srcPath, dstPath, err)
-			}
-		}
+	if err := registerHooks(txn, cfg, hooks.Post, txnTarget, stage); err != nil {
+		return fmt.Errorf("setup hooks failed: %w", err)
+	}
+
+	if opts.DryRun {
+		txn.Plan(os.Stdout)
+		fmt.Println("INFO: dry run, nothing was changed")
+		return nil
 	}
 
-	// Restart MIMO with saved config
-	if err := spdk.RestartSpdkWithSavedConfig(); err != nil {
-		return fmt.Errorf("failed to restart SPDK: %w", err)
+	fmt.Println("INFO: applying file mappings...")
+	if err := txn.Run(); err != nil {
+		return txnFailed(fmt.Errorf("target update failed: %w", err))
 	}
+	in.done()
 
+	fmt.Println("INFO: target update completed")
 	return nil
 }
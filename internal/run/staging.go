@@ -0,0 +1,100 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"mimo/internal/decompress"
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/manifest"
+	"mimo/internal/system"
+)
+
+// stagingMargin 解压所需空间之外预留的余量
+const stagingMargin = 64 << 20
+
+// newStaging 在 parent 下创建本次更新独占的暂存目录，并发的更新互不影响
+func newStaging(parent string) (string, error) {
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("create staging directory: %w", err)
+	}
+	dir, err := os.MkdirTemp(parent, "mimo-update-")
+	if err != nil {
+		return "", fmt.Errorf("create staging directory: %w", err)
+	}
+	return dir, nil
+}
+
+// checkSpace 确认 dir 所在文件系统能容纳资源包解压后的内容。
+// 资源包没有清单（不知道解压后大小）时只告警。
+func checkSpace(dir string, b *decompress.Bundle) error {
+	data, err := b.ReadFile(manifest.FileName)
+	if err != nil {
+		fmt.Println("WARN: bundle has no manifest, skipping free space check")
+		return nil
+	}
+	m, err := manifest.Parse(data)
+	if err != nil {
+		return fmt.Errorf("bundle manifest: %w", err)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		fmt.Printf("WARN: checking free space in %s failed: %v\n", dir, err)
+		return nil
+	}
+	free := int64(st.Bavail) * int64(st.Bsize)
+	need := m.UnpackedSize + stagingMargin
+	if free < need {
+		return fmt.Errorf("not enough space in %s: the bundle needs %s unpacked, %s available (choose another location with --staging-dir or %s)",
+			filepath.Dir(dir), fileops.FormatSize(need), fileops.FormatSize(free), EnvStagingDir)
+	}
+	return nil
+}
+
+// loadConfig 读取暂存目录中的 config.json（旧格式自动迁移），将 src 换算到暂存目录，
+// 并去掉条件不满足的映射
+func loadConfig(stage string) (*fileops.Config, error) {
+	cfg, err := fileops.LoadConfig(filepath.Join(stage, configFile))
+	if err != nil {
+		return nil, err
+	}
+	for i := range cfg.FileMappings {
+		if cfg.FileMappings[i].Src != "" {
+			cfg.FileMappings[i].Src = env.RebaseSrc(cfg.FileMappings[i].Src, stage)
+		}
+	}
+	if cfg.Version != nil {
+		cfg.Version.Src = env.RebaseSrc(cfg.Version.Src, stage)
+	}
+	if cfg.Packages != nil && cfg.Packages.Repo != "" {
+		cfg.Packages.Repo = env.RebaseSrc(cfg.Packages.Repo, stage)
+	}
+	if cfg.Hooks != nil {
+		for _, hs := range [][]fileops.Hook{cfg.Hooks.Pre, cfg.Hooks.Post} {
+			for i := range hs {
+				hs[i].Script = env.RebaseSrc(hs[i].Script, stage)
+				if hs[i].Undo != "" {
+					hs[i].Undo = env.RebaseSrc(hs[i].Undo, stage)
+				}
+			}
+		}
+	}
+
+	cfg, skipped := cfg.Applicable(currentHost())
+	for _, s := range skipped {
+		fmt.Printf("INFO: skipping %s: %s\n", s.Mapping.Dst, s.Reason)
+	}
+	return cfg, nil
+}
+
+// currentHost 返回映射条件所用的本机信息，读取 os-release 失败时为空
+func currentHost() fileops.Host {
+	var host fileops.Host
+	if rel, _, err := system.OSRelease(); err == nil {
+		host = fileops.Host{ID: rel["ID"], VersionID: rel["VERSION_ID"]}
+	}
+	return host
+}
@@ -0,0 +1,109 @@
+package run
+
+import (
+	"fmt"
+	"os"
+
+	"mimo/internal/env"
+	"mimo/internal/grub"
+	"mimo/internal/hugepages"
+	"mimo/internal/system"
+	"mimo/internal/transaction"
+)
+
+const (
+	txnCmdline   = "system-cmdline"
+	txnHugepages = "system-hugepages"
+	txnCloudInit = "system-cloud-init"
+)
+
+// systemChange 在事务中执行一次 mimo system 修改：register 注册动作，没有需要修改的内容时返回 false，
+// 此时以退出码 ExitNoOp 结束
+func systemChange(kind string, opts Options, register func(txn *transaction.Transaction) (bool, error)) (err error) {
+	env.MustBeRoot()
+	rec := newAudit(kind, opts)
+	defer func() { err = rec.finish(err) }()
+	if !opts.DryRun {
+		release, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if err := checkPending(); err != nil {
+		if !opts.DryRun {
+			return err
+		}
+		fmt.Printf("WARN: %v\n", err)
+	}
+
+	txn, err := newTransaction(kind, opts)
+	if err != nil {
+		return err
+	}
+	defer txn.Cleanup()
+	changed, err := register(txn)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		// 与其它无需更新的情况一致，历史记录不关联事务：事务没有执行，其日志随 Cleanup 删除
+		return &ExitError{Code: ExitNoOp, Err: fmt.Errorf("already configured, nothing to change")}
+	}
+	rec.track(txn)
+
+	if opts.DryRun {
+		txn.Plan(os.Stdout)
+		fmt.Println("INFO: dry run, nothing was changed")
+		return nil
+	}
+	if err := txn.Run(); err != nil {
+		return txnFailed(fmt.Errorf("%s failed: %w", kind, err))
+	}
+	return nil
+}
+
+// EditCmdline 在事务中修改内核命令行：set 中的参数更新已有的同名参数，没有时追加到变量 v；
+// unset 中的参数删除。其余参数保持不变，没有变化时不执行 update-grub。
+func EditCmdline(opts Options, set, unset []grub.Param, v string) error {
+	return systemChange(txnCmdline, opts, func(txn *transaction.Transaction) (bool, error) {
+		c, err := grub.CurrentCmdline()
+		if err != nil {
+			return false, err
+		}
+		for _, p := range unset {
+			c.Unset(p)
+		}
+		for _, p := range set {
+			c.Set(p, v)
+		}
+		return grub.RegisterCmdlineAction(txn, c), nil
+	})
+}
+
+// SetHugepages 在事务中配置大页：写入内核命令行使其在重启后保持，并立即通过 sysfs 调整数量
+func SetHugepages(opts Options, s hugepages.Setting) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return systemChange(txnHugepages, opts, func(txn *transaction.Transaction) (bool, error) {
+		c, err := grub.CurrentCmdline()
+		if err != nil {
+			return false, err
+		}
+		hugepages.SetCmdline(c, s)
+		cmdline := grub.RegisterCmdlineAction(txn, c)
+		applied, err := hugepages.RegisterApplyAction(txn, s)
+		if err != nil {
+			return false, err
+		}
+		return cmdline || applied, nil
+	})
+}
+
+// SetCloudInit 在事务中启用或禁用 cloud-init；失败时恢复每个单元原来的状态与标记文件
+func SetCloudInit(opts Options, enable bool) error {
+	return systemChange(txnCloudInit, opts, func(txn *transaction.Transaction) (bool, error) {
+		return system.RegisterCloudInitAction(txn, enable), nil
+	})
+}
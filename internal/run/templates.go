@@ -0,0 +1,94 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/manifest"
+	"mimo/internal/render"
+)
+
+// renderTemplates 在暂存目录中渲染标记为模板的映射，并将渲染后的哈希与大小写回暂存的清单，
+// 之后的同步与已安装清单都以渲染结果为准
+func renderTemplates(cfg *fileops.Config, stage string, opts Options) error {
+	var tmpls []fileops.FileMapping
+	for _, m := range cfg.FileMappings {
+		if m.Template {
+			tmpls = append(tmpls, m)
+		}
+	}
+	if len(tmpls) == 0 {
+		return nil
+	}
+
+	mPath := filepath.Join(stage, manifest.FileName)
+	m, err := manifest.Load(mPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err := templateData(cfg, m, opts)
+	if err != nil {
+		return err
+	}
+
+	var idx map[string]*manifest.File
+	if m != nil {
+		idx = m.ByTarget()
+	}
+	for _, t := range tmpls {
+		if err := render.File(t.Src, data); err != nil {
+			return fmt.Errorf("render %s: %w", t.Dst, err)
+		}
+		fmt.Printf("INFO: rendered template %s\n", t.Dst)
+		f := idx[filepath.Clean(t.Dst)]
+		if f == nil {
+			continue
+		}
+		sum, err := fileops.FileDigest(t.Src)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(t.Src)
+		if err != nil {
+			return err
+		}
+		f.SHA256, f.Size = sum, fi.Size()
+	}
+	if m == nil {
+		return nil
+	}
+	return m.Save(mPath)
+}
+
+// templateData 收集模板变量：新版本的 VERSION.json、主机名、资源包清单与用户变量
+// （config.json 的 values < render.ValuesPath < --set）
+func templateData(cfg *fileops.Config, m *manifest.Manifest, opts Options) (*render.Data, error) {
+	data := &render.Data{VersionInfo: map[string]any{}}
+	if cfg.Version != nil {
+		raw, err := os.ReadFile(cfg.Version.Src)
+		if err != nil {
+			return nil, fmt.Errorf("read version file: %w", err)
+		}
+		if data.VersionInfo, err = render.ParseVersionInfo(raw); err != nil {
+			return nil, err
+		}
+		data.Version = env.ParseMimoVersion(raw)
+	}
+	data.Hostname, _ = os.Hostname()
+	if m != nil {
+		data.Bundle = render.Bundle{Version: m.Version, Base: m.BaseVersion, Created: m.Created}
+		if data.Version == "" {
+			data.Version = m.Version
+		}
+	}
+
+	local, err := render.LoadValues(render.ValuesPath)
+	if err != nil {
+		return nil, err
+	}
+	data.Values = render.Merge(cfg.Values, local, opts.Values)
+	return data, nil
+}
@@ -0,0 +1,385 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mimo/internal/decompress"
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/grub"
+	"mimo/internal/manifest"
+	"mimo/internal/motd"
+	"mimo/internal/originals"
+	"mimo/internal/slots"
+	"mimo/internal/spdk"
+	"mimo/internal/system"
+	"mimo/internal/systemd"
+)
+
+// dataDir MIMO 在节点上的状态目录（历史、清单、原始文件、事务日志）
+const dataDir = "/var/lib/mimo"
+
+// uninstallReport 卸载结果：已恢复的路径、执行失败的问题，以及没有原始记录、无法恢复的内容。
+// 只有 problems 影响退出码；unrestorable 重新执行卸载也不会改变，只在报告中列出。
+type uninstallReport struct {
+	restored     []string
+	problems     []string
+	unrestorable []string
+}
+
+func (r *uninstallReport) ok(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Println("INFO: " + msg)
+	r.restored = append(r.restored, msg)
+}
+
+func (r *uninstallReport) fail(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Println("WARN: " + msg)
+	r.problems = append(r.problems, msg)
+}
+
+func (r *uninstallReport) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Println("WARN: " + msg)
+	r.unrestorable = append(r.unrestorable, msg)
+}
+
+// uninstallStep 卸载的一步；失败写入报告，不中断后续步骤
+type uninstallStep struct {
+	desc string
+	do   func(r *uninstallReport)
+}
+
+// uninstaller 卸载所需的信息
+type uninstaller struct {
+	cfg       *fileops.Config
+	installed *manifest.Manifest
+	store     originals.Store
+	layout    slots.Layout
+	keepData  bool
+}
+
+// Uninstall 撤销 MIMO 对系统的全部改动：停用并删除 MIMO 的服务，用安装前保存的原始文件恢复被覆盖的文件，
+// 删除 MIMO 安装的文件与版本目录，恢复 motd 与 cloud-init，重新生成 grub 与 initramfs。
+// keepData 为 true 时保留 /var/lib/mimo（更新历史、SPDK 配置备份等）。
+// 逐步执行，某一步失败不影响其它步骤，最后列出未能恢复的内容。
+func Uninstall(opts Options, keepData bool) error {
+	env.MustBeRoot()
+	if !opts.DryRun {
+		release, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if err := checkPending(); err != nil {
+		return err
+	}
+
+	u := &uninstaller{store: originals.Default(), layout: slots.Default(), keepData: keepData}
+	if data, err := decompress.Embedded().ReadFile(configFile); err == nil {
+		u.cfg, err = fileops.ParseConfig(data)
+		if err != nil {
+			fmt.Printf("WARN: ignoring embedded %s: %v\n", configFile, err)
+		}
+	}
+	if u.cfg == nil {
+		u.cfg = &fileops.Config{}
+	}
+	m, err := manifest.Load(manifest.InstalledPath)
+	switch {
+	case err == nil:
+		u.installed = m
+	case !os.IsNotExist(err):
+		fmt.Printf("WARN: ignoring installed manifest: %v\n", err)
+	}
+
+	steps := u.steps()
+	if opts.DryRun {
+		lines := make([]string, len(steps))
+		for i, s := range steps {
+			lines[i] = fmt.Sprintf("%2d. %s", i+1, s.desc)
+		}
+		printSection("uninstall", lines)
+		fmt.Println("INFO: dry run, nothing was changed")
+		return nil
+	}
+
+	if !opts.confirm("Remove MIMO and restore the original system configuration? [y/N]: ") {
+		fmt.Println("INFO: uninstall cancelled")
+		return cancelled()
+	}
+	if spdk.IsRunning() {
+		fmt.Println("INFO: detected running MIMO instance")
+		if !opts.shouldStop() {
+			fmt.Println("INFO: please stop I/O before uninstalling")
+			return cancelled()
+		}
+		if err := spdk.Stop(); err != nil {
+			return fmt.Errorf("stopping MIMO failed: %w", err)
+		}
+	}
+
+	r := &uninstallReport{}
+	for _, s := range steps {
+		s.do(r)
+	}
+
+	fmt.Printf("INFO: uninstall finished: %d item(s) restored or removed\n", len(r.restored))
+	if len(r.unrestorable) > 0 {
+		fmt.Println("WARN: the following had no original kept and were not restored:")
+		for _, p := range r.unrestorable {
+			fmt.Printf("  - %s\n", p)
+		}
+	}
+	if len(r.problems) == 0 {
+		return nil
+	}
+	fmt.Println("WARN: the following could not be restored:")
+	for _, p := range r.problems {
+		fmt.Printf("  - %s\n", p)
+	}
+	return fmt.Errorf("uninstall finished with %d problem(s)", len(r.problems))
+}
+
+func (u *uninstaller) steps() []uninstallStep {
+	steps := []uninstallStep{
+		{"stop and disable MIMO services", u.disableServices},
+		{"restore files kept in " + u.store.Dir, u.restoreOriginals},
+		{"remove remaining files installed by MIMO", u.removeInstalled},
+		{"remove " + u.layout.Link + " and " + u.layout.Dir, u.removeSlots},
+		{"restore " + motd.BackupDir + " into /etc/update-motd.d", u.restoreMotd},
+		{"re-enable cloud-init", u.enableCloudInit},
+		{"remove " + env.ProfilePath, u.removeProfile},
+		{"reload systemd, run update-grub and update-initramfs -u", u.rebuild},
+	}
+	if u.keepData {
+		steps = append(steps, uninstallStep{"keep " + dataDir, func(r *uninstallReport) {
+			fmt.Printf("INFO: keeping %s\n", dataDir)
+		}})
+	} else {
+		steps = append(steps, uninstallStep{"remove " + dataDir + " (kept if a step above fails)", u.removeData})
+	}
+	return steps
+}
+
+func (u *uninstaller) disableServices(r *uninstallReport) {
+	if err := systemd.DisableServices(u.cfg); err != nil {
+		r.fail("services: %v", err)
+	}
+}
+
+// restoreOriginals 恢复保存过原始状态的每个路径；grub 文件没有原始记录时单独报告
+func (u *uninstaller) restoreOriginals(r *uninstallReport) {
+	paths, err := u.store.List()
+	if err != nil {
+		r.fail("%s: %v", u.store.Dir, err)
+		return
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		existed, _ := u.store.Existed(p)
+		if err := u.store.Restore(p); err != nil {
+			r.fail("%s: %v", p, err)
+			continue
+		}
+		if existed {
+			r.ok("restored %s", p)
+		} else {
+			r.ok("removed %s (did not exist before MIMO)", p)
+		}
+	}
+
+	if !u.store.Has(grub.GrubFile) {
+		r.warn("%s: no original was kept, the MIMO kernel command line is left in place", grub.GrubFile)
+	}
+	if !u.store.Has(grub.InitScript) {
+		data, err := os.ReadFile(grub.InitScript)
+		switch {
+		case os.IsNotExist(err):
+		case err == nil && grub.IsMimoInitScript(data):
+			if err := os.Remove(grub.InitScript); err != nil {
+				r.fail("%s: %v", grub.InitScript, err)
+			} else {
+				r.ok("removed %s", grub.InitScript)
+			}
+		default:
+			r.warn("%s: no original was kept and the file is not MIMO's, left in place", grub.InitScript)
+		}
+	}
+}
+
+// removeInstalled 删除清单中仍然存在的文件：它们没有原始记录（位于安装前已存在的目录中，
+// 或是在开始保存原始文件之前安装的），随后删除映射目录下变空的子目录
+func (u *uninstaller) removeInstalled(r *uninstallReport) {
+	if u.installed == nil {
+		fmt.Println("INFO: no installed manifest, nothing else to remove")
+		return
+	}
+	counts := map[string]int{}
+	for _, f := range u.installed.Files {
+		if f.Target == "" || within(f.Target, u.layout.Link) {
+			continue
+		}
+		// 配置文件被保留时旁边的新版本
+		if err := os.Remove(f.Target + fileops.NewSuffix); err == nil {
+			r.ok("removed %s", f.Target+fileops.NewSuffix)
+		}
+		if u.kept(f.Target) {
+			continue
+		}
+		if _, err := os.Lstat(f.Target); err != nil {
+			continue
+		}
+		if err := os.Remove(f.Target); err != nil {
+			r.fail("%s: %v", f.Target, err)
+			continue
+		}
+		root, ok := u.mappingRoot(f.Target)
+		if !ok {
+			r.warn("%s: no original was kept, removed the MIMO version", f.Target)
+			continue
+		}
+		counts[root]++
+		pruneEmpty(filepath.Dir(f.Target), root)
+	}
+	roots := make([]string, 0, len(counts))
+	for root := range counts {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	for _, root := range roots {
+		r.ok("removed %d file(s) installed by MIMO under %s", counts[root], root)
+		r.warn("%s existed before MIMO; files MIMO overwrote there were not kept", root)
+	}
+}
+
+// kept 报告 p 或其上级目录是否有原始记录（已由 restoreOriginals 处理）
+func (u *uninstaller) kept(p string) bool {
+	for {
+		if u.store.Has(p) {
+			return true
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return false
+		}
+		p = parent
+	}
+}
+
+// mappingRoot 返回 target 所属目录映射的目标目录；target 本身是映射目标（单个文件）时 ok 为 false
+func (u *uninstaller) mappingRoot(target string) (string, bool) {
+	best := ""
+	for _, m := range u.cfg.FileMappings {
+		dst := filepath.Clean(m.Dst)
+		if dst == target {
+			return "", false
+		}
+		if within(target, dst) && len(dst) > len(best) {
+			best = dst
+		}
+	}
+	if best == "" {
+		return filepath.Dir(target), true
+	}
+	return best, true
+}
+
+// pruneEmpty 自 dir 向上删除空目录，不包括 root 本身
+func pruneEmpty(dir, root string) {
+	for dir != root && within(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (u *uninstaller) removeSlots(r *uninstallReport) {
+	fi, err := os.Lstat(u.layout.Link)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		r.fail("%s: %v", u.layout.Link, err)
+	case fi.Mode()&os.ModeSymlink != 0 || !u.store.Has(u.layout.Link):
+		// 符号链接或旧版本的安装目录都属于 MIMO
+		if err := os.RemoveAll(u.layout.Link); err != nil {
+			r.fail("%s: %v", u.layout.Link, err)
+		} else {
+			r.ok("removed %s", u.layout.Link)
+		}
+	}
+	if _, err := os.Lstat(u.layout.Dir); err == nil {
+		if err := os.RemoveAll(u.layout.Dir); err != nil {
+			r.fail("%s: %v", u.layout.Dir, err)
+		} else {
+			r.ok("removed %s", u.layout.Dir)
+		}
+	}
+}
+
+func (u *uninstaller) restoreMotd(r *uninstallReport) {
+	if _, err := os.Stat(motd.BackupDir); os.IsNotExist(err) {
+		return
+	}
+	if err := motd.Restore(); err != nil {
+		r.fail("motd: %v", err)
+		return
+	}
+	r.ok("restored /etc/update-motd.d")
+}
+
+func (u *uninstaller) enableCloudInit(r *uninstallReport) {
+	if err := system.EnableCloudInit(); err != nil {
+		r.fail("cloud-init: %v", err)
+		return
+	}
+	r.ok("re-enabled cloud-init")
+}
+
+func (u *uninstaller) removeProfile(r *uninstallReport) {
+	if err := os.Remove(env.ProfilePath); err == nil {
+		r.ok("removed %s", env.ProfilePath)
+	} else if !os.IsNotExist(err) {
+		r.fail("%s: %v", env.ProfilePath, err)
+	}
+}
+
+func (u *uninstaller) rebuild(r *uninstallReport) {
+	if err := systemd.DaemonReload(); err != nil {
+		r.fail("systemd: %v", err)
+	}
+	fmt.Println("INFO: rebuilding grub config and initramfs...")
+	if err := grub.Rebuild(); err != nil {
+		r.fail("%v", err)
+		return
+	}
+	r.ok("rebuilt grub config and initramfs")
+}
+
+// removeData 删除 /var/lib/mimo；前面的步骤执行失败时保留该目录，其中的原始文件是重试卸载或手工恢复所需的唯一副本。
+// 没有原始记录、无法恢复的内容不影响删除
+func (u *uninstaller) removeData(r *uninstallReport) {
+	if len(r.problems) > 0 {
+		r.fail("%s kept because of the failures above: it holds the originals in %s; fix them and run 'mimo uninstall' again, or remove it by hand",
+			dataDir, u.store.Dir)
+		return
+	}
+	if err := os.RemoveAll(dataDir); err != nil {
+		r.fail("%s: %v", dataDir, err)
+		return
+	}
+	r.ok("removed %s", dataDir)
+}
+
+// within 报告 p 是否为 dir 或位于其下
+func within(p, dir string) bool {
+	p, dir = filepath.Clean(p), filepath.Clean(dir)
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,232 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mimo/internal/decompress"
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/manifest"
+)
+
+const txnRepair = "verify-repair"
+
+// Verify 将每个已安装文件与已安装清单比较（内容、权限、属主），并列出映射目录中清单之外的文件。
+// 映射取自 --bundle 指定或内嵌资源包的 config.json。
+func Verify(opts Options) ([]fileops.Drift, error) {
+	b, err := loadBundle(opts)
+	if err != nil {
+		return nil, err
+	}
+	return verify(b)
+}
+
+func verify(b *decompress.Bundle) ([]fileops.Drift, error) {
+	installed, err := manifest.Load(manifest.InstalledPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no installed manifest at %s; run 'mimo update --sys' first", manifest.InstalledPath)
+		}
+		return nil, err
+	}
+	cfg, err := bundleConfig(b)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("INFO: verifying %d installed files of %s...\n", len(installed.Files), installed.Version)
+	return fileops.Verify(cfg, installed)
+}
+
+// bundleConfig 不解压资源包，直接读取其 config.json，并去掉条件不满足的映射
+func bundleConfig(b *decompress.Bundle) (*fileops.Config, error) {
+	data, err := b.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("read bundle %s: %w", configFile, err)
+	}
+	cfg, err := fileops.ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _ = cfg.Applicable(currentHost())
+	return cfg, nil
+}
+
+// PrintDrift 逐行输出差异
+func PrintDrift(drifts []fileops.Drift) {
+	for _, d := range drifts {
+		line := fmt.Sprintf("%-8s  %s", d.Kind, d.Path)
+		switch {
+		case d.Kind == fileops.DriftEdited:
+			line += " (config file edited locally, kept on update)"
+		case d.Detail != "":
+			line += " (" + d.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+}
+
+// CountDrift 返回每种差异的数量摘要，如 "2 modified, 1 missing, 0 extra"
+func CountDrift(drifts []fileops.Drift) string {
+	n := map[string]int{}
+	for _, d := range drifts {
+		n[d.Kind]++
+	}
+	s := fmt.Sprintf("%d modified, %d missing, %d extra", n[fileops.DriftModified], n[fileops.DriftMissing], n[fileops.DriftExtra])
+	if n[fileops.DriftEdited] > 0 {
+		s += fmt.Sprintf(", %d edited config", n[fileops.DriftEdited])
+	}
+	return s
+}
+
+// Repair 在事务中恢复被修改与缺失的已安装文件：内容不同的文件从资源包中复制，
+// 只有权限或属主不同的文件重设属性。资源包中的文件必须与已安装的版本一致
+// （模板按当前变量重新渲染，已安装清单随之更新）。多出的文件与本地修改过的配置文件保持不变。
+func Repair(opts Options) (err error) {
+	env.MustBeRoot()
+	rec := newAudit(txnRepair, opts)
+	defer func() { err = rec.finish(err) }()
+	if !opts.DryRun {
+		release, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if err := checkPending(); err != nil {
+		if !opts.DryRun {
+			return err
+		}
+		fmt.Printf("WARN: %v\n", err)
+	}
+
+	b, err := verifiedBundle(opts, rec)
+	if err != nil {
+		return err
+	}
+	drifts, err := verify(b)
+	if err != nil {
+		return err
+	}
+	PrintDrift(drifts)
+	var fix []fileops.Drift
+	for _, d := range drifts {
+		if d.Repairable() {
+			fix = append(fix, d)
+		}
+	}
+	if len(fix) == 0 {
+		return &ExitError{Code: ExitNoOp, Err: fmt.Errorf("nothing to repair")}
+	}
+
+	stage, err := newStaging(opts.stagingDir())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(stage)
+	}()
+	fmt.Println("INFO: extracting package...")
+	if err := extract(b, stage); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(stage)
+	if err != nil {
+		return err
+	}
+	if err := renderTemplates(cfg, stage, opts); err != nil {
+		return err
+	}
+	installed, err := manifest.Load(manifest.InstalledPath)
+	if err != nil {
+		return err
+	}
+	rec.versions(installed.Version, installed.Version)
+	record, err := matchBundle(fix, stage, installed)
+	if err != nil {
+		return err
+	}
+
+	if !opts.DryRun && !opts.confirm(fmt.Sprintf("Repair %d file(s)? [y/N]: ", len(fix))) {
+		fmt.Println("INFO: repair cancelled")
+		return cancelled()
+	}
+
+	txn, err := newTransaction(txnRepair, opts)
+	if err != nil {
+		return err
+	}
+	defer txn.Cleanup()
+	rec.track(txn)
+
+	if err := fileops.RegisterRepairAction(txn, stage, fix); err != nil {
+		return err
+	}
+	if record != nil {
+		if err := fileops.RegisterManifestAction(txn, manifest.InstalledPath, record); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		txn.Plan(os.Stdout)
+		fmt.Println("INFO: dry run, nothing was changed")
+		return nil
+	}
+	if err := txn.Run(); err != nil {
+		return txnFailed(fmt.Errorf("repair failed: %w", err))
+	}
+	fmt.Printf("INFO: repaired %d file(s)\n", len(fix))
+	return nil
+}
+
+// matchBundle 确认解压的资源包含有每个待恢复文件的已安装版本，并将 fix 中的文件记录换成资源包中的记录。
+// 重新渲染的模板与已安装的记录不同时，返回需要保存的新已安装清单，否则返回 nil。
+func matchBundle(fix []fileops.Drift, stage string, installed *manifest.Manifest) (*manifest.Manifest, error) {
+	staged, err := manifest.Load(filepath.Join(stage, manifest.FileName))
+	if err != nil {
+		return nil, fmt.Errorf("bundle manifest: %w", err)
+	}
+	idx := staged.ByTarget()
+	var bad []string
+	rendered := map[string]*manifest.File{}
+	for i := range fix {
+		d := &fix[i]
+		if d.Attrs {
+			continue
+		}
+		f := idx[d.Path]
+		switch {
+		case f == nil:
+			bad = append(bad, d.Path+": not in the bundle")
+			continue
+		case f.Unchanged:
+			bad = append(bad, d.Path+": not carried by the delta bundle")
+			continue
+		case f.SHA256 != d.File.SHA256 && !f.Template:
+			bad = append(bad, d.Path+": the bundle has a different version")
+			continue
+		case f.SHA256 != d.File.SHA256:
+			rendered[d.Path] = f
+		}
+		d.File = f
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("the bundle (version %s) cannot restore installed version %s; use --bundle with the installed bundle:\n  %s",
+			staged.Version, installed.Version, strings.Join(bad, "\n  "))
+	}
+	if len(rendered) == 0 {
+		return nil, nil
+	}
+	out := *installed
+	out.Files = append([]manifest.File{}, installed.Files...)
+	for i, f := range out.Files {
+		if r := rendered[f.Target]; r != nil {
+			fmt.Printf("WARN: %s renders differently with the current values, updating the installed record\n", f.Target)
+			out.Files[i].SHA256, out.Files[i].Size = r.SHA256, r.Size
+		}
+	}
+	return &out, nil
+}
@@ -0,0 +1,80 @@
+package run
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"mimo/internal/decompress"
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/version"
+)
+
+const versionFile = "VERSION.json"
+
+// Versions 汇总 CLI 构建版本、内嵌资源包版本与已安装版本
+type Versions struct {
+	CLI       string `json:"cli"`
+	Bundle    string `json:"bundle"`
+	Installed string `json:"installed"`
+}
+
+// CurrentVersions 读取各版本，不解压资源包；b 为 nil 时使用内嵌资源包
+func CurrentVersions(b *decompress.Bundle) Versions {
+	if b == nil {
+		b = decompress.Embedded()
+	}
+	v := Versions{
+		CLI:       version.BuildVersion,
+		Bundle:    "unknown",
+		Installed: env.ReadMimoVersion(filepath.Join(env.MimoRoot(), versionFile)),
+	}
+	data, err := b.ReadFile(configFile)
+	if err != nil {
+		return v
+	}
+	cfg, err := fileops.ParseConfig(data)
+	if err != nil || cfg.Version == nil {
+		return v
+	}
+	// config.json 中的路径指向解压目录，换算为包内路径
+	src := strings.TrimPrefix(filepath.Clean(cfg.Version.Src), env.StagingRoot+"/")
+	if b, err := b.ReadFile(src); err == nil {
+		v.Bundle = env.ParseMimoVersion(b)
+	}
+	if cfg.Version.Dst != "" {
+		v.Installed = env.ReadMimoVersion(cfg.Version.Dst)
+	}
+	return v
+}
+
+// checkVersions 比较已安装版本与新版本，拒绝降级或重复安装（除非显式允许）
+func checkVersions(installed, next string, opts Options) error {
+	newV, err := version.Parse(next)
+	if err != nil {
+		if opts.Force {
+			fmt.Printf("WARN: %v; continuing because of --force\n", err)
+			return nil
+		}
+		return &ExitError{Code: ExitFailed, Err: fmt.Errorf("bundle version: %w (use --force to install anyway)", err)}
+	}
+	oldV, err := version.Parse(installed)
+	if err != nil {
+		fmt.Printf("WARN: installed version: %v; skipping version check\n", err)
+		return nil
+	}
+
+	switch c := version.Compare(newV, oldV); {
+	case c == 0 && !opts.Force:
+		fmt.Printf("INFO: version %s is already installed (use --force to reinstall)\n", oldV)
+		return &ExitError{Code: ExitNoOp}
+	case c < 0 && !opts.Force && !opts.AllowDowngrade:
+		return &ExitError{Code: ExitFailed, Err: fmt.Errorf("refusing to downgrade from %s to %s (use --allow-downgrade)", oldV, newV)}
+	case c < 0:
+		fmt.Printf("WARN: downgrading from %s to %s\n", oldV, newV)
+	case c == 0:
+		fmt.Printf("INFO: reinstalling %s\n", oldV)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"mimo/internal/env"
+	"mimo/internal/fileops"
+	"mimo/internal/manifest"
+	"mimo/internal/slots"
+	"mimo/internal/spdk"
+)
+
+const txnSwitch = "versions-switch"
+
+// SwitchVersion 将 /usr/local/mimo 切换到已安装的版本 v；MIMO 正在运行时
+// 按 opts 停止后切换并用保存的配置重启，启动失败则切回原版本
+func SwitchVersion(v string, opts Options) (err error) {
+	env.MustBeRoot()
+	rec := newAudit(txnSwitch, opts)
+	defer func() { err = rec.finish(err) }()
+	if !opts.DryRun {
+		release, err := acquireLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if err := checkPending(); err != nil {
+		return err
+	}
+
+	l := slots.Default()
+	if l.IsLegacy() {
+		return fmt.Errorf("%s is not managed by version slots yet; run 'mimo update --target' once first", l.Link)
+	}
+	slot, err := l.Find(v)
+	if err != nil {
+		return err
+	}
+	if slot.Active {
+		fmt.Printf("INFO: %s is already active\n", slot.Version)
+		return &ExitError{Code: ExitNoOp}
+	}
+	fmt.Printf("INFO: switching %s: %s -> %s\n", l.Link, l.Active(), slot.Path)
+	rec.versions(env.ReadMimoVersion(filepath.Join(l.Link, versionFile)), slot.Version)
+
+	if !opts.DryRun && !opts.confirm("Proceed with switch? [y/N]: ") {
+		fmt.Println("INFO: switch cancelled")
+		return cancelled()
+	}
+
+	txn, err := newTransaction(txnSwitch, opts)
+	if err != nil {
+		return err
+	}
+	defer txn.Cleanup()
+	rec.track(txn)
+
+	running := spdk.IsRunning()
+	if running {
+		fmt.Println("INFO: detected running MIMO instance")
+		if !opts.DryRun && !opts.shouldStop() {
+			fmt.Println("INFO: please stop I/O before switching")
+			return cancelled()
+		}
+		if err := spdk.RegisterStopAction(txn); err != nil {
+			return fmt.Errorf("setup stop action failed: %w", err)
+		}
+	}
+	if err := slots.RegisterSwitchAction(txn, l, slot.Path); err != nil {
+		return err
+	}
+
+	// 已安装清单中 /usr/local/mimo 部分换成目标版本的记录
+	installed, _ := manifest.Load(manifest.InstalledPath)
+	if m := l.SwitchedManifest(slot.Path, installed); m != nil {
+		err = fileops.RegisterManifestAction(txn, manifest.InstalledPath, m)
+	} else if exists(manifest.InstalledPath) {
+		err = fileops.RegisterManifestAction(txn, manifest.InstalledPath, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if running {
+		if err := spdk.RegisterRestartAction(txn, opts.startTimeout()); err != nil {
+			return fmt.Errorf("setup restart action failed: %w", err)
+		}
+	}
+
+	if opts.DryRun {
+		txn.Plan(os.Stdout)
+		fmt.Println("INFO: dry run, nothing was changed")
+		return nil
+	}
+	if err := txn.Run(); err != nil {
+		return txnFailed(fmt.Errorf("switching version failed: %w", err))
+	}
+	fmt.Printf("INFO: %s is now active\n", slot.Version)
+	return nil
+}
+
+// PruneVersions 删除超出 keep 个的旧版本（当前版本总是保留）
+func PruneVersions(keep int, dryRun bool) error {
+	env.MustBeRoot()
+	release, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	l := slots.Default()
+	if dryRun {
+		stale, err := l.Stale(keep)
+		if err != nil {
+			return err
+		}
+		for _, s := range stale {
+			fmt.Printf("PLAN: remove %s (%s)\n", s.Path, s.Version)
+		}
+		fmt.Println("INFO: dry run, nothing was changed")
+		return nil
+	}
+	removed, err := l.Prune(keep)
+	for _, p := range removed {
+		fmt.Printf("INFO: removed %s\n", p)
+	}
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		fmt.Println("INFO: nothing to prune")
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package signature signs and verifies update bundles with ed25519 keys.
+//
+// The signed message binds the SHA-256 of the bundle archive to a fixed
+// context string, so a signature can't be replayed for other data.
+// Signature file (one line):   ed25519 <key-id> <base64 signature>
+// Public key file (one line):  ed25519 <base64 public key>
+// Private key file (one line): ed25519-private <base64 seed>
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultTrustedDir 节点上受信任公钥的默认目录
+const DefaultTrustedDir = "/etc/mimo/trusted-keys"
+
+const (
+	algo        = "ed25519"
+	privateAlgo = "ed25519-private"
+	context     = "mimo-bundle-v1\n"
+)
+
+// ErrUnsigned 表示资源包没有签名
+var ErrUnsigned = errors.New("bundle is not signed")
+
+// TrustedKey 受信任的公钥及其来源文件
+type TrustedKey struct {
+	ID   string
+	Key  ed25519.PublicKey
+	File string
+}
+
+// KeyID 返回公钥的短标识（SHA-256 前 8 字节）
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func message(digest string) []byte {
+	return []byte(context + strings.ToLower(strings.TrimSpace(digest)))
+}
+
+// GenerateKey 生成新的签名密钥对，返回公钥与私钥文件内容
+func GenerateKey() (pubFile, privFile []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubFile = []byte(fmt.Sprintf("%s %s\n", algo, base64.StdEncoding.EncodeToString(pub)))
+	privFile = []byte(fmt.Sprintf("%s %s\n", privateAlgo, base64.StdEncoding.EncodeToString(priv.Seed())))
+	return pubFile, privFile, nil
+}
+
+// ParsePrivateKey 解析私钥文件内容
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] != privateAlgo {
+		return nil, fmt.Errorf("not an %s key file", privateAlgo)
+	}
+	seed, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("malformed private key")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParsePublicKey 解析公钥文件内容
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] != algo {
+		return nil, fmt.Errorf("not an %s public key file", algo)
+	}
+	pub, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("malformed public key")
+	}
+	return ed25519.PublicKey(pub), nil
+}
+
+// Sign 对资源包摘要签名，返回签名文件内容
+func Sign(priv ed25519.PrivateKey, digest string) []byte {
+	sig := ed25519.Sign(priv, message(digest))
+	pub := priv.Public().(ed25519.PublicKey)
+	return []byte(fmt.Sprintf("%s %s %s\n", algo, KeyID(pub), base64.StdEncoding.EncodeToString(sig)))
+}
+
+// LoadTrustedKeys 读取目录中所有公钥文件；无法解析的文件返回错误
+func LoadTrustedKeys(dir string) ([]TrustedKey, error) {
+	dir = filepath.Clean(dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read trusted keys: %w", err)
+	}
+	var keys []TrustedKey
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", p, err)
+		}
+		pub, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		keys = append(keys, TrustedKey{ID: KeyID(pub), Key: pub, File: p})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].File < keys[j].File })
+	return keys, nil
+}
+
+// Verify 检查 sig 是否为 keys 中某个公钥对 digest 的有效签名，返回签名公钥
+func Verify(keys []TrustedKey, digest string, sig []byte) (*TrustedKey, error) {
+	sig = bytes.TrimSpace(sig)
+	if len(sig) == 0 {
+		return nil, ErrUnsigned
+	}
+	fields := strings.Fields(string(sig))
+	if len(fields) != 3 || fields[0] != algo {
+		return nil, fmt.Errorf("malformed bundle signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("malformed bundle signature")
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("bundle signed by key %s, but no trusted keys are installed", fields[1])
+	}
+	msg := message(digest)
+	for i := range keys {
+		if ed25519.Verify(keys[i].Key, msg, raw) {
+			return &keys[i], nil
+		}
+	}
+	for _, k := range keys {
+		if k.ID == fields[1] {
+			// the key is trusted but the signature doesn't match: tampered archive
+			return nil, fmt.Errorf("bundle signature does not match its content (key %s)", fields[1])
+		}
+	}
+	return nil, fmt.Errorf("bundle signed by untrusted key %s", fields[1])
+}
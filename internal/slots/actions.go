@@ -0,0 +1,184 @@
+package slots
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"mimo/internal/fileops"
+	"mimo/internal/transaction"
+)
+
+const (
+	undoMigrateKind = "slots.migrate"
+	undoSeedKind    = "slots.seed"
+	undoSwitchKind  = "slots.switch"
+)
+
+// linkState 是迁移与切换动作的撤销数据
+type linkState struct {
+	Link string `json:"link"`
+	Slot string `json:"slot"`
+	// Previous 切换前链接的目标，空表示切换前链接不存在
+	Previous string `json:"previous,omitempty"`
+}
+
+func init() {
+	transaction.RegisterUndo(undoMigrateKind, func(raw json.RawMessage) error {
+		var st linkState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return unmigrate(&st)
+	})
+	transaction.RegisterUndo(undoSeedKind, func(raw json.RawMessage) error {
+		var snap fileops.Snapshot
+		if err := json.Unmarshal(raw, &snap); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return snap.Restore()
+	})
+	transaction.RegisterUndo(undoSwitchKind, func(raw json.RawMessage) error {
+		var st linkState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return switchBack(&st)
+	})
+}
+
+// RegisterMigrateAction 将旧式安装目录（链接位置上的普通目录）移入版本 v 的槽位，
+// 并在原位置创建指向它的符号链接。
+func RegisterMigrateAction(txn *transaction.Transaction, l Layout, v string) error {
+	slot, err := l.SlotPath(v)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(slot); err == nil {
+		return fmt.Errorf("cannot migrate %s: %s already exists", l.Link, slot)
+	}
+	st := &linkState{Link: l.Link, Slot: slot}
+	txn.Add(&transaction.Action{
+		Name: fmt.Sprintf("move %s into slot %s", l.Link, slot),
+		Kind: undoMigrateKind,
+		State: func() (any, error) {
+			return st, nil
+		},
+		Describe: func() []string {
+			return []string{fmt.Sprintf("%s becomes a symlink to %s", l.Link, slot)}
+		},
+		Do: func() error {
+			if err := os.MkdirAll(l.Dir, 0755); err != nil {
+				return err
+			}
+			if err := move(l.Link, slot); err != nil {
+				return err
+			}
+			return l.Switch(slot)
+		},
+		Undo: func() error {
+			return unmigrate(st)
+		},
+	})
+	return nil
+}
+
+func unmigrate(st *linkState) error {
+	if fi, err := os.Lstat(st.Link); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(st.Link); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Lstat(st.Slot); err != nil {
+		// the move never happened
+		return nil
+	}
+	return move(st.Slot, st.Link)
+}
+
+// move renames src to dst, copying across filesystems
+func move(src, dst string) error {
+	if err := os.Rename(src, dst); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("move %s -> %s: %w", src, dst, err)
+		}
+		if err := fileops.CopyTree(src, dst); err != nil {
+			return err
+		}
+		return os.RemoveAll(src)
+	}
+	return nil
+}
+
+// RegisterSeedAction 以当前版本为基础创建新槽位：目录结构重建、文件以硬链接共享，
+// 随后的同步动作只替换有变化的文件。已有的同名槽位先移到一旁，撤销时恢复。
+func RegisterSeedAction(txn *transaction.Transaction, l Layout, slot string) error {
+	stateDir, err := txn.StateDir()
+	if err != nil {
+		return err
+	}
+	snap := fileops.NewSnapshot(slot, filepath.Join(stateDir, "snapshots"))
+	txn.Add(&transaction.Action{
+		Name: fmt.Sprintf("prepare slot %s from %s", slot, l.Link),
+		Kind: undoSeedKind,
+		State: func() (any, error) {
+			return snap, nil
+		},
+		Describe: func() []string {
+			out := []string{"unchanged files are hard links to the current version"}
+			if _, err := os.Lstat(slot); err == nil {
+				out = append(out, "replaces the existing inactive slot")
+			}
+			return out
+		},
+		Do: func() error {
+			if err := snap.Take(); err != nil {
+				return fmt.Errorf("snapshot %s: %w", slot, err)
+			}
+			return fileops.LinkTree(l.Link, slot)
+		},
+		Undo: snap.Restore,
+	})
+	return nil
+}
+
+// RegisterSwitchAction 将链接原子地切换到 slot，撤销时切回原目标
+func RegisterSwitchAction(txn *transaction.Transaction, l Layout, slot string) error {
+	st := &linkState{Link: l.Link, Slot: slot}
+	txn.Add(&transaction.Action{
+		Name: fmt.Sprintf("switch %s -> %s", l.Link, slot),
+		Kind: undoSwitchKind,
+		State: func() (any, error) {
+			// 在执行时读取，之前的动作可能刚把目录迁移为链接
+			st.Previous = l.Active()
+			return st, nil
+		},
+		Describe: func() []string {
+			if cur := l.Active(); cur != "" {
+				return []string{fmt.Sprintf("%s -> %s (was %s)", l.Link, slot, cur)}
+			}
+			return []string{fmt.Sprintf("%s -> %s", l.Link, slot)}
+		},
+		Do: func() error {
+			return l.Switch(slot)
+		},
+		Undo: func() error {
+			return switchBack(st)
+		},
+	})
+	return nil
+}
+
+func switchBack(st *linkState) error {
+	l := Layout{Link: st.Link}
+	if st.Previous == "" {
+		if l.Active() == st.Slot {
+			return os.Remove(st.Link)
+		}
+		return nil
+	}
+	return l.Switch(st.Previous)
+}
@@ -0,0 +1,224 @@
+// Package slots keeps each installed MIMO version in its own directory under
+// /usr/local/mimo-versions and points /usr/local/mimo at the active one with
+// a symlink, so switching versions is a single atomic rename.
+package slots
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mimo/internal/env"
+	"mimo/internal/manifest"
+	"mimo/internal/version"
+)
+
+const (
+	// DefaultLink 指向当前版本的符号链接
+	DefaultLink = "/usr/local/mimo"
+	// DefaultDir 各版本的安装目录
+	DefaultDir = "/usr/local/mimo-versions"
+	// DefaultKeep 更新后保留的版本数（含当前版本）
+	DefaultKeep = 3
+
+	versionFile  = "VERSION.json"
+	manifestsDir = ".manifests"
+)
+
+// validName 版本号用作目录名，只允许安全字符
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// Slot 一个已安装的版本
+type Slot struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Active  bool   `json:"active"`
+}
+
+// Layout 槽位目录与符号链接的位置
+type Layout struct {
+	Link string
+	Dir  string
+}
+
+// Default 返回默认布局
+func Default() Layout {
+	return Layout{Link: DefaultLink, Dir: DefaultDir}
+}
+
+// SlotPath 返回版本 v 的槽位目录
+func (l Layout) SlotPath(v string) (string, error) {
+	if !validName.MatchString(v) {
+		return "", fmt.Errorf("invalid version %q for an install slot", v)
+	}
+	return filepath.Join(l.Dir, v), nil
+}
+
+// IsLegacy 链接位置是普通目录（尚未使用槽位的安装）
+func (l Layout) IsLegacy() bool {
+	fi, err := os.Lstat(l.Link)
+	return err == nil && fi.IsDir()
+}
+
+// Active 返回当前槽位目录；链接不存在或不是符号链接时返回空串
+func (l Layout) Active() string {
+	target, err := os.Readlink(l.Link)
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(l.Link), target)
+	}
+	return filepath.Clean(target)
+}
+
+// List 返回所有槽位，按版本从新到旧排序
+func (l Layout) List() ([]Slot, error) {
+	entries, err := os.ReadDir(l.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	active := l.Active()
+	var out []Slot
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		p := filepath.Join(l.Dir, e.Name())
+		v := e.Name()
+		if data, err := os.ReadFile(filepath.Join(p, versionFile)); err == nil {
+			v = env.ParseMimoVersion(data)
+		}
+		out = append(out, Slot{Version: v, Path: p, Active: p == active})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return newer(filepath.Base(out[i].Path), filepath.Base(out[j].Path))
+	})
+	return out, nil
+}
+
+// Find 按版本号（目录名）查找槽位
+func (l Layout) Find(v string) (*Slot, error) {
+	list, err := l.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if filepath.Base(list[i].Path) == v || list[i].Version == v {
+			return &list[i], nil
+		}
+	}
+	return nil, fmt.Errorf("version %s is not installed in %s", v, l.Dir)
+}
+
+// newer 按语义化版本比较目录名，无法解析的按字符串比较
+func newer(a, b string) bool {
+	va, errA := version.Parse(a)
+	vb, errB := version.Parse(b)
+	if errA == nil && errB == nil {
+		if c := version.Compare(va, vb); c != 0 {
+			return c > 0
+		}
+	}
+	return a > b
+}
+
+// Switch 原子地将链接指向 target：先创建临时链接再 rename 覆盖
+func (l Layout) Switch(target string) error {
+	tmp := fmt.Sprintf("%s.tmp-%d", l.Link, os.Getpid())
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return fmt.Errorf("create link: %w", err)
+	}
+	if err := os.Rename(tmp, l.Link); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("switch %s -> %s: %w", l.Link, target, err)
+	}
+	if d, err := os.Open(filepath.Dir(l.Link)); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Stale 返回超出 keep 个（含当前版本）的最旧槽位，当前槽位总是保留
+func (l Layout) Stale(keep int) ([]Slot, error) {
+	if keep < 1 {
+		keep = 1
+	}
+	list, err := l.List()
+	if err != nil {
+		return nil, err
+	}
+	var out []Slot
+	kept := 0
+	for _, s := range list {
+		switch {
+		case s.Active:
+		case kept < keep-1:
+			kept++
+		default:
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// Prune 删除 Stale 返回的槽位，返回被删除的目录
+func (l Layout) Prune(keep int) ([]string, error) {
+	stale, err := l.Stale(keep)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, s := range stale {
+		if err := os.RemoveAll(s.Path); err != nil {
+			return removed, fmt.Errorf("remove %s: %w", s.Path, err)
+		}
+		_ = os.Remove(l.manifestPath(filepath.Base(s.Path)))
+		removed = append(removed, s.Path)
+	}
+	return removed, nil
+}
+
+func (l Layout) manifestPath(name string) string {
+	return filepath.Join(l.Dir, manifestsDir, name+".json")
+}
+
+// SaveManifest 保存安装到槽位时的完整清单，切换版本时用于更新已安装清单
+func (l Layout) SaveManifest(slot string, m *manifest.Manifest) error {
+	return m.Save(l.manifestPath(filepath.Base(slot)))
+}
+
+// SwitchedManifest 返回切换到 slot 后的已安装清单：链接下的文件取自该槽位的
+// 清单，其余映射保持 installed 中的记录。槽位没有清单时返回 nil。
+func (l Layout) SwitchedManifest(slot string, installed *manifest.Manifest) *manifest.Manifest {
+	sm, err := manifest.Load(l.manifestPath(filepath.Base(slot)))
+	if err != nil {
+		return nil
+	}
+	under := func(p string) bool { return p == l.Link || strings.HasPrefix(p, l.Link+"/") }
+	out := &manifest.Manifest{Schema: manifest.Schema, Version: sm.Version, Created: sm.Created}
+	if installed != nil {
+		for _, f := range installed.Files {
+			if !under(f.Target) {
+				out.Files = append(out.Files, f)
+			}
+		}
+	}
+	for _, f := range sm.Files {
+		if under(f.Target) {
+			out.Files = append(out.Files, f)
+		}
+	}
+	for _, f := range out.Files {
+		out.UnpackedSize += f.Size
+	}
+	return out
+}
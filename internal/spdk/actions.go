@@ -0,0 +1,264 @@
+package spdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"mimo/internal/transaction"
+)
+
+const (
+	undoRestartKind = "spdk.restart"
+	undoStopKind    = "spdk.stop"
+
+	savedConfigName = "spdk_config.json"
+	healthName      = "spdk_health.json"
+	// DefaultStartTimeout 等待 spdk_tgt 打开 RPC socket 的默认时间
+	DefaultStartTimeout = 60 * time.Second
+)
+
+// spdkHealth 为停止前采集的状态快照，重启后据此检查配置是否完整恢复
+var spdkHealth *Health
+
+// runState 是停止/重启动作写入事务日志的撤销数据
+type runState struct {
+	Cmd    string `json:"cmd"`
+	Config string `json:"config"`
+}
+
+func init() {
+	transaction.RegisterUndo(undoRestartKind, func(raw json.RawMessage) error {
+		var st runState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return restartPrevious(&st)
+	})
+	transaction.RegisterUndo(undoStopKind, func(json.RawMessage) error {
+		return stopRunning()
+	})
+}
+
+// RegisterStopAction 注册“保存配置并停止 spdk_tgt”动作。
+// Undo 使用保存的配置和原启动参数重新启动之前运行的 spdk_tgt。
+func RegisterStopAction(txn *transaction.Transaction) error {
+	if txn == nil {
+		return fmt.Errorf("nil transaction")
+	}
+	stateDir, err := txn.StateDir()
+	if err != nil {
+		return err
+	}
+	// 配置保存在事务目录中，断电后恢复时仍可使用
+	st := &runState{Config: filepath.Join(stateDir, savedConfigName)}
+
+	txn.Add(&transaction.Action{
+		Name: "stop MIMO and save config",
+		Kind: undoRestartKind,
+		State: func() (any, error) {
+			// 命令行在停止前采集，确保日志中有重启所需的全部信息
+			pid, err := socketPid()
+			if err != nil {
+				return nil, err
+			}
+			cmd, err := processArgs(pid)
+			if err != nil {
+				return nil, err
+			}
+			st.Cmd = cmd
+			return st, nil
+		},
+		Do: func() error {
+			spdkHealth = nil
+			if h, err := CaptureHealth(); err != nil {
+				fmt.Printf("WARN: cannot read bdev state, health check after restart is limited to the RPC socket: %v\n", err)
+			} else {
+				fmt.Printf("INFO: current state: %s\n", h.Summary())
+				spdkHealth = h
+				if data, err := json.MarshalIndent(h, "", "  "); err == nil {
+					_ = os.WriteFile(filepath.Join(stateDir, healthName), data, 0644)
+				}
+			}
+			cmd, err := saveConfigAndStop(st.Config)
+			if err != nil {
+				return err
+			}
+			st.Cmd = cmd
+			spdkOrigCmd = cmd
+			return waitSocketClosed(10 * time.Second)
+		},
+		Undo: func() error {
+			return restartPrevious(st)
+		},
+		Describe: func() []string {
+			pid, err := socketPid()
+			if err != nil {
+				return []string{fmt.Sprintf("cannot find MIMO process: %v", err)}
+			}
+			cmd, _ := processArgs(pid)
+			out := []string{
+				fmt.Sprintf("save config to %s", st.Config),
+				fmt.Sprintf("stop pid %d: %s", pid, cmd),
+			}
+			if h, err := CaptureHealth(); err == nil {
+				out = append(out, "record state for the health check: "+h.Summary())
+			}
+			return out
+		},
+	})
+	return nil
+}
+
+// RegisterRestartAction 注册“以保存的配置重启 spdk_tgt 并等待就绪”动作，
+// 必须在 RegisterStopAction 之后注册。就绪指 RPC socket 可连接，且停止前的
+// 每个 bdev 与 RAID 都已恢复；timeout 内未达到则动作失败，事务回滚。
+// Undo 停止新启动的进程。
+func RegisterRestartAction(txn *transaction.Transaction, timeout time.Duration) error {
+	if txn == nil {
+		return fmt.Errorf("nil transaction")
+	}
+	stateDir, err := txn.StateDir()
+	if err != nil {
+		return err
+	}
+	config := filepath.Join(stateDir, savedConfigName)
+
+	txn.Add(&transaction.Action{
+		Name: "restart MIMO with saved config",
+		Kind: undoStopKind,
+		Do: func() error {
+			if spdkOrigCmd == "" {
+				return fmt.Errorf("no original MIMO command captured")
+			}
+			deadline := time.Now().Add(timeout)
+			pid, err := startWithConfig(spdkOrigCmd, config)
+			if err != nil {
+				return err
+			}
+			if err := WaitReady(pid, timeout); err != nil {
+				return err
+			}
+			fmt.Println("INFO: MIMO is up")
+			if spdkHealth == nil {
+				return nil
+			}
+			fmt.Println("INFO: waiting for bdevs and RAID arrays to come back...")
+			if err := WaitHealthy(spdkHealth, deadline); err != nil {
+				fmt.Printf("ERROR: %v\n", err)
+				return err
+			}
+			return nil
+		},
+		Undo: stopRunning,
+		Describe: func() []string {
+			return []string{
+				"start spdk_tgt with the saved config and its previous arguments",
+				fmt.Sprintf("wait up to %s for %s and for every bdev and RAID array to come back", timeout, spdkSock),
+				"roll back to the previous version if they do not",
+			}
+		},
+	})
+	return nil
+}
+
+// restartPrevious 重新启动更新前运行的 spdk_tgt
+func restartPrevious(st *runState) error {
+	if st.Cmd == "" {
+		return nil
+	}
+	if _, err := os.Stat(st.Config); err != nil {
+		return fmt.Errorf("saved config unavailable: %w", err)
+	}
+	pid, err := startWithConfig(st.Cmd, st.Config)
+	if err != nil {
+		return err
+	}
+	if err := WaitReady(pid, DefaultStartTimeout); err != nil {
+		return err
+	}
+	// 回滚已完成，状态不一致只提示，由运维人员处理
+	var h Health
+	if data, err := os.ReadFile(filepath.Join(filepath.Dir(st.Config), healthName)); err == nil && json.Unmarshal(data, &h) == nil {
+		if err := WaitHealthy(&h, time.Now().Add(DefaultStartTimeout)); err != nil {
+			fmt.Printf("WARN: previous version restarted, but %v\n", err)
+		}
+	}
+	return nil
+}
+
+// Stop 停止正在运行的 spdk_tgt（不保存配置），未运行时返回 nil
+func Stop() error {
+	return stopRunning()
+}
+
+// stopRunning 停止当前监听 socket 的 spdk_tgt，未运行时返回 nil
+func stopRunning() error {
+	if !socketAlive() {
+		return nil
+	}
+	pid, err := socketPid()
+	if err != nil {
+		return err
+	}
+	if err := killPid(pid); err != nil {
+		return err
+	}
+	return waitSocketClosed(10 * time.Second)
+}
+
+// WaitReady 等待 spdk_tgt 的 RPC socket 可连接；pid 非 0 时若进程退出则立即失败
+func WaitReady(pid int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if socketAlive() {
+			return nil
+		}
+		if pid > 0 && !pidAlive(pid) {
+			return fmt.Errorf("MIMO process %d exited during startup", pid)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("MIMO did not open %s within %s", spdkSock, timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// IsRunning 报告是否有 spdk_tgt 在监听 RPC socket
+func IsRunning() bool {
+	return socketAlive()
+}
+
+// socketAlive 检查 RPC socket 是否有进程在监听（被 kill 的进程会留下 socket 文件）
+func socketAlive() bool {
+	conn, err := net.DialTimeout("unix", spdkSock, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func waitSocketClosed(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for socketAlive() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("MIMO still listening on %s", spdkSock)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return nil
+}
+
+func pidAlive(pid int) bool {
+	// 子进程退出后需要回收，否则会一直以僵尸状态存在
+	var ws syscall.WaitStatus
+	if wpid, _ := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); wpid == pid {
+		return false
+	}
+	return syscall.Kill(pid, 0) == nil
+}
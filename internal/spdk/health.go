@@ -0,0 +1,153 @@
+package spdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rpcTimeout 单次 JSON-RPC 调用的超时
+const rpcTimeout = 10 * time.Second
+
+// Health 是 spdk_tgt 的 bdev 与 RAID 状态快照，用于更新后确认配置已完整恢复
+type Health struct {
+	Bdevs []string    `json:"bdevs"`
+	Raids []RaidState `json:"raids"`
+}
+
+// RaidState 单个 RAID bdev 的状态（bdev_raid_get_bdevs 的子集）
+type RaidState struct {
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Level       string `json:"raid_level"`
+	BaseBdevs   int    `json:"num_base_bdevs"`
+	Discovered  int    `json:"num_base_bdevs_discovered"`
+	Operational int    `json:"num_base_bdevs_operational"`
+}
+
+type rpcRequest struct {
+	Version string `json:"jsonrpc"`
+	ID      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// rpcCall 通过 SPDK RPC socket 调用 method，将结果解码到 result
+func rpcCall(method string, params, result any) error {
+	conn, err := net.DialTimeout("unix", spdkSock, time.Second)
+	if err != nil {
+		return fmt.Errorf("connect %s: %w", spdkSock, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(rpcTimeout))
+
+	if err := json.NewEncoder(conn).Encode(rpcRequest{Version: "2.0", ID: 1, Method: method, Params: params}); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	var resp rpcResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("%s: read response: %w", method, err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %s (code %d)", method, resp.Error.Message, resp.Error.Code)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// CaptureHealth 通过 RPC 读取当前所有 bdev 与 RAID 的状态
+func CaptureHealth() (*Health, error) {
+	var bdevs []struct {
+		Name string `json:"name"`
+	}
+	if err := rpcCall("bdev_get_bdevs", nil, &bdevs); err != nil {
+		return nil, err
+	}
+	h := &Health{}
+	for _, b := range bdevs {
+		h.Bdevs = append(h.Bdevs, b.Name)
+	}
+	sort.Strings(h.Bdevs)
+
+	if err := rpcCall("bdev_raid_get_bdevs", map[string]string{"category": "all"}, &h.Raids); err != nil {
+		return nil, err
+	}
+	sort.Slice(h.Raids, func(i, j int) bool { return h.Raids[i].Name < h.Raids[j].Name })
+	return h, nil
+}
+
+// Problems 返回 now 相对于快照 h 缺失或降级的 bdev 与 RAID，空表示一致。
+// 更新后新增的 bdev 不视为问题。
+func (h *Health) Problems(now *Health) []string {
+	var out []string
+	have := map[string]bool{}
+	for _, b := range now.Bdevs {
+		have[b] = true
+	}
+	for _, b := range h.Bdevs {
+		if !have[b] {
+			out = append(out, fmt.Sprintf("bdev %s missing", b))
+		}
+	}
+	raids := map[string]RaidState{}
+	for _, r := range now.Raids {
+		raids[r.Name] = r
+	}
+	for _, was := range h.Raids {
+		r, ok := raids[was.Name]
+		switch {
+		case !ok:
+			out = append(out, fmt.Sprintf("raid %s (%s) missing", was.Name, was.Level))
+		case r.State != was.State:
+			out = append(out, fmt.Sprintf("raid %s is %s, was %s (%d/%d base bdevs operational)",
+				r.Name, r.State, was.State, r.Operational, r.BaseBdevs))
+		case r.Operational < was.Operational:
+			out = append(out, fmt.Sprintf("raid %s degraded: %d/%d base bdevs operational, was %d",
+				r.Name, r.Operational, r.BaseBdevs, was.Operational))
+		}
+	}
+	return out
+}
+
+// Summary 返回一行概要
+func (h *Health) Summary() string {
+	online := 0
+	for _, r := range h.Raids {
+		if r.State == "online" {
+			online++
+		}
+	}
+	return fmt.Sprintf("%d bdevs, %d RAID arrays (%d online)", len(h.Bdevs), len(h.Raids), online)
+}
+
+// WaitHealthy 轮询直到 bdev 与 RAID 状态与快照 want 一致；到达 deadline 时
+// 返回列出全部缺失项的错误
+func WaitHealthy(want *Health, deadline time.Time) error {
+	var problems []string
+	for {
+		now, err := CaptureHealth()
+		if err != nil {
+			problems = []string{err.Error()}
+		} else if problems = want.Problems(now); len(problems) == 0 {
+			fmt.Printf("INFO: health check passed: %s\n", now.Summary())
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("MIMO did not restore its previous state:\n  %s", strings.Join(problems, "\n  "))
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -30,9 +30,15 @@ func RestartSpdkWithSavedConfig() error {
 		fmt.Println("INFO: No original MIMO command captured; restart skipped.")
 		return nil
 	}
+	_, err := startWithConfig(spdkOrigCmd, spdkConfigPath)
+	return err
+}
 
+// startWithConfig 以 origCmd 的参数启动 spdk_tgt，并用 configPath 替换其 -c 参数。
+// 返回新进程 pid（未知时为 0）。
+func startWithConfig(origCmd, configPath string) (int, error) {
 	mimoRoot := env.EnsureMimoRoot()
-	cleanConfigPath := filepath.Clean(spdkConfigPath)
+	cleanConfigPath := filepath.Clean(configPath)
 
 	// Helper: remove "-c <file>" pair from original args and return remaining args
 	stripConfigArg := func(parts []string) string {
@@ -50,7 +56,7 @@ func RestartSpdkWithSavedConfig() error {
 		return strings.Join(newParts, " ")
 	}
 
-	parts := strings.Fields(spdkOrigCmd)
+	parts := strings.Fields(origCmd)
 	rest := stripConfigArg(parts)
 
 	newCmd := fmt.Sprintf("%s/%s -c %s", mimoRoot, spdkBinPath, cleanConfigPath)
@@ -63,60 +69,94 @@ func RestartSpdkWithSavedConfig() error {
 	bgCmd.Stdout = os.Stdout
 	bgCmd.Stderr = os.Stderr
 	if err := bgCmd.Start(); err != nil {
-		return fmt.Errorf("failed to restart MIMO: %w", err)
+		return 0, fmt.Errorf("failed to restart MIMO: %w", err)
 	}
 	if bgCmd.Process != nil {
 		fmt.Printf("INFO: MIMO restart initiated (pid=%d)\n", bgCmd.Process.Pid)
-	} else {
-		fmt.Println("INFO: MIMO restart initiated")
+		return bgCmd.Process.Pid, nil
 	}
-	return nil
+	fmt.Println("INFO: MIMO restart initiated")
+	return 0, nil
 }
 
 func SaveSpdkConfigAndGetCommand() error {
+	cmd, err := saveConfigAndStop(spdkConfigPath)
+	if err != nil {
+		return err
+	}
+	spdkOrigCmd = cmd
+	return nil
+}
+
+// socketPid 返回监听 SPDK socket 的进程 pid
+func socketPid() (int, error) {
 	out, err := exec.Command("lsof", "-t", spdkSock).Output()
 	if err != nil {
-		return fmt.Errorf("failed to check MIMO socket: %w", err)
+		return 0, fmt.Errorf("failed to check MIMO socket: %w", err)
 	}
 	pidStr := strings.TrimSpace(string(out))
 	if pidStr == "" {
-		return fmt.Errorf("no MIMO process found on socket")
+		return 0, fmt.Errorf("no MIMO process found on socket")
 	}
 
 	parts := strings.Fields(pidStr)
 	pid, err := strconv.Atoi(parts[0])
 	if err != nil {
-		return fmt.Errorf("failed to parse MIMO pid: %w", err)
+		return 0, fmt.Errorf("failed to parse MIMO pid: %w", err)
+	}
+	return pid, nil
+}
+
+// saveConfigAndStop 保存运行中 SPDK 的配置到 configPath 后停止进程，返回原启动命令
+func saveConfigAndStop(configPath string) (string, error) {
+	pid, err := socketPid()
+	if err != nil {
+		return "", err
 	}
 	fmt.Printf("INFO: MIMO process detected (pid=%d)\n", pid)
 
-	psOut, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "args=").Output()
+	origCmd, err := processArgs(pid)
 	if err != nil {
-		return fmt.Errorf("failed to obtain MIMO process info: %w", err)
+		return "", err
 	}
-	spdkOrigCmd = strings.TrimSpace(string(psOut))
 
 	// Step 1: Save configuration BEFORE killing the process
 	mimoRoot := env.EnsureMimoRoot()
 	rpcPath := filepath.Clean(filepath.Join(mimoRoot, scriptsDir, rpcScript))
 	if _, statErr := os.Stat(rpcPath); statErr != nil {
-		return fmt.Errorf("required helper not found: %w", statErr)
+		return "", fmt.Errorf("required helper not found: %w", statErr)
 	}
 
-	cmdLine := fmt.Sprintf("%s save_config -i 2 > %s", rpcPath, filepath.Clean(spdkConfigPath))
+	cmdLine := fmt.Sprintf("%s save_config -i 2 > %s", rpcPath, filepath.Clean(configPath))
 	saveCmd := exec.Command("bash", "-c", cmdLine)
 	saveCmd.Stdout = os.Stdout
 	saveCmd.Stderr = os.Stderr
 	if err := saveCmd.Run(); err != nil {
-		return fmt.Errorf("failed to save MIMO configuration: %w", err)
+		return "", fmt.Errorf("failed to save MIMO configuration: %w", err)
 	}
 	fmt.Printf("INFO: configuration saved\n")
 
 	// Step 2: Kill process AFTER saving config
 	fmt.Printf("INFO: stopping MIMO process\n")
+	if err := killPid(pid); err != nil {
+		return "", err
+	}
+	fmt.Printf("INFO: MIMO process stopped\n")
+	return origCmd, nil
+}
+
+// processArgs 返回进程的完整命令行
+func processArgs(pid int) (string, error) {
+	psOut, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "args=").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain MIMO process info: %w", err)
+	}
+	return strings.TrimSpace(string(psOut)), nil
+}
+
+func killPid(pid int) error {
 	if err := exec.Command("kill", "-9", strconv.Itoa(pid)).Run(); err != nil {
 		return fmt.Errorf("failed to stop MIMO process: %w", err)
 	}
-	fmt.Printf("INFO: MIMO process stopped\n")
 	return nil
 }
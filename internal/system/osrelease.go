@@ -0,0 +1,49 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+
+	"mimo/internal/originals"
+)
+
+const osReleasePath = "/etc/os-release"
+
+// OSRelease returns the fields of the host's os-release. MIMO replaces
+// /etc/os-release with its own branding, so the copy saved before the first
+// install is preferred; source names the file that was read.
+func OSRelease() (fields map[string]string, source string, err error) {
+	data, err := originals.Default().ReadFile(osReleasePath)
+	source = "original " + osReleasePath
+	if err != nil {
+		data, err = os.ReadFile(osReleasePath)
+		source = osReleasePath
+	}
+	if err != nil {
+		return nil, osReleasePath, err
+	}
+	return ParseOSRelease(data), source, nil
+}
+
+// ParseOSRelease parses the os-release format (KEY=value, optionally quoted)
+func ParseOSRelease(data []byte) map[string]string {
+	out := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if u, err := strconv.Unquote(v); err == nil {
+			v = u
+		} else {
+			v = strings.Trim(v, `'"`)
+		}
+		out[k] = v
+	}
+	return out
+}
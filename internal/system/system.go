@@ -1,46 +1,276 @@
 /*
 Changes:
-- Implement DisableCloudInit to stop/disable cloud-init related services and create a disabled marker file.
-- Does not fatal; returns error for caller to decide.
-- Outputs concise English messages when called by higher-level code.
+  - Disable cloud-init as a transaction action: the prior enable/active state of
+    each unit and the marker file are journaled, undo restores them.
+  - systemctl failures are returned instead of ignored.
+  - Outputs concise English messages when called by higher-level code.
 */
 package system
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+
+	"mimo/internal/transaction"
 )
 
-// DisableCloudInit stops and disables cloud-init services and creates marker file.
-// Returns error if marker creation fails or critical operations fail.
-func DisableCloudInit() error {
-	services := []string{
-		"cloud-init",
-		"cloud-final",
-		"cloud-config",
-		"cloud-init-local",
+var cloudInitServices = []string{
+	"cloud-init",
+	"cloud-final",
+	"cloud-config",
+	"cloud-init-local",
+}
+
+const cloudInitMarker = "/etc/cloud/cloud-init.disabled"
+
+// UnitState is a unit's state as systemctl reports it
+type UnitState struct {
+	Unit string `json:"unit"`
+	// Enabled is the is-enabled state: enabled, disabled, static, masked,
+	// or not-found when the unit is not installed
+	Enabled string `json:"enabled"`
+	// Active is the is-active state: active, inactive, failed, ...
+	Active string `json:"active"`
+}
+
+// Installed reports whether the unit exists
+func (u UnitState) Installed() bool {
+	return u.Enabled != "not-found"
+}
+
+// CloudInitState is the state of every cloud-init unit and of the marker
+// file that keeps cloud-init from running at boot
+type CloudInitState struct {
+	Units  []UnitState `json:"units"`
+	Marker bool        `json:"marker"`
+}
+
+// Disabled reports whether cloud-init will not run: the marker exists and
+// no installed unit is enabled or running
+func (st CloudInitState) Disabled() bool {
+	if !st.Marker {
+		return false
 	}
+	for _, u := range st.Units {
+		if isEnabled(u.Enabled) || isActive(u.Active) {
+			return false
+		}
+	}
+	return true
+}
 
-	for _, s := range services {
-		// stop and disable; ignore non-zero results but collect first error
-		_ = exec.Command("systemctl", "stop", s).Run()
-		_ = exec.Command("systemctl", "disable", s).Run()
+// Enabled reports whether nothing keeps cloud-init from running at boot:
+// no marker and no installed unit disabled
+func (st CloudInitState) Enabled() bool {
+	if st.Marker {
+		return false
+	}
+	for _, u := range st.Units {
+		if u.Enabled == "disabled" {
+			return false
+		}
 	}
+	return true
+}
+
+// Summary is "enabled", "disabled" or "partially disabled"
+func (st CloudInitState) Summary() string {
+	switch {
+	case st.Disabled():
+		return "disabled"
+	case st.Enabled():
+		return "enabled"
+	}
+	return "partially disabled"
+}
+
+func isEnabled(s string) bool {
+	return s == "enabled" || s == "enabled-runtime"
+}
 
-	// ensure dir exists
-	dir := "/etc/cloud"
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("create %s: %w", dir, err)
+func isActive(s string) bool {
+	return s == "active" || s == "activating" || s == "reloading"
+}
+
+// CloudInitStatus reads the current state of the cloud-init units
+func CloudInitStatus() CloudInitState {
+	st := CloudInitState{}
+	for _, s := range cloudInitServices {
+		u := UnitState{Unit: s}
+		enabled, _ := exec.Command("systemctl", "is-enabled", s).Output()
+		active, _ := exec.Command("systemctl", "is-active", s).Output()
+		u.Enabled = strings.TrimSpace(string(enabled))
+		u.Active = strings.TrimSpace(string(active))
+		if u.Enabled == "" {
+			u.Enabled = "not-found"
+		}
+		if u.Active == "" {
+			u.Active = "unknown"
+		}
+		st.Units = append(st.Units, u)
 	}
+	_, err := os.Stat(cloudInitMarker)
+	st.Marker = err == nil
+	return st
+}
 
-	marker := filepath.Join(dir, "cloud-init.disabled")
-	f, err := os.Create(marker)
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("create marker %s: %w", marker, err)
+		return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// EnableCloudInit removes the marker file and enables the cloud-init units
+// that are installed but disabled. Units are not started; cloud-init runs
+// on the next boot.
+func EnableCloudInit() error {
+	return CloudInitStatus().enable()
+}
+
+func (st CloudInitState) enable() error {
+	if err := os.Remove(cloudInitMarker); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove marker %s: %w", cloudInitMarker, err)
+	}
+	var errs []string
+	for _, u := range st.Units {
+		if u.Enabled == "disabled" {
+			if err := systemctl("enable", u.Unit); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("enable cloud-init: %s", strings.Join(errs, "; "))
 	}
-	defer f.Close()
-	_, _ = f.WriteString("disabled\n")
 	return nil
 }
+
+// disable stops and disables the units st found enabled or running and
+// creates the marker file
+func (st CloudInitState) disable() error {
+	var errs []string
+	for _, u := range st.Units {
+		if isEnabled(u.Enabled) {
+			if err := systemctl("disable", u.Unit); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if isActive(u.Active) {
+			if err := systemctl("stop", u.Unit); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("disable cloud-init: %s", strings.Join(errs, "; "))
+	}
+	if err := os.MkdirAll(filepath.Dir(cloudInitMarker), 0755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(cloudInitMarker), err)
+	}
+	if err := os.WriteFile(cloudInitMarker, []byte("disabled\n"), 0644); err != nil {
+		return fmt.Errorf("create marker %s: %w", cloudInitMarker, err)
+	}
+	return nil
+}
+
+// restore puts every unit back into the recorded state and removes the
+// marker file unless it existed before
+func (st CloudInitState) restore() error {
+	var errs []string
+	for _, u := range st.Units {
+		if !u.Installed() {
+			continue
+		}
+		switch {
+		case isEnabled(u.Enabled):
+			if err := systemctl("enable", u.Unit); err != nil {
+				errs = append(errs, err.Error())
+			}
+		case u.Enabled == "disabled":
+			if err := systemctl("disable", u.Unit); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if isActive(u.Active) {
+			if err := systemctl("start", u.Unit); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if st.Marker {
+		if err := os.WriteFile(cloudInitMarker, []byte("disabled\n"), 0644); err != nil {
+			errs = append(errs, err.Error())
+		}
+	} else if err := os.Remove(cloudInitMarker); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("restore cloud-init: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+const undoCloudInitKind = "system.cloud-init"
+
+func init() {
+	transaction.RegisterUndo(undoCloudInitKind, func(raw json.RawMessage) error {
+		var st CloudInitState
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return fmt.Errorf("decode undo state: %w", err)
+		}
+		return st.restore()
+	})
+}
+
+// RegisterCloudInitAction registers an action that disables cloud-init
+// (enable false) or turns it back on (enable true). The prior state of
+// every unit and of the marker is journaled, and undo restores it. Nothing
+// is registered and false is returned when cloud-init is already in the
+// wanted state.
+func RegisterCloudInitAction(txn *transaction.Transaction, enable bool) bool {
+	st := CloudInitStatus()
+	if (enable && st.Enabled()) || (!enable && st.Disabled()) {
+		return false
+	}
+	name, do := "disable cloud-init", st.disable
+	if enable {
+		name, do = "enable cloud-init", st.enable
+	}
+	txn.Add(&transaction.Action{
+		Name: name,
+		Kind: undoCloudInitKind,
+		State: func() (any, error) {
+			return st, nil
+		},
+		Describe: func() []string {
+			var out []string
+			for _, u := range st.Units {
+				if !u.Installed() {
+					continue
+				}
+				switch {
+				case !enable && (isEnabled(u.Enabled) || isActive(u.Active)):
+					out = append(out, fmt.Sprintf("stop and disable %s (currently %s, %s)", u.Unit, u.Enabled, u.Active))
+				case enable && u.Enabled == "disabled":
+					out = append(out, fmt.Sprintf("enable %s", u.Unit))
+				}
+			}
+			switch {
+			case !enable && !st.Marker:
+				out = append(out, "create "+cloudInitMarker)
+			case enable && st.Marker:
+				out = append(out, "remove "+cloudInitMarker)
+			}
+			return out
+		},
+		Do:   do,
+		Undo: st.restore,
+	})
+	return true
+}
@@ -3,6 +3,7 @@ Changes:
 - Implement EnableServices: collect .service files from file mappings, run daemon-reload once, then enable+start each service.
 - Non-fatal per-service: warn and continue. Returns error only if daemon-reload fails.
 - Keeps user-facing messages concise.
+- PlanServices describes the same work without touching the system (dry-run).
 */
 package systemd
 
@@ -17,19 +18,79 @@ import (
 	"mimo/internal/fileops"
 )
 
-func EnableServices(cfg *fileops.Config) error {
-	if cfg == nil {
-		return nil
-	}
-	services := make(map[string]struct{})
+// serviceUnits returns the .service units installed by cfg's mappings.
+// With installed set, only units whose destination exists are returned.
+func serviceUnits(cfg *fileops.Config, installed bool) []string {
+	var units []string
+	seen := make(map[string]struct{})
 	for _, m := range cfg.FileMappings {
 		dst := filepath.Clean(m.Dst)
-		if strings.HasSuffix(strings.ToLower(dst), ".service") {
-			if _, err := os.Stat(dst); err == nil {
-				services[filepath.Base(dst)] = struct{}{}
+		if !strings.HasSuffix(strings.ToLower(dst), ".service") {
+			continue
+		}
+		if installed {
+			if _, err := os.Stat(dst); err != nil {
+				continue
 			}
 		}
+		name := filepath.Base(dst)
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		units = append(units, name)
+	}
+	return units
+}
+
+// PlanServices describes which services EnableServices would enable and start.
+func PlanServices(cfg *fileops.Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	var out []string
+	for _, s := range serviceUnits(cfg, false) {
+		state, _ := exec.Command("systemctl", "is-enabled", s).Output()
+		cur := strings.TrimSpace(string(state))
+		if cur == "" {
+			cur = "not installed"
+		}
+		out = append(out, fmt.Sprintf("enable and start %s (currently %s)", s, cur))
+	}
+	return out
+}
+
+// DisableServices stops and disables the installed services of cfg's
+// mappings. Every unit is attempted; the error lists the ones that failed.
+func DisableServices(cfg *fileops.Config) error {
+	if cfg == nil {
+		return nil
+	}
+	var failed []string
+	for _, s := range serviceUnits(cfg, true) {
+		if out, err := exec.Command("systemctl", "disable", "--now", s).CombinedOutput(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", s, strings.TrimSpace(string(out))))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("disable failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// DaemonReload makes systemd pick up added or removed unit files.
+func DaemonReload() error {
+	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
+		return fmt.Errorf("daemon-reload failed: %w", err)
+	}
+	return nil
+}
+
+func EnableServices(cfg *fileops.Config) error {
+	if cfg == nil {
+		return nil
 	}
+	services := serviceUnits(cfg, true)
 	if len(services) == 0 {
 		return nil
 	}
@@ -39,7 +100,7 @@ func EnableServices(cfg *fileops.Config) error {
 		return fmt.Errorf("daemon-reload failed: %w", err)
 	}
 
-	for s := range services {
+	for _, s := range services {
 		// enable (synchronous)
 		if err := exec.Command("systemctl", "enable", s).Run(); err != nil {
 			log.Printf("WARN: enable %s failed", s)
@@ -0,0 +1,255 @@
+package transaction
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJournalRoot 事务日志的默认根目录
+const DefaultJournalRoot = "/var/lib/mimo/txn"
+
+const journalFile = "journal.jsonl"
+
+// kindNone 标记没有撤销操作的动作
+const kindNone = "none"
+
+const (
+	eventBegin          = "begin"
+	eventStart          = "start"
+	eventDone           = "done"
+	eventFailed         = "failed"
+	eventUndone         = "undone"
+	eventCommit         = "commit"
+	eventRolledBack     = "rolled-back"
+	eventRollbackFailed = "rollback-failed"
+)
+
+// Record 日志中的一行
+type Record struct {
+	Event  string          `json:"event"`
+	Time   time.Time       `json:"time"`
+	Name   string          `json:"name,omitempty"`
+	Index  int             `json:"index"`
+	Action string          `json:"action,omitempty"`
+	Kind   string          `json:"kind,omitempty"`
+	State  json.RawMessage `json:"state,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Journal 以追加方式记录事务进度，每条记录落盘后才继续执行
+type Journal struct {
+	dir string
+	f   *os.File
+}
+
+func createJournal(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create transaction dir %s: %w", dir, err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	return &Journal{dir: dir, f: f}, nil
+}
+
+// write 追加一条记录并 fsync；nil 日志为空操作
+func (j *Journal) write(r Record) error {
+	if j == nil || j.f == nil {
+		return nil
+	}
+	r.Time = time.Now().UTC()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode journal record: %w", err)
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	return nil
+}
+
+func (j *Journal) close() {
+	if j == nil || j.f == nil {
+		return
+	}
+	_ = j.f.Close()
+	j.f = nil
+}
+
+// remove 事务结束后删除日志及撤销数据
+func (j *Journal) remove() {
+	if j == nil {
+		return
+	}
+	j.close()
+	_ = os.RemoveAll(j.dir)
+}
+
+// ========== 进程外恢复 ==========
+
+var (
+	undoMu       sync.RWMutex
+	undoHandlers = map[string]func(state json.RawMessage) error{}
+)
+
+// RegisterUndo 注册 Kind 对应的撤销处理器，供 Pending.Rollback 使用。
+// 通常在各包的 init 中调用。
+func RegisterUndo(kind string, fn func(state json.RawMessage) error) {
+	undoMu.Lock()
+	defer undoMu.Unlock()
+	undoHandlers[kind] = fn
+}
+
+func undoHandler(kind string) func(state json.RawMessage) error {
+	undoMu.RLock()
+	defer undoMu.RUnlock()
+	return undoHandlers[kind]
+}
+
+// PendingAction 中断事务中已开始的动作
+type PendingAction struct {
+	Index  int
+	Name   string
+	Kind   string
+	State  json.RawMessage
+	Done   bool
+	Undone bool
+}
+
+// Pending 未完成（中断或回滚失败）的事务
+type Pending struct {
+	ID      string
+	Name    string
+	Started time.Time
+	Status  string
+	Actions []*PendingAction
+
+	dir string
+}
+
+// ListPending 列出 root 下未完成的事务，按开始时间排序
+func ListPending(root string) ([]*Pending, error) {
+	root = filepath.Clean(root)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read journal root: %w", err)
+	}
+	var out []*Pending
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		p, err := loadPending(filepath.Join(root, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].Started.Before(out[k].Started) })
+	return out, nil
+}
+
+// loadPending 解析日志，已提交或已回滚的事务返回 nil
+func loadPending(dir string) (*Pending, error) {
+	f, err := os.Open(filepath.Join(dir, journalFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open journal %s: %w", dir, err)
+	}
+	defer f.Close()
+
+	p := &Pending{ID: filepath.Base(dir), Status: "interrupted", dir: dir}
+	byIndex := map[int]*PendingAction{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			// 断电可能留下半行，忽略其后的内容
+			break
+		}
+		switch r.Event {
+		case eventBegin:
+			p.Name, p.Started = r.Name, r.Time
+		case eventStart:
+			a := &PendingAction{Index: r.Index, Name: r.Action, Kind: r.Kind, State: r.State}
+			byIndex[r.Index] = a
+			p.Actions = append(p.Actions, a)
+		case eventDone:
+			if a := byIndex[r.Index]; a != nil {
+				a.Done = true
+			}
+		case eventUndone:
+			if a := byIndex[r.Index]; a != nil {
+				a.Undone = true
+			}
+		case eventCommit, eventRolledBack:
+			return nil, nil
+		case eventRollbackFailed:
+			p.Status = "rollback-failed"
+		}
+	}
+	return p, nil
+}
+
+// Rollback 使用注册的撤销处理器按相反顺序撤销已开始的动作。
+// 全部成功后删除日志；否则保留以便重试。
+func (p *Pending) Rollback() error {
+	j, err := createJournal(p.dir)
+	if err != nil {
+		return err
+	}
+	defer j.close()
+
+	var errs []string
+	for i := len(p.Actions) - 1; i >= 0; i-- {
+		a := p.Actions[i]
+		if a.Undone || a.Kind == kindNone {
+			continue
+		}
+		fn := undoHandler(a.Kind)
+		if fn == nil {
+			errs = append(errs, fmt.Sprintf("%s: no recovery handler", a.Name))
+			continue
+		}
+		if err := fn(a.State); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", a.Name, err))
+			continue
+		}
+		a.Undone = true
+		_ = j.write(Record{Event: eventUndone, Index: a.Index})
+	}
+	if len(errs) > 0 {
+		_ = j.write(Record{Event: eventRollbackFailed, Error: strings.Join(errs, "; ")})
+		return fmt.Errorf("rollback errors: %s", strings.Join(errs, "; "))
+	}
+	_ = j.write(Record{Event: eventRolledBack})
+	j.remove()
+	return nil
+}
+
+// Discard 放弃该事务的日志，不做任何撤销
+func (p *Pending) Discard() error {
+	if err := os.RemoveAll(p.dir); err != nil {
+		return fmt.Errorf("remove journal %s: %w", p.dir, err)
+	}
+	return nil
+}
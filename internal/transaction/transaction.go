@@ -1,8 +1,15 @@
 package transaction
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Action 定义单个事务动作
@@ -10,22 +17,142 @@ type Action struct {
 	Name string
 	Do   func() error
 	Undo func() error
+
+	// Kind 指定进程外回滚时使用的撤销处理器（见 RegisterUndo）。
+	// 为空表示该动作只能在当前进程内回滚。
+	Kind string
+	// State 返回撤销所需的可序列化数据，在 Do 之前写入日志。
+	State func() (any, error)
+	// Describe 描述 Do 将做出的改动，不得产生副作用（用于 dry-run）。
+	Describe func() []string
+}
+
+// Step 状态
+const (
+	StepDone       = "done"
+	StepFailed     = "failed"
+	StepUndone     = "undone"
+	StepUndoFailed = "undo-failed"
+)
+
+// Step 记录一个已开始执行的动作及其最终状态（供更新历史使用）
+type Step struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
 // Transaction 管理多个动作
 type Transaction struct {
 	actions  []*Action
 	executed []*Action
+	steps    []Step
+	stepOf   map[*Action]int
+
+	id      string
+	name    string
+	journal *Journal
+	tempDir string
+	started bool
+}
+
+// RunError 描述失败的动作及回滚结果
+type RunError struct {
+	Action      string
+	Err         error
+	RollbackErr error
+}
+
+func (e *RunError) Error() string {
+	msg := fmt.Sprintf("action %q failed: %v", e.Action, e.Err)
+	if e.RollbackErr != nil {
+		msg += "; " + e.RollbackErr.Error()
+	}
+	return msg
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// RolledBack 报告失败后的回滚是否全部成功
+func (e *RunError) RolledBack() bool {
+	return e.RollbackErr == nil
 }
 
 // New 创建事务
 func New() *Transaction {
+	return NewNamed("")
+}
+
+// NewNamed 创建带名称的内存事务（名称用于日志和计划输出）
+func NewNamed(name string) *Transaction {
 	return &Transaction{
 		actions:  make([]*Action, 0),
 		executed: make([]*Action, 0),
+		id:       newID(),
+		name:     name,
 	}
 }
 
+// NewJournaled 创建带磁盘日志的事务，日志位于 root/<id>/ 下。
+// 进程中断后可通过 ListPending 找到该事务并回滚。
+func NewJournaled(root, name string) (*Transaction, error) {
+	t := NewNamed(name)
+	j, err := createJournal(filepath.Join(filepath.Clean(root), t.id))
+	if err != nil {
+		return nil, err
+	}
+	t.journal = j
+	return t, nil
+}
+
+// ID 返回事务标识
+func (t *Transaction) ID() string {
+	return t.id
+}
+
+// Name 返回事务名称
+func (t *Transaction) Name() string {
+	return t.name
+}
+
+// Steps 返回最近一次 Run 中开始执行的动作及其状态（包括回滚结果）
+func (t *Transaction) Steps() []Step {
+	return append([]Step(nil), t.steps...)
+}
+
+func (t *Transaction) setStep(a *Action, status string, err error) {
+	i, ok := t.stepOf[a]
+	if !ok {
+		return
+	}
+	t.steps[i].Status = status
+	switch {
+	case err == nil:
+	case t.steps[i].Error != "":
+		t.steps[i].Error += "; " + err.Error()
+	default:
+		t.steps[i].Error = err.Error()
+	}
+}
+
+// StateDir 返回事务的状态目录，供动作保存撤销数据。
+// 未启用日志时使用临时目录，Cleanup 时删除。
+func (t *Transaction) StateDir() (string, error) {
+	if t.journal != nil {
+		return t.journal.dir, nil
+	}
+	if t.tempDir == "" {
+		dir, err := os.MkdirTemp("", "mimo-txn-")
+		if err != nil {
+			return "", fmt.Errorf("create transaction dir: %w", err)
+		}
+		t.tempDir = dir
+	}
+	return t.tempDir, nil
+}
+
 // Add 添加动作
 func (t *Transaction) Add(a *Action) {
 	if a == nil {
@@ -37,42 +164,146 @@ func (t *Transaction) Add(a *Action) {
 // Run 执行事务，遇到错误则回滚已执行的动作并返回错误
 func (t *Transaction) Run() error {
 	t.executed = t.executed[:0]
-	for _, a := range t.actions {
+	t.steps = nil
+	t.stepOf = map[*Action]int{}
+	t.started = true
+	if err := t.journal.write(Record{Event: eventBegin, Name: t.name}); err != nil {
+		return err
+	}
+	for i, a := range t.actions {
 		if a == nil || a.Do == nil {
 			continue
 		}
-		if err := a.Do(); err != nil {
-			// 回滚已执行动作
-			_ = t.Rollback()
-			return fmt.Errorf("action %q failed: %w", a.Name, err)
+		rec := Record{Event: eventStart, Index: i, Action: a.Name, Kind: a.Kind}
+		if a.Undo == nil {
+			rec.Kind = kindNone
+		}
+		if a.State != nil {
+			st, err := a.State()
+			if err != nil {
+				return t.fail(a, fmt.Errorf("state: %w", err))
+			}
+			if rec.State, err = json.Marshal(st); err != nil {
+				return t.fail(a, fmt.Errorf("state: %w", err))
+			}
 		}
+		if err := t.journal.write(rec); err != nil {
+			return t.fail(a, err)
+		}
+		// 记录为已执行后再运行 Do，部分完成的动作同样需要撤销
 		t.executed = append(t.executed, a)
+		t.stepOf[a] = len(t.steps)
+		t.steps = append(t.steps, Step{Name: a.Name})
+		if err := a.Do(); err != nil {
+			_ = t.journal.write(Record{Event: eventFailed, Index: i, Error: err.Error()})
+			t.setStep(a, StepFailed, err)
+			return t.fail(a, err)
+		}
+		t.setStep(a, StepDone, nil)
+		if err := t.journal.write(Record{Event: eventDone, Index: i}); err != nil {
+			return t.fail(a, err)
+		}
 	}
+	if err := t.journal.write(Record{Event: eventCommit}); err != nil {
+		return err
+	}
+	t.journal.remove()
 	return nil
 }
 
+// fail 回滚已执行动作并返回 *RunError
+func (t *Transaction) fail(a *Action, err error) error {
+	return &RunError{Action: a.Name, Err: err, RollbackErr: t.Rollback()}
+}
+
 // Rollback 回滚已执行的动作（按相反顺序），返回合并错误或 nil
 func (t *Transaction) Rollback() error {
 	var errs []string
 	for i := len(t.executed) - 1; i >= 0; i-- {
 		a := t.executed[i]
-		if a == nil || a.Undo == nil {
+		if a == nil {
+			continue
+		}
+		if a.Undo == nil {
+			_ = t.journal.write(Record{Event: eventUndone, Index: t.indexOf(a)})
 			continue
 		}
 		if err := a.Undo(); err != nil {
 			errs = append(errs, fmt.Sprintf("%s: %v", a.Name, err))
+			t.setStep(a, StepUndoFailed, fmt.Errorf("undo: %w", err))
+			continue
 		}
+		_ = t.journal.write(Record{Event: eventUndone, Index: t.indexOf(a)})
+		t.setStep(a, StepUndone, nil)
 	}
 	// 清空已执行列表以避免重复回滚
 	t.executed = t.executed[:0]
 	if len(errs) > 0 {
+		// 保留日志，供 recover 重试
+		_ = t.journal.write(Record{Event: eventRollbackFailed, Error: strings.Join(errs, "; ")})
 		return fmt.Errorf("rollback errors: %s", strings.Join(errs, "; "))
 	}
+	if err := t.journal.write(Record{Event: eventRolledBack}); err == nil {
+		t.journal.remove()
+	}
 	return nil
 }
 
+// Plan 按执行顺序输出所有动作及其描述，不执行任何动作
+func (t *Transaction) Plan(w io.Writer) {
+	n := 0
+	for _, a := range t.actions {
+		if a != nil && a.Do != nil {
+			n++
+		}
+	}
+	title := t.name
+	if title == "" {
+		title = "transaction"
+	}
+	fmt.Fprintf(w, "PLAN: %s (%d actions)\n", title, n)
+	i := 0
+	for _, a := range t.actions {
+		if a == nil || a.Do == nil {
+			continue
+		}
+		i++
+		fmt.Fprintf(w, "  %2d. %s\n", i, a.Name)
+		if a.Describe == nil {
+			continue
+		}
+		for _, line := range a.Describe() {
+			fmt.Fprintf(w, "        %s\n", line)
+		}
+	}
+}
+
 // Cleanup 清理事务内部状态（释放引用）
 func (t *Transaction) Cleanup() {
+	if !t.started {
+		// 从未执行的事务不需要恢复
+		t.journal.remove()
+	}
+	t.journal.close()
+	if t.tempDir != "" {
+		_ = os.RemoveAll(t.tempDir)
+		t.tempDir = ""
+	}
 	t.actions = nil
 	t.executed = nil
 }
+
+func (t *Transaction) indexOf(a *Action) int {
+	for i, x := range t.actions {
+		if x == a {
+			return i
+		}
+	}
+	return -1
+}
+
+func newID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(b))
+}
@@ -0,0 +1,279 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder collects the order actions run and are undone in
+type recorder struct {
+	mu  sync.Mutex
+	log []string
+}
+
+func (r *recorder) add(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.log = append(r.log, s)
+}
+
+func (r *recorder) action(name string, fail bool) *Action {
+	return &Action{
+		Name: name,
+		Kind: "test.record",
+		State: func() (any, error) {
+			return name, nil
+		},
+		Do: func() error {
+			r.add("do " + name)
+			if fail {
+				return errors.New("boom")
+			}
+			return nil
+		},
+		Undo: func() error {
+			r.add("undo " + name)
+			return nil
+		},
+	}
+}
+
+func TestRunRollsBackInReverse(t *testing.T) {
+	root := t.TempDir()
+	txn, err := NewJournaled(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Cleanup()
+	r := &recorder{}
+	txn.Add(r.action("a", false))
+	txn.Add(&Action{Name: "no undo", Do: func() error { r.add("do no undo"); return nil }})
+	txn.Add(r.action("b", false))
+	txn.Add(r.action("c", true))
+	txn.Add(r.action("d", false))
+
+	err = txn.Run()
+	var re *RunError
+	if !errors.As(err, &re) {
+		t.Fatalf("Run() = %v, want *RunError", err)
+	}
+	if re.Action != "c" || !re.RolledBack() {
+		t.Errorf("RunError = %+v, want failed action c rolled back", re)
+	}
+	// the failed action may have done part of its work, so it is undone too
+	want := []string{"do a", "do no undo", "do b", "do c", "undo c", "undo b", "undo a"}
+	if !slices.Equal(r.log, want) {
+		t.Errorf("order = %q, want %q", r.log, want)
+	}
+
+	steps := map[string]Step{}
+	for _, s := range txn.Steps() {
+		steps[s.Name] = s
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if steps[name].Status != StepUndone {
+			t.Errorf("step %s = %q, want %q", name, steps[name].Status, StepUndone)
+		}
+	}
+	if steps["c"].Error != "boom" {
+		t.Errorf("step c error = %q, want the Do error", steps["c"].Error)
+	}
+	if _, ok := steps["d"]; ok {
+		t.Error("step d ran after the failure")
+	}
+
+	pending, err := ListPending(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("rolled back transaction is still pending: %+v", pending[0])
+	}
+}
+
+func TestRollbackFailureStaysPending(t *testing.T) {
+	root := t.TempDir()
+	txn, err := NewJournaled(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Cleanup()
+	a := (&recorder{}).action("a", false)
+	a.Undo = func() error { return errors.New("stuck") }
+	txn.Add(a)
+	txn.Add((&recorder{}).action("b", true))
+
+	var re *RunError
+	if err := txn.Run(); !errors.As(err, &re) || re.RolledBack() {
+		t.Fatalf("Run() = %v, want a failed rollback", err)
+	}
+	pending, err := ListPending(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Status != "rollback-failed" {
+		t.Fatalf("pending = %+v, want one rollback-failed transaction", pending)
+	}
+}
+
+// crash runs txn until an action's Do stops the goroutine, leaving the
+// journal as a killed process would: started actions, no commit record
+func crash(t *testing.T, txn *Transaction) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = txn.Run()
+		t.Error("transaction ran to the end")
+	}()
+	<-done
+	txn.Cleanup()
+}
+
+func crashAction(name, kind string) *Action {
+	return &Action{
+		Name:  name,
+		Kind:  kind,
+		State: func() (any, error) { return name, nil },
+		Do:    func() error { runtime.Goexit(); return nil },
+		Undo:  func() error { return nil },
+	}
+}
+
+func TestInterruptedIsPending(t *testing.T) {
+	root := t.TempDir()
+	committed, err := NewJournaled(root, "committed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed.Add((&recorder{}).action("ok", false))
+	if err := committed.Run(); err != nil {
+		t.Fatal(err)
+	}
+	committed.Cleanup()
+
+	txn, err := NewJournaled(root, "update-sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.Add((&recorder{}).action("a", false))
+	txn.Add(crashAction("b", "test.record"))
+	txn.Add((&recorder{}).action("c", false))
+	crash(t, txn)
+
+	pending, err := ListPending(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("ListPending = %d transactions, want 1", len(pending))
+	}
+	p := pending[0]
+	if p.ID != txn.ID() || p.Name != "update-sys" || p.Status != "interrupted" {
+		t.Errorf("pending = %s %s %s, want %s update-sys interrupted", p.ID, p.Name, p.Status, txn.ID())
+	}
+	if len(p.Actions) != 2 {
+		t.Fatalf("pending actions = %d, want 2 (c never started)", len(p.Actions))
+	}
+	if a := p.Actions[0]; a.Name != "a" || !a.Done || string(a.State) != `"a"` {
+		t.Errorf("action 0 = %+v, want a done", a)
+	}
+	if b := p.Actions[1]; b.Name != "b" || b.Done {
+		t.Errorf("action 1 = %+v, want b started but not done", b)
+	}
+}
+
+func TestPendingRollback(t *testing.T) {
+	var undone []string
+	RegisterUndo("test.pending", func(state json.RawMessage) error {
+		var name string
+		if err := json.Unmarshal(state, &name); err != nil {
+			return err
+		}
+		undone = append(undone, name)
+		return nil
+	})
+
+	root := t.TempDir()
+	txn, err := NewJournaled(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		a := (&recorder{}).action(name, false)
+		a.Kind = "test.pending"
+		txn.Add(a)
+	}
+	txn.Add(&Action{Name: "no undo", Do: func() error { return nil }})
+	txn.Add(crashAction("c", "test.pending"))
+	crash(t, txn)
+
+	pending, err := ListPending(root)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("ListPending = %v, %v; want one transaction", pending, err)
+	}
+	if err := pending[0].Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	// the interrupted action is undone first, the action without undo is skipped
+	if want := []string{"c", "b", "a"}; !slices.Equal(undone, want) {
+		t.Errorf("undone = %q, want %q", undone, want)
+	}
+	if pending, _ := ListPending(root); len(pending) != 0 {
+		t.Errorf("transaction still pending after rollback")
+	}
+}
+
+func TestPendingRollbackUnknownKind(t *testing.T) {
+	var undone []string
+	RegisterUndo("test.known", func(state json.RawMessage) error {
+		undone = append(undone, string(state))
+		return nil
+	})
+
+	root := t.TempDir()
+	txn, err := NewJournaled(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := (&recorder{}).action("known", false)
+	known.Kind = "test.known"
+	txn.Add(known)
+	txn.Add(crashAction("mystery", "test.unregistered"))
+	crash(t, txn)
+
+	pending, _ := ListPending(root)
+	if len(pending) != 1 {
+		t.Fatalf("ListPending = %d transactions, want 1", len(pending))
+	}
+	err = pending[0].Rollback()
+	if err == nil || !strings.Contains(err.Error(), "mystery: no recovery handler") {
+		t.Fatalf("Rollback = %v, want missing handler error", err)
+	}
+	// the other actions are still undone
+	if len(undone) != 1 {
+		t.Errorf("undone = %q, want the known action", undone)
+	}
+
+	// the journal is kept for another attempt, which only retries what is left
+	pending, _ = ListPending(root)
+	if len(pending) != 1 || pending[0].Status != "rollback-failed" {
+		t.Fatalf("pending = %+v, want one rollback-failed transaction", pending)
+	}
+	for _, a := range pending[0].Actions {
+		if a.Name == "known" && !a.Undone {
+			t.Error("known action not recorded as undone")
+		}
+	}
+	if err := pending[0].Rollback(); err == nil {
+		t.Error("second Rollback succeeded without a handler")
+	}
+	if len(undone) != 1 {
+		t.Errorf("known action undone again: %q", undone)
+	}
+}
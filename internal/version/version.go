@@ -0,0 +1,113 @@
+// Package version parses and compares MIMO semantic versions and carries the
+// CLI build version.
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BuildVersion 为 CLI 的构建版本，由打包脚本通过 -ldflags "-X" 注入
+var BuildVersion = "dev"
+
+// Version 语义化版本（MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]）
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 []string
+	raw                 string
+}
+
+// Parse 解析版本字符串，允许前缀 "v"，缺省的 MINOR/PATCH 视为 0
+func Parse(s string) (Version, error) {
+	v := Version{raw: s}
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if str == "" {
+		return v, fmt.Errorf("empty version")
+	}
+	// build metadata does not affect precedence
+	if i := strings.IndexByte(str, '+'); i >= 0 {
+		str = str[:i]
+	}
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		if i == len(str)-1 {
+			return v, fmt.Errorf("invalid version %q: empty pre-release", s)
+		}
+		v.Pre = strings.Split(str[i+1:], ".")
+		str = str[:i]
+	}
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// String 返回原始字符串
+func (v Version) String() string {
+	if v.raw != "" {
+		return v.raw
+	}
+	s := fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	return s
+}
+
+// Compare 按语义化版本优先级比较，返回 -1、0 或 1
+func Compare(a, b Version) int {
+	for _, d := range [][2]int{{a.Major, b.Major}, {a.Minor, b.Minor}, {a.Patch, b.Patch}} {
+		if d[0] != d[1] {
+			return cmpInt(d[0], d[1])
+		}
+	}
+	// a version without pre-release has higher precedence
+	switch {
+	case len(a.Pre) == 0 && len(b.Pre) == 0:
+		return 0
+	case len(a.Pre) == 0:
+		return 1
+	case len(b.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.Pre) && i < len(b.Pre); i++ {
+		if c := comparePre(a.Pre[i], b.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	return cmpInt(len(a.Pre), len(b.Pre))
+}
+
+// comparePre 比较单个 pre-release 标识：数字按数值，数字低于字母
+func comparePre(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return cmpInt(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}